@@ -0,0 +1,83 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsProtocol is the subprotocol a websocket handshake negotiates
+	wsProtocol = "users"
+	// wsTokenProtocolPrefix carries the token on a websocket handshake since browsers can't set headers on it
+	wsTokenProtocolPrefix = "bearer."
+)
+
+var (
+	errUnauthorized  = errors.New("unauthorized")
+	errAdminDisabled = errors.New("admin endpoints are disabled without tokens")
+)
+
+// adminOnly lets through only requests with one of the tokens in the Authorization header
+// and nothing at all when there are no tokens
+func (s *Server) adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.tokens) == 0 {
+			s.respondNotOK(w, http.StatusForbidden, errAdminDisabled)
+			return
+		}
+
+		if !s.validToken(bearerToken(r)) {
+			s.respondNotOK(w, http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// authorized lets through requests with one of the tokens, as long as there are any.
+// A websocket handshake may carry the token as a subprotocol.
+func (s *Server) authorized(r *http.Request) bool {
+	if len(s.tokens) == 0 {
+		return true
+	}
+
+	token := bearerToken(r)
+	if token == "" && websocket.IsWebSocketUpgrade(r) {
+		token = wsProtocolToken(r)
+	}
+
+	return s.validToken(token)
+}
+
+func (s *Server) validToken(token string) bool {
+	if token == "" {
+		return false
+	}
+
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func wsProtocolToken(r *http.Request) string {
+	for _, p := range websocket.Subprotocols(r) {
+		if token, ok := strings.CutPrefix(p, wsTokenProtocolPrefix); ok {
+			return token
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/storage"
+)
+
+const (
+	pinPrimaryHeader = "X-Read-Primary-Until"
+	pinPrimaryCookie = "read_primary_until"
+)
+
+type pinCtxKey struct{}
+
+// primaryPin tells readYourWrites whether the request writes something
+type primaryPin struct {
+	write bool
+}
+
+// markWrite overrides the guess readYourWrites makes from the method, for endpoints like GraphQL
+// which take reads and writes the same way
+func markWrite(ctx context.Context, write bool) {
+	if pin, ok := ctx.Value(pinCtxKey{}).(*primaryPin); ok {
+		pin.write = write
+	}
+}
+
+// readYourWrites pins a client to the primary for a while after it successfully writes something, so its next reads
+// don't land on a replica which hasn't caught up yet. The deadline in unix milliseconds is handed out
+// both as a cookie and as a header for clients without a cookie jar, they send it back the same way.
+func (s *Server) readYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		if s.pinnedToPrimary(r, now) {
+			r = r.WithContext(storage.WithPrimary(r.Context()))
+		}
+
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		pin := &primaryPin{write: true}
+		r = r.WithContext(context.WithValue(r.Context(), pinCtxKey{}, pin))
+		pw := &pinningWriter{ResponseWriter: w, pin: pin, until: now.Add(s.pinPrimaryFor), pinFor: s.pinPrimaryFor}
+		next.ServeHTTP(pw, r)
+		if !pw.wroteHeader {
+			// the handler had nothing to say, which is an implicit 200
+			pw.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+// pinnedToPrimary ignores deadlines further away than pinPrimaryFor, those weren't handed out by the server
+func (s *Server) pinnedToPrimary(r *http.Request, now time.Time) bool {
+	raw := r.Header.Get(pinPrimaryHeader)
+	if c, err := r.Cookie(pinPrimaryCookie); raw == "" && err == nil {
+		raw = c.Value
+	}
+
+	until, err := strconv.ParseInt(raw, 10, 64)
+	return err == nil && now.UnixMilli() < until && until <= now.Add(s.pinPrimaryFor).UnixMilli()
+}
+
+// pinningWriter hands out the pin along with a successful response
+type pinningWriter struct {
+	http.ResponseWriter
+	pin         *primaryPin
+	until       time.Time
+	pinFor      time.Duration
+	wroteHeader bool
+}
+
+func (w *pinningWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if w.pin.write && status < http.StatusBadRequest {
+			w.setPin()
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *pinningWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *pinningWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *pinningWriter) setPin() {
+	until := strconv.FormatInt(w.until.UnixMilli(), 10)
+	w.Header().Set(pinPrimaryHeader, until)
+	http.SetCookie(w, &http.Cookie{
+		Name:     pinPrimaryCookie,
+		Value:    until,
+		Path:     "/",
+		MaxAge:   int(w.pinFor.Round(time.Second).Seconds()) + 1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadYourWrites(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{pinPrimaryFor: time.Minute}
+	h := s.readYourWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/graphql":
+			markWrite(r.Context(), false)
+			_, _ = w.Write([]byte("{}"))
+		}
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/users/42", nil))
+	until := rec.Header().Get(pinPrimaryHeader)
+	require.NotEmpty(t, until)
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, pinPrimaryCookie, cookies[0].Name)
+	assert.Equal(t, until, cookies[0].Value)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+	assert.Empty(t, rec.Header().Get(pinPrimaryHeader), "reads don't pin")
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/users/missing", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Empty(t, rec.Header().Get(pinPrimaryHeader), "failed writes don't pin")
+	assert.Empty(t, rec.Result().Cookies())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", nil))
+	assert.Empty(t, rec.Header().Get(pinPrimaryHeader), "graphql queries don't pin")
+
+	now := time.Now()
+	r := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	assert.False(t, s.pinnedToPrimary(r, now))
+
+	r.Header.Set(pinPrimaryHeader, until)
+	assert.True(t, s.pinnedToPrimary(r, now))
+	assert.False(t, s.pinnedToPrimary(r, now.Add(2*time.Minute)), "the pin expires")
+
+	r.Header.Set(pinPrimaryHeader, strconv.FormatInt(now.Add(time.Hour).UnixMilli(), 10))
+	assert.False(t, s.pinnedToPrimary(r, now), "pins longer than pinPrimaryFor aren't ours")
+
+	r = httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	r.AddCookie(cookies[0])
+	assert.True(t, s.pinnedToPrimary(r, now))
+
+	r.Header.Set(pinPrimaryHeader, strconv.Itoa(0))
+	assert.False(t, s.pinnedToPrimary(r, now), "the header takes precedence")
+}
+
+func TestIsMutation(t *testing.T) {
+	t.Parallel()
+
+	const doc = `query Read { user(id: "42") { id } } mutation Write { deleteUser(id: "42") }`
+	parsed, err := parser.Parse(parser.ParseParams{Source: doc})
+	require.NoError(t, err)
+
+	assert.False(t, isMutation(parsed, "Read"))
+	assert.True(t, isMutation(parsed, "Write"))
+
+	parsed, err = parser.Parse(parser.ParseParams{Source: `{ user(id: "42") { id } }`})
+	require.NoError(t, err)
+	assert.False(t, isMutation(parsed, ""))
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/gorilla/mux"
+)
+
+type countResponse struct {
+	Count int `json:"count"`
+}
+
+func (s *Server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r.URL.Query())
+	if err == nil {
+		err = checkPage(limit, offset)
+	}
+	if err != nil {
+		s.respondNotOK(w, http.StatusBadRequest, err)
+		return
+	}
+
+	items, more, err := fetchPage(limit, func(limit int) ([]entity.DeadLetter, error) {
+		return s.repo.DeadLetters(r.Context(), limit, offset)
+	})
+	if err != nil {
+		s.respondNotOK(w, http.StatusInternalServerError, fmt.Errorf("list dead letters: %w", err))
+		return
+	}
+
+	s.respondOK(w, http.StatusOK, entity.DeadLetterPage{Items: items, Limit: limit, Offset: offset, HasMore: more})
+}
+
+func (s *Server) getDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := deadLetterID(r)
+	if err != nil {
+		s.respondNotOK(w, http.StatusBadRequest, err)
+		return
+	}
+
+	d, err := s.repo.DeadLetterByID(r.Context(), id)
+	if err != nil {
+		s.respondNotOK(w, statusByErr(err), deadLetterErr(id, err))
+		return
+	}
+
+	s.respondOK(w, http.StatusOK, d)
+}
+
+func (s *Server) replayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id, err := deadLetterID(r)
+	if err != nil {
+		s.respondNotOK(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.repo.ReplayDeadLetter(r.Context(), id); err != nil {
+		s.respondNotOK(w, statusByErr(err), deadLetterErr(id, err))
+		return
+	}
+
+	s.kickRelay()
+	s.respondOK(w, http.StatusOK, countResponse{Count: 1})
+}
+
+func (s *Server) replayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	n, err := s.repo.ReplayDeadLetters(r.Context())
+	if err != nil {
+		s.respondNotOK(w, http.StatusInternalServerError, fmt.Errorf("replay dead letters: %w", err))
+		return
+	}
+
+	s.kickRelay()
+	s.respondOK(w, http.StatusOK, countResponse{Count: n})
+}
+
+func (s *Server) purgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	n, err := s.repo.PurgeDeadLetters(r.Context())
+	if err != nil {
+		s.respondNotOK(w, http.StatusInternalServerError, fmt.Errorf("purge dead letters: %w", err))
+		return
+	}
+
+	s.respondOK(w, http.StatusOK, countResponse{Count: n})
+}
+
+func deadLetterID(r *http.Request) (int64, error) {
+	raw := mux.Vars(r)["id"]
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid dead letter id %q", raw)
+	}
+
+	return id, nil
+}
+
+func deadLetterErr(id int64, err error) error {
+	if errors.Is(err, entity.ErrNotFound) {
+		return fmt.Errorf("dead letter %d not found", id)
+	}
+
+	return fmt.Errorf("dead letter %d: %w", id, err)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type deadLettersRepo struct {
+	repo
+	count int
+}
+
+func (r *deadLettersRepo) CountDeadLetters(context.Context) (int, error) {
+	return r.count, nil
+}
+
+func (r *deadLettersRepo) PurgeDeadLetters(context.Context) (int, error) {
+	n := r.count
+	r.count = 0
+	return n, nil
+}
+
+func TestPurgeDeadLettersNeedsToken(t *testing.T) {
+	t.Parallel()
+
+	r := &deadLettersRepo{count: 2}
+	testSrv := httptest.NewServer(setupRouter(&Server{repo: r, tokens: []string{"secret"}}))
+	defer testSrv.Close()
+
+	purge := func(token string) (int, string) {
+		req, err := http.NewRequest(http.MethodDelete, testSrv.URL+"/admin/dead-letters", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, _ := purge("")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, 2, r.count)
+
+	status, _ = purge("wrong")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, 2, r.count)
+
+	status, body := purge("secret")
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"count":2}`, body)
+	assert.Zero(t, r.count)
+}
+
+func TestAdminDisabledWithoutTokens(t *testing.T) {
+	t.Parallel()
+
+	r := &deadLettersRepo{count: 2}
+	testSrv := httptest.NewServer(setupRouter(&Server{repo: r}))
+	defer testSrv.Close()
+
+	req, err := http.NewRequest(http.MethodDelete, testSrv.URL+"/admin/dead-letters", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer ")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, 2, r.count)
+}
+
+func TestDeadLettersMetric(t *testing.T) {
+	t.Parallel()
+
+	testSrv := httptest.NewServer(setupRouter(&Server{repo: &deadLettersRepo{count: 7}}))
+	defer testSrv.Close()
+
+	resp, err := http.Get(testSrv.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "users_dead_letters 7\n")
+}
@@ -0,0 +1,19 @@
+package entity
+
+import "time"
+
+// DeadLetter is a notification the changelog or a webhook didn't accept even after all the retries
+type DeadLetter struct {
+	ID        int64     `json:"id"`
+	Event     UserEvent `json:"event"`
+	LastError string    `json:"last_error"`
+	Attempts  int       `json:"attempts"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+type DeadLetterPage struct {
+	Items   []DeadLetter `json:"items"`
+	Limit   int          `json:"limit"`
+	Offset  int          `json:"offset"`
+	HasMore bool         `json:"has_more"`
+}
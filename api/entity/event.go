@@ -0,0 +1,49 @@
+package entity
+
+import "time"
+
+type EventType string
+
+const (
+	EventUserCreated EventType = "CREATED"
+	EventUserUpdated EventType = "UPDATED"
+	EventUserDeleted EventType = "DELETED"
+)
+
+type UserEvent struct {
+	Seq       int64     `json:"seq"`
+	Type      EventType `json:"type"`
+	User      User      `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+	// UserSeq numbers the changes of a user one by one starting from 1 in the order they were committed,
+	// so a gap means a missed event and a number seen before means a duplicate
+	UserSeq int64 `json:"user_seq"`
+	// Before is the user prior to the change, notifications about updates carry it
+	Before *User `json:"before,omitempty"`
+	// Changed lists JSON names of the fields the update changed
+	Changed []string `json:"changed,omitempty"`
+	// TxID is the transaction which recorded the event in the log, see EventPosition
+	TxID int64 `json:"-"`
+	// HeldBack tells the previous event of the user comes later in the log, this one goes right after it
+	HeldBack bool `json:"-"`
+	// HoldsBack tells the next event of the user comes earlier in the log and waits for this one
+	HoldsBack bool `json:"-"`
+}
+
+// Position is where the event is in the log
+func (e UserEvent) Position() EventPosition {
+	return EventPosition{TxID: e.TxID, Seq: e.Seq}
+}
+
+// EventPosition orders the event log: by the transaction which recorded the event, then by seq.
+// The seqs of a log read in this order aren't always increasing, as a transaction may take its seq
+// before an older one does.
+type EventPosition struct {
+	TxID int64
+	Seq  int64
+}
+
+// After tells whether p comes after o in the log
+func (p EventPosition) After(o EventPosition) bool {
+	return p.TxID > o.TxID || (p.TxID == o.TxID && p.Seq > o.Seq)
+}
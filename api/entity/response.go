@@ -7,6 +7,6 @@ import (
 
 func CloseBody(c io.Closer) {
 	if err := c.Close(); err != nil {
-		slog.Error("closing response body", err)
+		slog.Error("closing response body", "err", err)
 	}
 }
@@ -8,3 +8,59 @@ type User struct {
 	LastName  string    `json:"last_name"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+// UserUpdate is the user before and after an update
+type UserUpdate struct {
+	Before User
+	After  User
+}
+
+// Changed lists JSON names of the fields the update changed
+func (u UserUpdate) Changed() []string {
+	changed := []string{}
+	if u.Before.FirstName != u.After.FirstName {
+		changed = append(changed, "first_name")
+	}
+	if u.Before.LastName != u.After.LastName {
+		changed = append(changed, "last_name")
+	}
+
+	return changed
+}
+
+type UserFilter struct {
+	// Name matches a part of the first or the last name, empty matches everyone
+	Name   string
+	Limit  int
+	Offset int
+}
+
+type UserPage struct {
+	Items   []User `json:"items"`
+	Limit   int    `json:"limit"`
+	Offset  int    `json:"offset"`
+	HasMore bool   `json:"has_more"`
+}
+
+type UserSearch struct {
+	// Query is matched fuzzily against the full name, so "jon doe" finds "John Doe"
+	Query  string
+	Limit  int
+	Offset int
+	// Highlight marks the matched words of the HTML-escaped full name with <mark> tags
+	Highlight bool
+}
+
+// UserMatch is a user found by a search, the higher the score the better it matches
+type UserMatch struct {
+	User
+	Score     float64 `json:"score"`
+	Highlight string  `json:"highlight,omitempty"`
+}
+
+type UserSearchPage struct {
+	Items   []UserMatch `json:"items"`
+	Limit   int         `json:"limit"`
+	Offset  int         `json:"offset"`
+	HasMore bool        `json:"has_more"`
+}
@@ -0,0 +1,19 @@
+package entity
+
+import "time"
+
+// Webhook is a subscription to user events, every matching event is posted to its URL
+type Webhook struct {
+	ID         string      `json:"id"`
+	URL        string      `json:"url"`
+	EventTypes []EventType `json:"event_types"`
+	// Format is the shape of the posted notifications: legacy, cloudevents or cloudevents-binary
+	Format string `json:"format"`
+	// Secret is shown only once, when the webhook is created or its secret is rotated
+	Secret string `json:"secret,omitempty"`
+	// PreviousSecret still signs deliveries for a while after a rotation, so the receiver has time to move on
+	PreviousSecret          string     `json:"-"`
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	Active                  bool       `json:"active"`
+	CreatedAt               time.Time  `json:"created_at"`
+}
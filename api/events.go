@@ -0,0 +1,208 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+)
+
+const (
+	sseBufferSize     = 64
+	sseReplayPageSize = 100
+	sseRetryMillis    = 3000
+)
+
+var (
+	sseHeartbeatInterval = 15 * time.Second
+	sseWriteTimeout      = 5 * time.Second
+)
+
+func (s *Server) streamUserEvents(w http.ResponseWriter, r *http.Request) {
+	lastSeq, err := lastEventID(r)
+	if err != nil {
+		s.respondNotOK(w, http.StatusBadRequest, err)
+		return
+	}
+
+	match := userIDFilter(r.URL.Query()["user_id"])
+
+	// subscribe before replaying so that nothing published in between gets lost
+	sub := s.events.subscribe(sseBufferSize)
+	defer s.events.unsubscribe(sub)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if err := s.writeSSE(rc, w, fmt.Sprintf("retry: %d\n\n", sseRetryMillis)); err != nil {
+		return
+	}
+
+	// the log is read in the order of transactions, where seqs don't always grow, so the stream goes by positions
+	var last entity.EventPosition
+	if lastSeq > 0 {
+		if last, err = s.repo.UserEventPosition(r.Context(), lastSeq); err != nil {
+			slog.Error("looking for the last event id", "seq", lastSeq, "err", err)
+			return
+		}
+		if err := s.replayHeldBack(rc, w, r, lastSeq, match); err != nil {
+			slog.Error("replaying user events", "err", err)
+			return
+		}
+		if last, err = s.replayUserEvents(rc, w, r, last, match); err != nil {
+			slog.Error("replaying user events", "err", err)
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	// a held back event is published right after the one it waits for, so it's new when that one is
+	var fresh bool
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if err := s.writeSSE(rc, w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-sub.events:
+			if !ok {
+				// either the server is shutting down or the client was too slow to keep up,
+				// it'll reconnect and resume with Last-Event-ID
+				return
+			}
+
+			if !e.HeldBack {
+				if fresh = e.Position().After(last); fresh {
+					last = e.Position()
+				}
+			}
+			if !fresh || !match(e) {
+				continue
+			}
+
+			if err := s.writeUserEvent(rc, w, e); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) replayUserEvents(rc *http.ResponseController, w http.ResponseWriter, r *http.Request, after entity.EventPosition, match func(entity.UserEvent) bool) (entity.EventPosition, error) {
+	for {
+		events, err := s.repo.UserEventsAfter(r.Context(), after, sseReplayPageSize)
+		if err != nil {
+			return after, fmt.Errorf("load events after %d: %w", after.Seq, err)
+		}
+
+		for _, e := range events {
+			after = e.Position()
+			if !match(e) {
+				continue
+			}
+
+			inOrder, err := s.inUserOrder(r.Context(), e)
+			if err != nil {
+				return after, err
+			}
+			for _, e := range inOrder {
+				if err := s.writeUserEvent(rc, w, e); err != nil {
+					return after, err
+				}
+			}
+		}
+
+		if len(events) < sseReplayPageSize {
+			return after, nil
+		}
+	}
+}
+
+// replayHeldBack sends the events held back behind the last one the client got, reading the log on
+// from its position skips them
+func (s *Server) replayHeldBack(rc *http.ResponseController, w http.ResponseWriter, r *http.Request, seq int64, match func(entity.UserEvent) bool) error {
+	held, err := s.repo.UserEventsHeldBack(r.Context(), seq)
+	if err != nil {
+		return fmt.Errorf("load events held back behind %d: %w", seq, err)
+	}
+
+	for _, e := range held {
+		if !match(e) {
+			continue
+		}
+		if err := s.writeUserEvent(rc, w, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) writeUserEvent(rc *http.ResponseController, w http.ResponseWriter, e entity.UserEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode event %d: %w", e.Seq, err)
+	}
+
+	frame := fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", e.Seq, strings.ToLower(string(e.Type)), data)
+	return s.writeSSE(rc, w, frame)
+}
+
+func (s *Server) writeSSE(rc *http.ResponseController, w http.ResponseWriter, frame string) error {
+	// a stuck consumer must not hold the handler forever
+	if err := rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+
+	if _, err := w.Write([]byte(frame)); err != nil {
+		return err
+	}
+
+	return rc.Flush()
+}
+
+func lastEventID(r *http.Request) (int64, error) {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("last_event_id")
+	}
+	if raw == "" {
+		return 0, nil
+	}
+
+	seq, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, fmt.Errorf("invalid last event id %q", raw)
+	}
+
+	return seq, nil
+}
+
+func userIDFilter(ids []string) func(entity.UserEvent) bool {
+	if len(ids) == 0 {
+		return func(entity.UserEvent) bool { return true }
+	}
+
+	wanted := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		wanted[id] = struct{}{}
+	}
+
+	return func(e entity.UserEvent) bool {
+		_, ok := wanted[e.User.ID]
+		return ok
+	}
+}
@@ -0,0 +1,291 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type sseFrame struct {
+	id    string
+	event string
+	data  string
+}
+
+type sseStream struct {
+	resp   *http.Response
+	reader *bufio.Reader
+	cancel context.CancelFunc
+}
+
+func (s *srvSuite) openEventStream(url string, lastEventID int64) *sseStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(s.T(), err)
+
+	if lastEventID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(lastEventID, 10))
+	}
+
+	// no client timeout here: the stream is supposed to stay open
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	require.Equal(s.T(), "text/event-stream", resp.Header.Get("Content-Type"))
+
+	return &sseStream{resp: resp, reader: bufio.NewReader(resp.Body), cancel: cancel}
+}
+
+func (st *sseStream) close() {
+	st.cancel()
+	entity.CloseBody(st.resp.Body)
+}
+
+// next returns the next frame carrying an event, skipping comments and retry hints
+func (st *sseStream) next(t *testing.T) sseFrame {
+	frameCh := make(chan sseFrame, 1)
+	go func() {
+		var f sseFrame
+		for {
+			line, err := st.reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case line == "":
+				if f.data != "" {
+					frameCh <- f
+					return
+				}
+			case strings.HasPrefix(line, "id: "):
+				f.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				f.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				f.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for an event")
+		return sseFrame{}
+	case f := <-frameCh:
+		return f
+	}
+}
+
+func (s *srvSuite) TestUserEventsStream() {
+	var cl mockedChangelog
+	cl.On("UserCreated", mock.Anything).Return(nil)
+	cl.On("UserUpdated", mock.Anything).Return(nil)
+
+	srvURL, closer := s.setupServer(&cl)
+	defer closer()
+
+	stream := s.openEventStream(srvURL+"/users/events", 0)
+	defer stream.close()
+
+	userCreated, err := s.createTestUser(srvURL, entity.User{FirstName: "Luke", LastName: "Skywalker"})
+	require.NoError(s.T(), err)
+
+	frame := stream.next(s.T())
+	assert.Equal(s.T(), "created", frame.event)
+
+	var e entity.UserEvent
+	require.NoError(s.T(), json.Unmarshal([]byte(frame.data), &e))
+	assert.Equal(s.T(), frame.id, strconv.FormatInt(e.Seq, 10))
+	assert.Equal(s.T(), entity.EventUserCreated, e.Type)
+	assert.Equal(s.T(), userCreated.ID, e.User.ID)
+}
+
+func (s *srvSuite) TestUserEventsResume() {
+	var cl mockedChangelog
+	cl.On("UserCreated", mock.Anything).Return(nil)
+
+	srvURL, closer := s.setupServer(&cl)
+	defer closer()
+
+	stream := s.openEventStream(srvURL+"/users/events", 0)
+	first, err := s.createTestUser(srvURL, entity.User{FirstName: "Leia", LastName: "Organa"})
+	require.NoError(s.T(), err)
+
+	var firstEvent entity.UserEvent
+	require.NoError(s.T(), json.Unmarshal([]byte(stream.next(s.T()).data), &firstEvent))
+	require.Equal(s.T(), first.ID, firstEvent.User.ID)
+	stream.close()
+
+	// this one happens while nobody is listening
+	second, err := s.createTestUser(srvURL, entity.User{FirstName: "Ben", LastName: "Solo"})
+	require.NoError(s.T(), err)
+
+	require.Eventually(s.T(), func() bool {
+		pos, err := s.repo.UserEventPosition(context.Background(), firstEvent.Seq)
+		if err != nil {
+			return false
+		}
+		events, err := s.repo.UserEventsAfter(context.Background(), pos, 100)
+		return err == nil && len(events) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	resumed := s.openEventStream(srvURL+"/users/events?user_id="+second.ID, firstEvent.Seq)
+	defer resumed.close()
+
+	var replayed entity.UserEvent
+	require.NoError(s.T(), json.Unmarshal([]byte(resumed.next(s.T()).data), &replayed))
+	assert.Equal(s.T(), second.ID, replayed.User.ID)
+	assert.Greater(s.T(), replayed.Seq, firstEvent.Seq)
+}
+
+func TestEventPositionAfter(t *testing.T) {
+	t.Parallel()
+
+	pos := entity.EventPosition{TxID: 10, Seq: 5}
+	assert.True(t, entity.EventPosition{TxID: 10, Seq: 6}.After(pos))
+	assert.True(t, entity.EventPosition{TxID: 11, Seq: 1}.After(pos), "a later transaction may have taken a lower seq")
+	assert.False(t, entity.EventPosition{TxID: 9, Seq: 7}.After(pos))
+	assert.False(t, pos.After(pos))
+}
+
+func TestEventHubDropsSlowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	var h eventHub
+	slow := h.subscribe(1)
+	fast := h.subscribe(2)
+
+	h.publish(entity.UserEvent{Seq: 1})
+	h.publish(entity.UserEvent{Seq: 2})
+
+	e, ok := <-slow.events
+	require.True(t, ok)
+	assert.EqualValues(t, 1, e.Seq)
+
+	_, ok = <-slow.events
+	assert.False(t, ok, "slow subscriber must be dropped")
+
+	assert.EqualValues(t, 1, (<-fast.events).Seq)
+	assert.EqualValues(t, 2, (<-fast.events).Seq)
+
+	h.close()
+	_, ok = <-fast.events
+	assert.False(t, ok)
+
+	// unsubscribing after being dropped is a no-op
+	h.unsubscribe(slow)
+	h.unsubscribe(fast)
+}
+
+// interleavedLogRepo is the log after two transactions touched user B: the older one (xid 10) changed A,
+// waited while the younger one (xid 11) changed B and committed, then changed B too
+type interleavedLogRepo struct {
+	repo
+}
+
+func (interleavedLogRepo) UserEventsAfter(_ context.Context, after entity.EventPosition, _ int) ([]entity.UserEvent, error) {
+	log := []entity.UserEvent{
+		{Seq: 1, TxID: 10, UserSeq: 1, User: entity.User{ID: "A"}},
+		{Seq: 3, TxID: 10, UserSeq: 6, User: entity.User{ID: "B"}, HeldBack: true},
+		{Seq: 2, TxID: 11, UserSeq: 5, User: entity.User{ID: "B"}, HoldsBack: true},
+	}
+
+	var res []entity.UserEvent
+	for _, e := range log {
+		if e.Position().After(after) {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+func (interleavedLogRepo) UserEventsHeldBack(_ context.Context, seq int64) ([]entity.UserEvent, error) {
+	if seq != 2 {
+		return nil, nil
+	}
+	return []entity.UserEvent{{Seq: 3, TxID: 10, UserSeq: 6, User: entity.User{ID: "B"}, HeldBack: true}}, nil
+}
+
+func TestEventTailHoldsBackUntilPreviousOfUser(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{repo: interleavedLogRepo{}}
+	sub := srv.events.subscribe(10)
+
+	after := srv.publishEventsAfter(context.Background(), tailCursor{started: true})
+	assert.Equal(t, entity.EventPosition{TxID: 11, Seq: 2}, after.pos)
+
+	srv.events.close()
+	var got []string
+	for e := range sub.events {
+		got = append(got, e.User.ID+"#"+strconv.FormatInt(e.UserSeq, 10))
+	}
+	assert.Equal(t, []string{"A#1", "B#5", "B#6"}, got)
+}
+
+func (s *srvSuite) TestUserEventsOfInterleavedTransactions() {
+	var cl mockedChangelog
+	cl.On("UserCreated", mock.Anything).Return(nil)
+	cl.On("UserUpdated", mock.Anything).Return(nil)
+
+	srvURL, closer := s.setupServer(&cl)
+	defer closer()
+
+	ctx := context.Background()
+	a, err := s.repo.InsertUser(ctx, entity.User{FirstName: "Padme", LastName: "Amidala"})
+	s.Require().NoError(err)
+	b, err := s.repo.InsertUser(ctx, entity.User{FirstName: "Anakin", LastName: "Skywalker"})
+	s.Require().NoError(err)
+
+	stream := s.openEventStream(srvURL+"/users/events?user_id="+b.ID, 0)
+	defer stream.close()
+
+	// the older transaction takes its xid with the first write, the younger one numbers its change of b first
+	olderWrote, youngerDone := make(chan struct{}), make(chan struct{})
+	olderErr := make(chan error, 1)
+	go func() {
+		olderErr <- s.repo.WithTx(ctx, func(ctx context.Context) error {
+			if _, err := s.repo.UpdateUser(ctx, a.ID, entity.User{FirstName: "Padme", LastName: "Naberrie"}); err != nil {
+				return err
+			}
+			close(olderWrote)
+			<-youngerDone
+			_, err := s.repo.UpdateUser(ctx, b.ID, entity.User{FirstName: "Darth", LastName: "Vader"})
+			return err
+		})
+	}()
+
+	<-olderWrote
+	_, err = s.repo.UpdateUser(ctx, b.ID, entity.User{FirstName: "Ani", LastName: "Skywalker"})
+	s.Require().NoError(err)
+	close(youngerDone)
+	s.Require().NoError(<-olderErr)
+
+	var younger, older entity.UserEvent
+	s.Require().NoError(json.Unmarshal([]byte(stream.next(s.T()).data), &younger))
+	s.Require().NoError(json.Unmarshal([]byte(stream.next(s.T()).data), &older))
+	s.EqualValues(2, younger.UserSeq)
+	s.Equal("Ani", younger.User.FirstName)
+	s.EqualValues(3, older.UserSeq)
+	s.Equal("Darth", older.User.FirstName)
+
+	// resuming after either of them goes on in the order of user_seq too
+	resumed := s.openEventStream(srvURL+"/users/events?user_id="+b.ID, younger.Seq)
+	defer resumed.close()
+
+	var replayed entity.UserEvent
+	s.Require().NoError(json.Unmarshal([]byte(resumed.next(s.T()).data), &replayed))
+	s.Equal(older.Seq, replayed.Seq)
+}
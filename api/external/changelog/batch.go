@@ -0,0 +1,153 @@
+package changelog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/notifyformat"
+)
+
+// ErrNotSent is the outcome of a notification left unsent since an earlier one of the batch failed,
+// sending it would get it ahead of that one
+var ErrNotSent = errors.New("notification not sent after an earlier one failed")
+
+// maxBatchResponseSize limits how much of a partial-failure response is read
+const maxBatchResponseSize = 1 << 20
+
+// unsupportedBatchStatuses tell the receiver doesn't take batches, it gets notifications one by one from then on
+var unsupportedBatchStatuses = []int{
+	http.StatusNotFound,
+	http.StatusMethodNotAllowed,
+	http.StatusUnsupportedMediaType,
+	http.StatusNotImplemented,
+}
+
+// BatchPolicy controls sending several notifications in one request
+type BatchPolicy struct {
+	// MaxSize is the most notifications in a request, 1 or less disables batching
+	MaxSize int
+	// MaxWait is how long notifications may wait for others to fill a batch up
+	MaxWait time.Duration
+	// Format is a JSON array by default
+	Format notifyformat.BatchFormat
+}
+
+// batchResponse is what the receiver may answer a batch with in a 207 Multi-Status response:
+// a result for every notification in the order of the batch, the ones without a 2xx status are sent again one by one
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+type batchResult struct {
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (r batchResponse) accepted(i int) bool {
+	return i < len(r.Results) && r.Results[i].Status >= 200 && r.Results[i].Status < 300
+}
+
+// WithBatching turns batching on, notifications are sent one by one by default
+func WithBatching(p BatchPolicy) Option {
+	return func(n *RestNotifier) {
+		n.batch = p
+	}
+}
+
+// Batching tells how notifications are batched, MaxSize is zero while they're sent one by one:
+// either batching is off or the receiver doesn't take batches
+func (n *RestNotifier) Batching() BatchPolicy {
+	if n.batch.MaxSize <= 1 || n.batchUnsupported.Load() {
+		return BatchPolicy{}
+	}
+
+	return n.batch
+}
+
+// NotifyBatch sends the events in order in batches of at most MaxSize and tells the outcome of each of them,
+// nil for the delivered ones. A batch is retried as a whole. The notifications a receiver rejected in
+// a partial-failure response, as well as the ones of a batch it's given up on, are sent again one by one.
+// Once a notification fails for good the rest of them are ErrNotSent.
+func (n *RestNotifier) NotifyBatch(ctx context.Context, events []entity.UserEvent) []error {
+	errs := make([]error, len(events))
+	size := max(n.Batching().MaxSize, 1)
+	for start := 0; start < len(events); start += size {
+		end := min(start+size, len(events))
+		if !n.notifyBatch(ctx, events[start:end], errs[start:end]) {
+			fillErrs(errs[end:], ErrNotSent)
+			break
+		}
+	}
+
+	return errs
+}
+
+// notifyBatch sends a single batch, it's false unless every notification is delivered
+func (n *RestNotifier) notifyBatch(ctx context.Context, events []entity.UserEvent, errs []error) bool {
+	if n.Batching().MaxSize <= 1 {
+		return n.notifyEach(ctx, events, errs, batchResponse{})
+	}
+
+	body, header, err := n.encoder.EncodeBatch(events, n.batch.Format)
+	if err != nil {
+		fillErrs(errs, err)
+		return false
+	}
+
+	partial, err := n.postWithRetries(ctx, body, header, true)
+	switch {
+	case err == nil && partial == nil:
+		return true
+	case err == nil:
+		var resp batchResponse
+		if err := json.Unmarshal(partial, &resp); err != nil {
+			slog.Warn("unreadable partial-failure response, sending the batch one by one", "size", len(events), "err", err)
+		}
+		return n.notifyEach(ctx, events, errs, resp)
+	case errors.Is(err, ErrCircuitOpen) || ctx.Err() != nil:
+		fillErrs(errs, err)
+		return false
+	}
+
+	var se *statusError
+	if errors.As(err, &se) && slices.Contains(unsupportedBatchStatuses, se.code) {
+		n.batchUnsupported.Store(true)
+		slog.Warn("the changelog doesn't take batches, sending notifications one by one", "err", err)
+	} else {
+		slog.Warn("batch failed, sending it one by one", "size", len(events), "err", err)
+	}
+
+	return n.notifyEach(ctx, events, errs, batchResponse{})
+}
+
+// notifyEach sends the events the receiver hasn't accepted yet one by one until one of them fails
+func (n *RestNotifier) notifyEach(ctx context.Context, events []entity.UserEvent, errs []error, resp batchResponse) bool {
+	for i, e := range events {
+		if resp.accepted(i) {
+			continue
+		}
+
+		if errs[i] = n.notify(ctx, e); errs[i] != nil {
+			for j := i + 1; j < len(events); j++ {
+				if !resp.accepted(j) {
+					errs[j] = ErrNotSent
+				}
+			}
+			return false
+		}
+	}
+
+	return true
+}
+
+func fillErrs(errs []error, err error) {
+	for i := range errs {
+		errs[i] = err
+	}
+}
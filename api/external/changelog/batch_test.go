@@ -0,0 +1,162 @@
+package changelog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/notifyformat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type receivedNotification struct {
+	Seq int64 `json:"seq"`
+}
+
+// batchReceiver hands every request to answer along with the seqs of the notifications in it,
+// batch tells whether they came in an array or alone
+func batchReceiver(t *testing.T, answer func(w http.ResponseWriter, seqs []int64, batch bool)) *httptest.Server {
+	t.Helper()
+
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var items []receivedNotification
+		batch := bytes.HasPrefix(body, []byte("["))
+		if batch {
+			require.NoError(t, json.Unmarshal(body, &items))
+		} else {
+			var item receivedNotification
+			require.NoError(t, json.Unmarshal(body, &item))
+			items = append(items, item)
+		}
+
+		seqs := make([]int64, 0, len(items))
+		for _, item := range items {
+			seqs = append(seqs, item.Seq)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		answer(w, seqs, batch)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func eventsFrom(seq, n int) []entity.UserEvent {
+	events := make([]entity.UserEvent, 0, n)
+	for i := 0; i < n; i++ {
+		events = append(events, entity.UserEvent{Seq: int64(seq + i), Type: entity.EventUserCreated, User: entity.User{ID: "42"}})
+	}
+	return events
+}
+
+func TestNotifyBatch(t *testing.T) {
+	t.Parallel()
+
+	var requests [][]int64
+	srv := batchReceiver(t, func(_ http.ResponseWriter, seqs []int64, batch bool) {
+		assert.True(t, batch)
+		requests = append(requests, seqs)
+	})
+	n := New(srv.URL, WithRetry(fastRetry), WithBatching(BatchPolicy{MaxSize: 2}))
+
+	errs := n.NotifyBatch(context.Background(), eventsFrom(1, 5))
+	assert.Equal(t, make([]error, 5), errs)
+	assert.Equal(t, [][]int64{{1, 2}, {3, 4}, {5}}, requests)
+}
+
+func TestNotifyBatchNDJSON(t *testing.T) {
+	t.Parallel()
+
+	var lines int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		lines = bytes.Count(body, []byte("\n"))
+	}))
+	defer srv.Close()
+
+	n := New(srv.URL, WithBatching(BatchPolicy{MaxSize: 10, Format: notifyformat.BatchNDJSON}))
+	assert.Equal(t, make([]error, 3), n.NotifyBatch(context.Background(), eventsFrom(1, 3)))
+	assert.Equal(t, 3, lines)
+}
+
+func TestNotifyBatchResendsRejected(t *testing.T) {
+	t.Parallel()
+
+	var singles []int64
+	srv := batchReceiver(t, func(w http.ResponseWriter, seqs []int64, batch bool) {
+		if !batch {
+			singles = append(singles, seqs...)
+			return
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		require.NoError(t, json.NewEncoder(w).Encode(batchResponse{Results: []batchResult{
+			{Status: http.StatusOK},
+			{Status: http.StatusServiceUnavailable, Error: "busy"},
+			{Status: http.StatusAccepted},
+		}}))
+	})
+	n := New(srv.URL, WithRetry(fastRetry), WithBatching(BatchPolicy{MaxSize: 10}))
+
+	assert.Equal(t, make([]error, 3), n.NotifyBatch(context.Background(), eventsFrom(1, 3)))
+	assert.Equal(t, []int64{2}, singles, "only the rejected one goes again")
+}
+
+func TestNotifyBatchStopsAfterFailure(t *testing.T) {
+	t.Parallel()
+
+	srv := batchReceiver(t, func(w http.ResponseWriter, seqs []int64, batch bool) {
+		if !batch {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusMultiStatus)
+		require.NoError(t, json.NewEncoder(w).Encode(batchResponse{Results: []batchResult{
+			{Status: http.StatusBadRequest},
+			{Status: http.StatusOK},
+		}}))
+	})
+	n := New(srv.URL, WithRetry(fastRetry), WithBatching(BatchPolicy{MaxSize: 2}))
+
+	errs := n.NotifyBatch(context.Background(), eventsFrom(1, 4))
+	assert.EqualError(t, errs[0], "unexpected response-code 400")
+	assert.NoError(t, errs[1], "accepted in the batch")
+	assert.ErrorIs(t, errs[2], ErrNotSent)
+	assert.ErrorIs(t, errs[3], ErrNotSent)
+}
+
+func TestNotifyBatchFallsBackToSingles(t *testing.T) {
+	t.Parallel()
+
+	var batches, singles int
+	srv := batchReceiver(t, func(w http.ResponseWriter, seqs []int64, batch bool) {
+		if batch {
+			batches++
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		singles++
+	})
+	n := New(srv.URL, WithRetry(fastRetry), WithBatching(BatchPolicy{MaxSize: 2}))
+
+	assert.Equal(t, make([]error, 4), n.NotifyBatch(context.Background(), eventsFrom(1, 4)))
+	assert.Equal(t, 1, batches, "the receiver isn't asked for batches again")
+	assert.Equal(t, 4, singles)
+	assert.Zero(t, n.Batching().MaxSize)
+}
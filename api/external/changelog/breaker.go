@@ -0,0 +1,172 @@
+package changelog
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without reaching the receiver while it's considered down
+var ErrCircuitOpen = errors.New("changelog circuit is open")
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// BreakerPolicy controls when the receiver is considered down
+type BreakerPolicy struct {
+	// FailureThreshold is the number of failed posts in a row that opens the circuit, 0 disables the breaker
+	FailureThreshold int
+	// CoolDown is how long the circuit stays open before a probe is let through
+	CoolDown time.Duration
+	// SuccessThreshold is the number of successful probes in a row that closes the circuit again
+	SuccessThreshold int
+}
+
+var DefaultBreakerPolicy = BreakerPolicy{
+	FailureThreshold: 5,
+	CoolDown:         30 * time.Second,
+	SuccessThreshold: 1,
+}
+
+// Circuit is a snapshot of the breaker
+type Circuit struct {
+	State CircuitState
+	// OpenUntil is when the next probe is let through, it's set only for the open circuit
+	OpenUntil time.Time
+	// Changes counts state changes since the start
+	Changes uint64
+}
+
+// breaker lets posts through while the circuit is closed, fails them fast while it's open
+// and lets a single probe at a time through while it's half-open
+type breaker struct {
+	policy BreakerPolicy
+	now    func() time.Time
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	successes int
+	openUntil time.Time
+	probing   probe
+	probes    uint64
+	changes   uint64
+}
+
+// probe is the token of the single post let through a half-open circuit, zero stands for a regular post
+type probe uint64
+
+func newBreaker(p BreakerPolicy) *breaker {
+	return &breaker{
+		policy: p,
+		now:    time.Now,
+		state:  CircuitClosed,
+	}
+}
+
+// allow tells whether a post may go and hands out the probe token when it's the probe,
+// every allowed post must be followed by done with the token it got
+func (b *breaker) allow() (probe, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Before(b.openUntil) {
+			return 0, ErrCircuitOpen
+		}
+		b.setState(CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if b.probing != 0 {
+			return 0, ErrCircuitOpen
+		}
+		b.probes++
+		b.probing = probe(b.probes)
+		return b.probing, nil
+	}
+
+	return 0, nil
+}
+
+// done records the outcome of an allowed post, p is the token allow gave it
+func (b *breaker) done(ctx context.Context, p probe, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen {
+		// a late outcome of a post let through before the circuit opened
+		return
+	}
+
+	if b.state == CircuitHalfOpen {
+		if p == 0 || p != b.probing {
+			// a late outcome of a post let through while the circuit was closed, it's no probe
+			return
+		}
+		b.probing = 0
+	}
+
+	switch {
+	case ctx.Err() != nil || (err != nil && !receiverFailure(err)):
+		// the outcome says nothing about the receiver, a half-open circuit lets another probe through
+	case err == nil && b.state == CircuitHalfOpen:
+		b.successes++
+		if b.successes >= max(b.policy.SuccessThreshold, 1) {
+			b.setState(CircuitClosed)
+		}
+	case err == nil:
+		b.failures = 0
+	case b.state == CircuitHalfOpen:
+		b.open()
+	default:
+		b.failures++
+		if b.failures >= b.policy.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+func (b *breaker) open() {
+	b.openUntil = b.now().Add(b.policy.CoolDown)
+	b.setState(CircuitOpen)
+}
+
+func (b *breaker) setState(s CircuitState) {
+	slog.Warn("changelog circuit state changed", "from", b.state, "to", s)
+
+	b.state = s
+	b.failures = 0
+	b.successes = 0
+	b.changes++
+	if s != CircuitOpen {
+		b.openUntil = time.Time{}
+	}
+}
+
+func (b *breaker) circuit() Circuit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return Circuit{State: b.state, OpenUntil: b.openUntil, Changes: b.changes}
+}
+
+// receiverFailure tells whether err says something bad about the receiver's health,
+// a request it rejected as bad doesn't
+func receiverFailure(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code >= http.StatusInternalServerError ||
+			se.code == http.StatusTooManyRequests || se.code == http.StatusRequestTimeout
+	}
+
+	return true
+}
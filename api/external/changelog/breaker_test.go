@@ -0,0 +1,135 @@
+package changelog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pass lets a post through the breaker and records its outcome
+func pass(t *testing.T, b *breaker, ctx context.Context, err error, msgAndArgs ...interface{}) {
+	t.Helper()
+
+	p, allowErr := b.allow()
+	require.NoError(t, allowErr, msgAndArgs...)
+	b.done(ctx, p, err)
+}
+
+func TestBreakerStates(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 11, 5, 10, 0, 0, 0, time.UTC)
+	b := newBreaker(BreakerPolicy{FailureThreshold: 2, CoolDown: time.Minute, SuccessThreshold: 2})
+	b.now = func() time.Time { return now }
+	ctx := context.Background()
+	down := &statusError{code: http.StatusServiceUnavailable}
+
+	pass(t, b, ctx, down)
+	pass(t, b, ctx, nil)
+	pass(t, b, ctx, down)
+	assert.Equal(t, CircuitClosed, b.circuit().State, "failures must come in a row")
+
+	pass(t, b, ctx, down)
+	assert.Equal(t, Circuit{State: CircuitOpen, OpenUntil: now.Add(time.Minute), Changes: 1}, b.circuit())
+	_, err := b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	now = now.Add(time.Minute)
+	p, err := b.allow()
+	require.NoError(t, err, "a probe goes after the cool-down")
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen, "one probe at a time")
+	b.done(ctx, p, down)
+	assert.Equal(t, CircuitOpen, b.circuit().State, "a failed probe opens the circuit again")
+
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		pass(t, b, ctx, nil)
+	}
+	assert.Equal(t, Circuit{State: CircuitClosed, Changes: 5}, b.circuit())
+}
+
+func TestBreakerIgnoresRejectedNotifications(t *testing.T) {
+	t.Parallel()
+
+	b := newBreaker(BreakerPolicy{FailureThreshold: 1, CoolDown: time.Minute})
+	pass(t, b, context.Background(), &statusError{code: http.StatusBadRequest})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pass(t, b, ctx, errors.New("context canceled"))
+
+	assert.Equal(t, CircuitClosed, b.circuit().State)
+}
+
+func TestBreakerProbeSaysNothing(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 11, 5, 10, 0, 0, 0, time.UTC)
+	b := newBreaker(BreakerPolicy{FailureThreshold: 1, CoolDown: time.Minute})
+	b.now = func() time.Time { return now }
+
+	pass(t, b, context.Background(), &statusError{code: http.StatusServiceUnavailable})
+	now = now.Add(time.Minute)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	pass(t, b, cancelled, nil)
+	assert.Equal(t, CircuitHalfOpen, b.circuit().State, "a cancelled probe neither closes the circuit")
+
+	pass(t, b, context.Background(), &statusError{code: http.StatusBadRequest}, "nor keeps the next probe waiting")
+	assert.Equal(t, CircuitHalfOpen, b.circuit().State, "a rejected probe doesn't close it either")
+
+	pass(t, b, context.Background(), nil)
+	assert.Equal(t, CircuitClosed, b.circuit().State)
+}
+
+func TestBreakerLatePostIsNoProbe(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 11, 5, 10, 0, 0, 0, time.UTC)
+	b := newBreaker(BreakerPolicy{FailureThreshold: 1, CoolDown: time.Minute})
+	b.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	late, err := b.allow()
+	require.NoError(t, err)
+	pass(t, b, ctx, &statusError{code: http.StatusServiceUnavailable})
+	now = now.Add(time.Minute)
+
+	p, err := b.allow()
+	require.NoError(t, err)
+	b.done(ctx, late, nil)
+	assert.Equal(t, CircuitHalfOpen, b.circuit().State, "a post let through while closed doesn't close the circuit")
+	_, err = b.allow()
+	assert.ErrorIs(t, err, ErrCircuitOpen, "nor lets another probe through")
+
+	b.done(ctx, p, nil)
+	assert.Equal(t, CircuitClosed, b.circuit().State)
+}
+
+func TestOpenCircuitFailsFast(t *testing.T) {
+	t.Parallel()
+
+	srv, calls := failingReceiver(t, 100, http.StatusServiceUnavailable, nil)
+	n := New(srv.URL, WithRetry(fastRetry), WithBreaker(BreakerPolicy{FailureThreshold: 3, CoolDown: time.Hour}))
+
+	err := n.UserCreated(context.Background(), userCreated)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	var counted *attemptsError
+	require.ErrorAs(t, err, &counted)
+	assert.Equal(t, 3, counted.Attempts())
+	assert.EqualValues(t, 3, calls.Load())
+
+	err = n.UserCreated(context.Background(), userCreated)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	assert.EqualValues(t, 3, calls.Load(), "the receiver isn't bothered while the circuit is open")
+	assert.Equal(t, CircuitOpen, n.Circuit().State)
+
+	assert.Equal(t, CircuitClosed, New(srv.URL, WithBreaker(BreakerPolicy{})).Circuit().State)
+}
@@ -2,60 +2,185 @@ package changelog
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/api/external/notification"
+	"github.com/andyklimenko/testify-usage-example/signature"
 )
 
 type RestNotifier struct {
-	addr string
-	cli  *http.Client
+	addr    string
+	cli     *http.Client
+	retry   RetryPolicy
+	breaker *breaker
+	secrets []string
+	encoder notification.Encoder
+	batch   BatchPolicy
+	// batchUnsupported is set once the receiver turns a batch down as something it doesn't understand
+	batchUnsupported atomic.Bool
 }
 
-func (n *RestNotifier) UserCreated(u entity.User) error {
-	return n.notify(u, created)
+type Option func(n *RestNotifier)
+
+// WithRetry replaces DefaultRetryPolicy
+func WithRetry(p RetryPolicy) Option {
+	return func(n *RestNotifier) {
+		n.retry = p
+	}
+}
+
+// WithBreaker replaces DefaultBreakerPolicy, zero FailureThreshold disables the breaker
+func WithBreaker(p BreakerPolicy) Option {
+	return func(n *RestNotifier) {
+		n.breaker = nil
+		if p.FailureThreshold > 0 {
+			n.breaker = newBreaker(p)
+		}
+	}
 }
 
-func (n *RestNotifier) UserUpdated(u entity.User) error {
-	return n.notify(u, updated)
+// WithSecrets signs every notification with each of the secrets, see the signature package.
+// The second one is meant for the previous secret while receivers move to the new one.
+func WithSecrets(secrets ...string) Option {
+	return func(n *RestNotifier) {
+		n.secrets = secrets
+	}
 }
 
-func (n *RestNotifier) UserDeleted(u entity.User) error {
-	return n.notify(u, deleted)
+// WithEncoder picks the shape of notifications, the legacy one by default
+func WithEncoder(enc notification.Encoder) Option {
+	return func(n *RestNotifier) {
+		n.encoder = enc
+	}
 }
 
-func (n *RestNotifier) notify(u entity.User, nt notificationType) error {
-	nb := notificationBody{
-		NotificationType: nt,
-		User:             u,
+// Circuit tells the state of the breaker, it's always closed when the breaker is disabled
+func (n *RestNotifier) Circuit() Circuit {
+	if n.breaker == nil {
+		return Circuit{State: CircuitClosed}
 	}
-	bodyRaw, err := json.Marshal(nb)
+
+	return n.breaker.circuit()
+}
+
+func (n *RestNotifier) UserCreated(ctx context.Context, e entity.UserEvent) error {
+	return n.notify(ctx, e)
+}
+
+func (n *RestNotifier) UserUpdated(ctx context.Context, e entity.UserEvent) error {
+	return n.notify(ctx, e)
+}
+
+func (n *RestNotifier) UserDeleted(ctx context.Context, e entity.UserEvent) error {
+	return n.notify(ctx, e)
+}
+
+func (n *RestNotifier) notify(ctx context.Context, e entity.UserEvent) error {
+	bodyRaw, header, err := n.encoder.Encode(e)
 	if err != nil {
-		return fmt.Errorf("encoding body: %w", err)
+		return err
 	}
 
-	resp, err := n.cli.Post(n.addr, "application/json", bytes.NewReader(bodyRaw))
+	_, err = n.postWithRetries(ctx, bodyRaw, header, false)
+	return err
+}
+
+// postWithRetries posts until the receiver takes the body or the retry policy gives up,
+// see post for multiStatus and what's returned
+func (n *RestNotifier) postWithRetries(ctx context.Context, body []byte, header http.Header, multiStatus bool) ([]byte, error) {
+	attempts := max(n.retry.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		partial, err := n.guardedPost(ctx, body, header, multiStatus)
+		if err == nil {
+			return partial, nil
+		}
+		if errors.Is(err, ErrCircuitOpen) {
+			// the receiver is down, waiting for it is pointless
+			return nil, &attemptsError{attempts: attempt - 1, err: err}
+		}
+		if attempt >= attempts || !n.retry.retryable(ctx, err) {
+			return nil, &attemptsError{attempts: attempt, err: err}
+		}
+
+		d := n.retry.delay(attempt, err)
+		slog.Warn("notification failed, retrying", "attempt", attempt, "retry_in", d, "err", err)
+		if sleepErr := sleep(ctx, d); sleepErr != nil {
+			return nil, &attemptsError{attempts: attempt, err: errors.Join(err, sleepErr)}
+		}
+	}
+}
+
+// guardedPost posts through the breaker, if there's one
+func (n *RestNotifier) guardedPost(ctx context.Context, body []byte, header http.Header, multiStatus bool) ([]byte, error) {
+	if n.breaker == nil {
+		return n.post(ctx, body, header, multiStatus)
+	}
+
+	p, err := n.breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	partial, err := n.post(ctx, body, header, multiStatus)
+	n.breaker.done(ctx, p, err)
+	return partial, err
+}
+
+// post accepts 200 and, when multiStatus is set, 207 Multi-Status whose body it returns:
+// the receiver took only some of the batched notifications
+func (n *RestNotifier) post(ctx context.Context, body []byte, header http.Header, multiStatus bool) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.addr, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("building request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	// every attempt is signed anew, so a retry doesn't go stale
+	signature.SignRequest(req, body, time.Now(), n.secrets...)
+
+	resp, err := n.cli.Do(req)
 	if err != nil {
-		return fmt.Errorf("executing request at %s: %w", n.addr, err)
+		return nil, fmt.Errorf("executing request at %s: %w", n.addr, err)
 	}
 
 	defer entity.CloseBody(resp.Body)
 
+	if multiStatus && resp.StatusCode == http.StatusMultiStatus {
+		partial, err := io.ReadAll(io.LimitReader(resp.Body, maxBatchResponseSize))
+		if err != nil {
+			return nil, fmt.Errorf("reading partial-failure response: %w", err)
+		}
+		return partial, nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected response-code %d", resp.StatusCode)
+		return nil, &statusError{code: resp.StatusCode, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
 	}
 
-	return nil
+	return nil, nil
 }
 
-func New(addr string) *RestNotifier {
-	return &RestNotifier{
+func New(addr string, opts ...Option) *RestNotifier {
+	n := &RestNotifier{
 		addr: addr,
 		cli: &http.Client{
 			Timeout: 3 * time.Second,
 		},
+		retry:   DefaultRetryPolicy,
+		breaker: newBreaker(DefaultBreakerPolicy),
 	}
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	return n
 }
@@ -0,0 +1,174 @@
+package changelog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fastRetry = RetryPolicy{
+	MaxAttempts:        4,
+	BaseDelay:          time.Millisecond,
+	MaxDelay:           10 * time.Millisecond,
+	Jitter:             0.5,
+	RetryStatuses:      []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+	RetryNetworkErrors: true,
+}
+
+var userCreated = entity.UserEvent{Seq: 7, Type: entity.EventUserCreated, User: entity.User{ID: "42"}}
+
+// failingReceiver answers with status to the first failures requests and accepts the rest
+func failingReceiver(t *testing.T, failures int32, status int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			NotificationType entity.EventType `json:"notification_type"`
+			User             entity.User      `json:"user"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, entity.EventUserCreated, body.NotificationType)
+		assert.Equal(t, "42", body.User.ID)
+
+		if calls.Add(1) <= failures {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &calls
+}
+
+func TestRetriesUntilSuccess(t *testing.T) {
+	t.Parallel()
+
+	srv, calls := failingReceiver(t, 3, http.StatusServiceUnavailable, nil)
+	n := New(srv.URL, WithRetry(fastRetry))
+
+	require.NoError(t, n.UserCreated(context.Background(), userCreated))
+	assert.EqualValues(t, 4, calls.Load())
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	t.Parallel()
+
+	srv, calls := failingReceiver(t, 10, http.StatusServiceUnavailable, nil)
+	n := New(srv.URL, WithRetry(fastRetry))
+
+	err := n.UserCreated(context.Background(), userCreated)
+	assert.EqualError(t, err, "unexpected response-code 503")
+	assert.EqualValues(t, 4, calls.Load())
+
+	var attempts interface{ Attempts() int }
+	require.ErrorAs(t, err, &attempts)
+	assert.Equal(t, 4, attempts.Attempts())
+}
+
+func TestDoesNotRetryOtherStatuses(t *testing.T) {
+	t.Parallel()
+
+	srv, calls := failingReceiver(t, 1, http.StatusBadRequest, nil)
+	n := New(srv.URL, WithRetry(fastRetry))
+
+	assert.Error(t, n.UserCreated(context.Background(), userCreated))
+	assert.EqualValues(t, 1, calls.Load())
+}
+
+func TestHonoursRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	srv, calls := failingReceiver(t, 1, http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}})
+	p := fastRetry
+	p.MaxDelay = time.Minute
+	n := New(srv.URL, WithRetry(p))
+
+	start := time.Now()
+	require.NoError(t, n.UserCreated(context.Background(), userCreated))
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+	assert.EqualValues(t, 2, calls.Load())
+}
+
+func TestStopsWithContext(t *testing.T) {
+	t.Parallel()
+
+	srv, calls := failingReceiver(t, 10, http.StatusServiceUnavailable, http.Header{"Retry-After": {"60"}})
+	p := fastRetry
+	p.MaxDelay = time.Hour
+	n := New(srv.URL, WithRetry(p))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := n.UserCreated(ctx, userCreated)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.EqualValues(t, 1, calls.Load())
+}
+
+func TestRetriesNetworkErrors(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	addr := srv.URL
+	srv.Close()
+
+	p := fastRetry
+	p.MaxAttempts = 2
+	n := New(addr, WithRetry(p))
+	assert.Error(t, n.UserCreated(context.Background(), userCreated))
+
+	p.RetryNetworkErrors = false
+	_, err := n.post(context.Background(), []byte("{}"), nil, false)
+	assert.False(t, p.retryable(context.Background(), err))
+}
+
+func TestSignsNotifications(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(signature.Verifier{Secrets: []string{"old"}}.Middleware(http.NotFoundHandler()))
+	defer srv.Close()
+
+	n := New(srv.URL, WithRetry(RetryPolicy{}), WithSecrets("new", "old"))
+	assert.EqualError(t, n.UserCreated(context.Background(), userCreated), "unexpected response-code 404",
+		"the signature is accepted by a receiver yet to get the new secret")
+
+	n = New(srv.URL, WithRetry(RetryPolicy{}), WithSecrets("other"))
+	assert.EqualError(t, n.UserCreated(context.Background(), userCreated), "unexpected response-code 401")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 11, 5, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, 2*time.Second, parseRetryAfter("2", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Sun, 05 Nov 2023 10:00:30 GMT", now))
+	assert.Zero(t, parseRetryAfter("Sun, 05 Nov 2023 09:00:00 GMT", now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.5}
+	for attempt := 1; attempt < 70; attempt++ {
+		d := p.backoff(attempt)
+		full := min(100*time.Millisecond<<(attempt-1), time.Second)
+		if attempt > 20 {
+			full = time.Second
+		}
+		assert.GreaterOrEqual(t, d, full/2, attempt)
+		assert.LessOrEqual(t, d, full, attempt)
+	}
+}
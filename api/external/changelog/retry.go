@@ -0,0 +1,145 @@
+package changelog
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how a notification is retried after a failed attempt
+type RetryPolicy struct {
+	// MaxAttempts includes the first one, 1 or less disables retries
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Jitter is the randomized share of every delay from 0 to 1, e.g. 0.5 waits between a half and the whole delay
+	Jitter float64
+	// RetryStatuses are response codes worth another attempt
+	RetryStatuses []int
+	// RetryNetworkErrors retries when the receiver couldn't be reached at all: refused connections, timeouts and so on
+	RetryNetworkErrors bool
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+	Jitter:      0.5,
+	RetryStatuses: []int{
+		http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+	RetryNetworkErrors: true,
+}
+
+// statusError is a response the receiver didn't accept
+type statusError struct {
+	code       int
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return "unexpected response-code " + strconv.Itoa(e.code)
+}
+
+// attemptsError is the last failure of a notification given up on, it tells how many attempts were made
+type attemptsError struct {
+	attempts int
+	err      error
+}
+
+func (e *attemptsError) Error() string {
+	return e.err.Error()
+}
+
+func (e *attemptsError) Unwrap() error {
+	return e.err
+}
+
+func (e *attemptsError) Attempts() int {
+	return e.attempts
+}
+
+// backoff is the exponential delay before the given retry
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	jitter := time.Duration(float64(d) * min(max(p.Jitter, 0), 1))
+	if jitter <= 0 {
+		return d
+	}
+
+	return d - jitter + time.Duration(rand.Int63n(int64(jitter)+1))
+}
+
+// delay is how long to wait after err before the given retry, the receiver's Retry-After wins over the backoff
+// as long as it doesn't exceed MaxDelay
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	var se *statusError
+	if errors.As(err, &se) && se.retryAfter > 0 {
+		if p.MaxDelay > 0 {
+			return min(se.retryAfter, p.MaxDelay)
+		}
+		return se.retryAfter
+	}
+
+	return p.backoff(attempt)
+}
+
+func (p RetryPolicy) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var se *statusError
+	if errors.As(err, &se) {
+		return slices.Contains(p.RetryStatuses, se.code)
+	}
+
+	var urlErr *url.Error
+	return p.RetryNetworkErrors && errors.As(err, &urlErr)
+}
+
+// parseRetryAfter reads both forms of the header: delay in seconds and HTTP date
+func parseRetryAfter(raw string, now time.Time) time.Duration {
+	if raw == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(raw); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
+	}
+
+	if at, err := http.ParseTime(raw); err == nil {
+		return max(at.Sub(now), 0)
+	}
+
+	return 0
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,200 @@
+// Package notification encodes user events for the changelog and webhooks, either in the legacy
+// {notification_type, user} shape or as CloudEvents 1.0 in structured or binary HTTP content mode.
+// Updates also carry the user before and after the change along with the names of the changed fields.
+// Every notification carries the per-user sequence of the event, so receivers can tell gaps and duplicates.
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/notifyformat"
+)
+
+const (
+	DefaultSource = "/users"
+	// TypePrefix is followed by the lowercase event type, e.g. com.example.user.created
+	TypePrefix = "com.example.user."
+
+	specVersion = "1.0"
+	contentType = "application/json"
+	// structuredContentType marks the structured content mode
+	structuredContentType = "application/cloudevents+json"
+	batchContentType      = "application/cloudevents-batch+json"
+	ndjsonContentType     = "application/x-ndjson"
+)
+
+type legacyBody struct {
+	NotificationType entity.EventType `json:"notification_type"`
+	Seq              int64            `json:"seq"`
+	UserSeq          int64            `json:"user_seq"`
+	User             entity.User      `json:"user"`
+	*update
+}
+
+func legacyOf(e entity.UserEvent) legacyBody {
+	return legacyBody{
+		NotificationType: e.Type,
+		Seq:              e.Seq,
+		UserSeq:          e.UserSeq,
+		User:             e.User,
+		update:           updateOf(e),
+	}
+}
+
+// update is what an update notification carries on top of the user
+type update struct {
+	Before  entity.User `json:"before"`
+	After   entity.User `json:"after"`
+	Changed []string    `json:"changed"`
+}
+
+// updateOf is nil for events without the previous state of the user
+func updateOf(e entity.UserEvent) *update {
+	if e.Before == nil {
+		return nil
+	}
+
+	changed := e.Changed
+	if changed == nil {
+		changed = entity.UserUpdate{Before: *e.Before, After: e.User}.Changed()
+	}
+
+	return &update{Before: *e.Before, After: e.User, Changed: changed}
+}
+
+// cloudEvent data is the user or, for updates, the user before and after the change.
+// userseq is an extension attribute with the per-user sequence of the event.
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Time            string      `json:"time,omitempty"`
+	Subject         string      `json:"subject"`
+	UserSeq         int64       `json:"userseq"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// Encoder turns user events into HTTP request bodies and headers
+type Encoder struct {
+	Format notifyformat.Format
+	// Source identifies this service in CloudEvents, DefaultSource when empty
+	Source string
+}
+
+// Encode returns the body of the request carrying the event and the headers to set, Content-Type included
+func (enc Encoder) Encode(e entity.UserEvent) ([]byte, http.Header, error) {
+	h := make(http.Header)
+	h.Set("Content-Type", contentType)
+
+	var v interface{} = legacyOf(e)
+	switch enc.Format {
+	case "", notifyformat.Legacy:
+	case notifyformat.CloudEvents:
+		h.Set("Content-Type", structuredContentType)
+		v = enc.cloudEvent(e)
+	case notifyformat.CloudEventsBinary:
+		ce := enc.cloudEvent(e)
+		h.Set("ce-specversion", ce.SpecVersion)
+		h.Set("ce-id", ce.ID)
+		h.Set("ce-source", ce.Source)
+		h.Set("ce-type", ce.Type)
+		h.Set("ce-subject", ce.Subject)
+		h.Set("ce-userseq", strconv.FormatInt(ce.UserSeq, 10))
+		if ce.Time != "" {
+			h.Set("ce-time", ce.Time)
+		}
+		// datacontenttype is carried by Content-Type in binary mode
+		v = ce.Data
+	default:
+		return nil, nil, fmt.Errorf("%w %q", notifyformat.ErrBadFormat, enc.Format)
+	}
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encoding body: %w", err)
+	}
+
+	return body, h, nil
+}
+
+// EncodeBatch puts the events into one body the way f says. CloudEvents are batched in structured mode,
+// as the binary one can't carry several events.
+func (enc Encoder) EncodeBatch(events []entity.UserEvent, f notifyformat.BatchFormat) ([]byte, http.Header, error) {
+	h := make(http.Header)
+	switch enc.Format {
+	case "", notifyformat.Legacy:
+		h.Set("Content-Type", contentType)
+	case notifyformat.CloudEvents, notifyformat.CloudEventsBinary:
+		h.Set("Content-Type", batchContentType)
+	default:
+		return nil, nil, fmt.Errorf("%w %q", notifyformat.ErrBadFormat, enc.Format)
+	}
+
+	items := make([]json.RawMessage, 0, len(events))
+	for _, e := range events {
+		var v interface{} = legacyOf(e)
+		if enc.Format == notifyformat.CloudEvents || enc.Format == notifyformat.CloudEventsBinary {
+			v = enc.cloudEvent(e)
+		}
+
+		item, err := json.Marshal(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("encoding event %d: %w", e.Seq, err)
+		}
+		items = append(items, item)
+	}
+
+	switch f {
+	case "", notifyformat.BatchJSON:
+		body, err := json.Marshal(items)
+		if err != nil {
+			return nil, nil, fmt.Errorf("encoding batch: %w", err)
+		}
+		return body, h, nil
+	case notifyformat.BatchNDJSON:
+		h.Set("Content-Type", ndjsonContentType)
+		var body bytes.Buffer
+		for _, item := range items {
+			body.Write(item)
+			body.WriteByte('\n')
+		}
+		return body.Bytes(), h, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w %q", notifyformat.ErrBadBatchFormat, f)
+}
+
+func (enc Encoder) cloudEvent(e entity.UserEvent) cloudEvent {
+	source := enc.Source
+	if source == "" {
+		source = DefaultSource
+	}
+
+	ce := cloudEvent{
+		SpecVersion:     specVersion,
+		ID:              strconv.FormatInt(e.Seq, 10),
+		Source:          source,
+		Type:            TypePrefix + strings.ToLower(string(e.Type)),
+		Subject:         e.User.ID,
+		UserSeq:         e.UserSeq,
+		DataContentType: contentType,
+		Data:            e.User,
+	}
+	if u := updateOf(e); u != nil {
+		ce.Data = u
+	}
+	if !e.CreatedAt.IsZero() {
+		ce.Time = e.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	return ce
+}
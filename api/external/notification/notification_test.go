@@ -0,0 +1,143 @@
+package notification
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/notifyformat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var event = entity.UserEvent{
+	Seq:       42,
+	UserSeq:   3,
+	Type:      entity.EventUserUpdated,
+	User:      entity.User{ID: "8d6f1ad6-7d5e-4b43-a87f-1bd7e6b8f0a4", FirstName: "Cassian", LastName: "Andor"},
+	CreatedAt: time.Date(2023, 11, 5, 10, 0, 0, 0, time.UTC),
+}
+
+const user = `{"id":"8d6f1ad6-7d5e-4b43-a87f-1bd7e6b8f0a4","first_name":"Cassian","last_name":"Andor","created_at":"0001-01-01T00:00:00Z"}`
+
+func TestEncodeLegacy(t *testing.T) {
+	t.Parallel()
+
+	body, h, err := Encoder{}.Encode(event)
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", h.Get("Content-Type"))
+	assert.JSONEq(t, `{"notification_type":"UPDATED","seq":42,"user_seq":3,"user":`+user+`}`, string(body))
+}
+
+func TestEncodeCloudEvents(t *testing.T) {
+	t.Parallel()
+
+	body, h, err := Encoder{Format: notifyformat.CloudEvents, Source: "/staging/users"}.Encode(event)
+	require.NoError(t, err)
+	assert.Equal(t, "application/cloudevents+json", h.Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"specversion": "1.0",
+		"id": "42",
+		"source": "/staging/users",
+		"type": "com.example.user.updated",
+		"time": "2023-11-05T10:00:00Z",
+		"subject": "8d6f1ad6-7d5e-4b43-a87f-1bd7e6b8f0a4",
+		"userseq": 3,
+		"datacontenttype": "application/json",
+		"data": `+user+`
+	}`, string(body))
+}
+
+func TestEncodeCloudEventsBinary(t *testing.T) {
+	t.Parallel()
+
+	body, h, err := Encoder{Format: notifyformat.CloudEventsBinary}.Encode(event)
+	require.NoError(t, err)
+	assert.JSONEq(t, user, string(body))
+	assert.Equal(t, "application/json", h.Get("Content-Type"))
+	assert.Equal(t, "1.0", h.Get("ce-specversion"))
+	assert.Equal(t, "42", h.Get("ce-id"))
+	assert.Equal(t, DefaultSource, h.Get("ce-source"))
+	assert.Equal(t, "com.example.user.updated", h.Get("ce-type"))
+	assert.Equal(t, "2023-11-05T10:00:00Z", h.Get("ce-time"))
+	assert.Equal(t, event.User.ID, h.Get("ce-subject"))
+	assert.Equal(t, "3", h.Get("ce-userseq"))
+
+	var u entity.User
+	require.NoError(t, json.Unmarshal(body, &u))
+	assert.Equal(t, event.User, u)
+}
+
+func TestEncodeUpdateSnapshots(t *testing.T) {
+	t.Parallel()
+
+	e := event
+	e.Before = &entity.User{ID: event.User.ID, FirstName: "Cassian", LastName: "Jeron"}
+	before := `{"id":"8d6f1ad6-7d5e-4b43-a87f-1bd7e6b8f0a4","first_name":"Cassian","last_name":"Jeron","created_at":"0001-01-01T00:00:00Z"}`
+	snapshots := `{"before":` + before + `,"after":` + user + `,"changed":["last_name"]}`
+
+	body, _, err := Encoder{}.Encode(e)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"notification_type":"UPDATED","seq":42,"user_seq":3,"user":`+user+`,"before":`+before+`,"after":`+user+`,"changed":["last_name"]}`, string(body))
+
+	body, _, err = Encoder{Format: notifyformat.CloudEventsBinary}.Encode(e)
+	require.NoError(t, err)
+	assert.JSONEq(t, snapshots, string(body))
+
+	body, _, err = Encoder{Format: notifyformat.CloudEvents}.Encode(e)
+	require.NoError(t, err)
+	var ce struct {
+		Data json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(body, &ce))
+	assert.JSONEq(t, snapshots, string(ce.Data))
+}
+
+func TestEncodeBatch(t *testing.T) {
+	t.Parallel()
+
+	created := event
+	created.Seq, created.UserSeq, created.Type = 41, 2, entity.EventUserCreated
+	events := []entity.UserEvent{created, event}
+
+	body, h, err := Encoder{}.EncodeBatch(events, notifyformat.BatchJSON)
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", h.Get("Content-Type"))
+	assert.JSONEq(t, `[
+		{"notification_type":"CREATED","seq":41,"user_seq":2,"user":`+user+`},
+		{"notification_type":"UPDATED","seq":42,"user_seq":3,"user":`+user+`}
+	]`, string(body))
+
+	body, h, err = Encoder{Format: notifyformat.CloudEventsBinary}.EncodeBatch(events, notifyformat.BatchNDJSON)
+	require.NoError(t, err)
+	assert.Equal(t, "application/x-ndjson", h.Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	require.Len(t, lines, 2)
+	for i, line := range lines {
+		var ce struct {
+			ID   string      `json:"id"`
+			Data entity.User `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &ce), "structured mode")
+		assert.Equal(t, strconv.FormatInt(events[i].Seq, 10), ce.ID)
+		assert.Equal(t, event.User, ce.Data)
+	}
+
+	_, h, err = Encoder{Format: notifyformat.CloudEvents}.EncodeBatch(events, notifyformat.BatchJSON)
+	require.NoError(t, err)
+	assert.Equal(t, "application/cloudevents-batch+json", h.Get("Content-Type"))
+
+	_, _, err = Encoder{}.EncodeBatch(events, "xml")
+	assert.ErrorIs(t, err, notifyformat.ErrBadBatchFormat)
+}
+
+func TestEncodeBadFormat(t *testing.T) {
+	t.Parallel()
+
+	_, _, err := Encoder{Format: "xml"}.Encode(event)
+	assert.ErrorIs(t, err, notifyformat.ErrBadFormat)
+}
@@ -0,0 +1,173 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/api/external/notification"
+	"github.com/andyklimenko/testify-usage-example/notifyformat"
+	"github.com/andyklimenko/testify-usage-example/signature"
+)
+
+// ErrPrivateTarget rejects webhooks reaching into the network the service runs in
+var ErrPrivateTarget = errors.New("webhook target is a loopback, link-local or private address")
+
+// Sender posts user events to webhooks
+type Sender struct {
+	cli          *http.Client
+	source       string
+	allowPrivate bool
+}
+
+type Option func(s *Sender)
+
+// WithHTTPClient replaces the default http client having a 3 seconds timeout and refusing private targets,
+// the client is used as is
+func WithHTTPClient(cli *http.Client) Option {
+	return func(s *Sender) {
+		s.cli = cli
+	}
+}
+
+// WithPrivateTargets lets the default client post to loopback, link-local and private addresses
+func WithPrivateTargets() Option {
+	return func(s *Sender) {
+		s.allowPrivate = true
+	}
+}
+
+// WithSource sets the source of CloudEvents, notification.DefaultSource by default
+func WithSource(source string) Option {
+	return func(s *Sender) {
+		s.source = source
+	}
+}
+
+func New(opts ...Option) *Sender {
+	s := &Sender{}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.cli == nil {
+		s.cli = &http.Client{
+			Timeout:   3 * time.Second,
+			Transport: newTransport(s.allowPrivate),
+		}
+	}
+
+	return s
+}
+
+// newTransport checks every address it dials, redirects included, unless private targets are allowed.
+// Names are checked once they're resolved, so one can't be pointed at a private address after the webhook is created.
+func newTransport(allowPrivate bool) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if allowPrivate {
+		return t
+	}
+
+	// a proxy would be dialed instead of the target
+	t.Proxy = nil
+	t.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || privateIP(ip) {
+				return fmt.Errorf("dial %s: %w", address, ErrPrivateTarget)
+			}
+			return nil
+		},
+	}).DialContext
+
+	return t
+}
+
+// CheckTarget rejects urls whose host is localhost or a private address right away,
+// names resolving to one are stopped when they're dialed
+func CheckTarget(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrPrivateTarget
+	}
+	if ip := net.ParseIP(host); ip != nil && privateIP(ip) {
+		return ErrPrivateTarget
+	}
+
+	return nil
+}
+
+// sharedNets aren't covered by net.IP's checks: "this network" and the carrier-grade NAT space
+var sharedNets = []*net.IPNet{mustParseCIDR("0.0.0.0/8"), mustParseCIDR("100.64.0.0/10")}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return n
+}
+
+func privateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, n := range sharedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Send posts the event to the webhook in its format signed with its secrets, anything but 2xx is a failure
+func (s *Sender) Send(ctx context.Context, w entity.Webhook, e entity.UserEvent) error {
+	raw, header, err := notification.Encoder{Format: notifyformat.Format(w.Format), Source: s.source}.Encode(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("building request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	signature.SignRequest(req, raw, time.Now(), w.Secret, w.PreviousSecret)
+
+	resp, err := s.cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("executing request at %s: %w", w.URL, err)
+	}
+
+	defer entity.CloseBody(resp.Body)
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected response-code %d", resp.StatusCode)
+	}
+
+	return nil
+}
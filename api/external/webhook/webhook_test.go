@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSend(t *testing.T) {
+	t.Parallel()
+
+	status := http.StatusAccepted
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.NoError(t, signature.Verify(r.Header, raw, "old"), "signed with the previous secret as well")
+
+		assert.Equal(t, "application/cloudevents+json", r.Header.Get("Content-Type"))
+		var ce map[string]interface{}
+		require.NoError(t, json.Unmarshal(raw, &ce))
+		assert.Equal(t, "com.example.user.deleted", ce["type"])
+		assert.Equal(t, "/staging/users", ce["source"])
+		assert.Equal(t, "42", ce["subject"])
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	hook := entity.Webhook{URL: srv.URL, Format: "cloudevents", Secret: "new", PreviousSecret: "old"}
+	e := entity.UserEvent{Seq: 1, Type: entity.EventUserDeleted, User: entity.User{ID: "42"}}
+	sender := New(WithSource("/staging/users"), WithPrivateTargets())
+	require.NoError(t, sender.Send(context.Background(), hook, e))
+
+	status = http.StatusGone
+	assert.EqualError(t, sender.Send(context.Background(), hook, e), "unexpected response-code 410")
+}
+
+func TestPrivateTargets(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a private target is reached")
+	}))
+	defer srv.Close()
+
+	hook := entity.Webhook{URL: srv.URL, Secret: "secret"}
+	e := entity.UserEvent{Seq: 1, Type: entity.EventUserDeleted, User: entity.User{ID: "42"}}
+	assert.ErrorIs(t, New().Send(context.Background(), hook, e), ErrPrivateTarget)
+
+	for _, target := range []string{srv.URL, "http://localhost:8080/hook", "http://10.0.0.1/hook", "http://169.254.169.254/latest",
+		"http://[::1]/hook", "http://0.0.0.0/hook", "http://0.1.2.3/hook", "http://100.64.0.1/hook", "http://100.127.255.254/hook",
+		"http://[::ffff:100.100.100.200]/hook"} {
+		assert.ErrorIs(t, CheckTarget(target), ErrPrivateTarget, target)
+	}
+	assert.NoError(t, CheckTarget("https://example.com/hook"))
+	assert.NoError(t, CheckTarget("http://93.184.216.34/hook"))
+	assert.NoError(t, CheckTarget("http://100.128.0.1/hook"))
+}
@@ -0,0 +1,408 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+var (
+	// introspection queries of GraphiQL and friends go about 13 levels deep
+	graphqlMaxDepth      = 15
+	graphqlMaxComplexity = 1000
+)
+
+type loaderCtxKey struct{}
+
+var gqlUserType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "User",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.ID),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(entity.User).ID, nil
+			},
+		},
+		"firstName": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(entity.User).FirstName, nil
+			},
+		},
+		"lastName": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(entity.User).LastName, nil
+			},
+		},
+		"createdAt": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.DateTime),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(entity.User).CreatedAt, nil
+			},
+		},
+	},
+})
+
+var gqlUserPageType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "UserPage",
+	Fields: graphql.Fields{
+		"items":   &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(gqlUserType)))},
+		"limit":   &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"offset":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"hasMore": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+	},
+})
+
+var gqlUserInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "UserInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"firstName": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"lastName":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+func newGraphQLSchema(s *Server) (graphql.Schema, error) {
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type:    gqlUserType,
+				Args:    graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: s.gqlUser,
+			},
+			"users": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.NewList(gqlUserType)),
+				Args:    graphql.FieldConfigArgument{"ids": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.ID)))}},
+				Resolve: s.gqlUsers,
+			},
+			"searchUsers": &graphql.Field{
+				Type: graphql.NewNonNull(gqlUserPageType),
+				Args: graphql.FieldConfigArgument{
+					"name":   {Type: graphql.String, DefaultValue: ""},
+					"limit":  {Type: graphql.Int, DefaultValue: defaultPageSize},
+					"offset": {Type: graphql.Int, DefaultValue: 0},
+				},
+				Resolve: s.gqlSearchUsers,
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createUser": &graphql.Field{
+				Type:    graphql.NewNonNull(gqlUserType),
+				Args:    graphql.FieldConfigArgument{"input": {Type: graphql.NewNonNull(gqlUserInputType)}},
+				Resolve: s.gqlCreateUser,
+			},
+			"updateUser": &graphql.Field{
+				Type: graphql.NewNonNull(gqlUserType),
+				Args: graphql.FieldConfigArgument{
+					"id":    {Type: graphql.NewNonNull(graphql.ID)},
+					"input": {Type: graphql.NewNonNull(gqlUserInputType)},
+				},
+				Resolve: s.gqlUpdateUser,
+			},
+			"deleteUser": &graphql.Field{
+				Type:    graphql.NewNonNull(graphql.Boolean),
+				Args:    graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: s.gqlDeleteUser,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+func (s *Server) gqlUser(p graphql.ResolveParams) (interface{}, error) {
+	thunk := loaderFromCtx(p.Context).Load(p.Args["id"].(string))
+	return func() (interface{}, error) {
+		u, err := thunk()
+		if errors.Is(err, entity.ErrNotFound) {
+			return nil, nil
+		}
+		return u, err
+	}, nil
+}
+
+func (s *Server) gqlUsers(p graphql.ResolveParams) (interface{}, error) {
+	loader := loaderFromCtx(p.Context)
+	ids := p.Args["ids"].([]interface{})
+	if len(ids) > maxPageSize {
+		return nil, fmt.Errorf("no more than %d ids at once", maxPageSize)
+	}
+
+	res := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		thunk := loader.Load(id.(string))
+		res = append(res, func() (interface{}, error) {
+			u, err := thunk()
+			if errors.Is(err, entity.ErrNotFound) {
+				return nil, nil
+			}
+			return u, err
+		})
+	}
+
+	return res, nil
+}
+
+func (s *Server) gqlSearchUsers(p graphql.ResolveParams) (interface{}, error) {
+	page, err := s.usersPage(p.Context, entity.UserFilter{
+		Name:   p.Args["name"].(string),
+		Limit:  p.Args["limit"].(int),
+		Offset: p.Args["offset"].(int),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"items":   page.Items,
+		"limit":   page.Limit,
+		"offset":  page.Offset,
+		"hasMore": page.HasMore,
+	}, nil
+}
+
+func (s *Server) gqlCreateUser(p graphql.ResolveParams) (interface{}, error) {
+	createdUser, err := s.repo.InsertUser(p.Context, gqlUserInput(p.Args["input"]))
+	if err != nil {
+		return nil, fmt.Errorf("create new user: %w", err)
+	}
+
+	s.kickRelay()
+
+	return createdUser, nil
+}
+
+func (s *Server) gqlUpdateUser(p graphql.ResolveParams) (interface{}, error) {
+	userID := p.Args["id"].(string)
+	res, err := s.repo.UpdateUser(p.Context, userID, gqlUserInput(p.Args["input"]))
+	if err != nil {
+		if errors.Is(err, entity.ErrNotFound) {
+			return nil, fmt.Errorf("user %s not found", userID)
+		}
+		return nil, fmt.Errorf("update user by id %s: %w", userID, err)
+	}
+
+	s.kickRelay()
+
+	return res.After, nil
+}
+
+func (s *Server) gqlDeleteUser(p graphql.ResolveParams) (interface{}, error) {
+	userID := p.Args["id"].(string)
+	if err := s.deleteUserTx(p.Context, userID); err != nil {
+		if errors.Is(err, entity.ErrNotFound) {
+			return false, nil
+		}
+		return nil, err
+	}
+
+	return true, nil
+}
+
+func gqlUserInput(raw interface{}) entity.User {
+	in := raw.(map[string]interface{})
+	return entity.User{
+		FirstName: in["firstName"].(string),
+		LastName:  in["lastName"].(string),
+	}
+}
+
+func loaderFromCtx(ctx context.Context) *userLoader {
+	return ctx.Value(loaderCtxKey{}).(*userLoader)
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func (s *Server) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if r.Method == http.MethodGet {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				s.respondNotOK(w, http.StatusBadRequest, fmt.Errorf("decode variables: %w", err))
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondNotOK(w, http.StatusBadRequest, fmt.Errorf("decode request body: %w", err))
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), loaderCtxKey{}, newUserLoader(r.Context(), s.repo))
+	s.respondOK(w, http.StatusOK, s.execGraphQL(ctx, req))
+}
+
+func (s *Server) execGraphQL(ctx context.Context, req graphqlRequest) *graphql.Result {
+	// only a mutation that gets to run pins the client to the primary
+	markWrite(ctx, false)
+
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+
+	if v := graphql.ValidateDocument(&s.graphqlSchema, doc, nil); !v.IsValid {
+		return &graphql.Result{Errors: v.Errors}
+	}
+
+	if err := checkQueryLimits(doc, req.OperationName, req.Variables); err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+
+	markWrite(ctx, isMutation(doc, req.OperationName))
+
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        s.graphqlSchema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	})
+}
+
+func isMutation(doc *ast.Document, operationName string) bool {
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok || (operationName != "" && (op.Name == nil || op.Name.Value != operationName)) {
+			continue
+		}
+		if op.Operation == ast.OperationTypeMutation {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkQueryLimits rejects queries that are nested too deep or would fetch too much.
+// Every field costs 1, fields returning lists multiply the cost of their selection by the requested size.
+func checkQueryLimits(doc *ast.Document, operationName string, vars map[string]interface{}) error {
+	fragments := make(map[string]*ast.FragmentDefinition)
+	var operations []*ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		switch d := def.(type) {
+		case *ast.FragmentDefinition:
+			fragments[d.Name.Value] = d
+		case *ast.OperationDefinition:
+			if operationName == "" || (d.Name != nil && d.Name.Value == operationName) {
+				operations = append(operations, d)
+			}
+		}
+	}
+
+	for _, op := range operations {
+		depth, complexity := selectionCost(op.SelectionSet, fragments, vars, 0)
+		if depth > graphqlMaxDepth {
+			return fmt.Errorf("query depth %d exceeds the limit of %d", depth, graphqlMaxDepth)
+		}
+		if complexity > graphqlMaxComplexity {
+			return fmt.Errorf("query complexity %d exceeds the limit of %d", complexity, graphqlMaxComplexity)
+		}
+	}
+
+	return nil
+}
+
+func selectionCost(set *ast.SelectionSet, fragments map[string]*ast.FragmentDefinition, vars map[string]interface{}, level int) (int, int) {
+	if set == nil {
+		return level, 0
+	}
+
+	// a fragment cycle is rejected by validation, the level guard is just to be safe
+	if level > graphqlMaxDepth {
+		return level, 0
+	}
+
+	maxDepth, cost := level, 0
+	for _, sel := range set.Selections {
+		var depth, selCost int
+		switch s := sel.(type) {
+		case *ast.Field:
+			depth, selCost = selectionCost(s.SelectionSet, fragments, vars, level+1)
+			selCost = 1 + fieldMultiplier(s, vars)*selCost
+		case *ast.InlineFragment:
+			depth, selCost = selectionCost(s.SelectionSet, fragments, vars, level)
+		case *ast.FragmentSpread:
+			if f, ok := fragments[s.Name.Value]; ok {
+				depth, selCost = selectionCost(f.SelectionSet, fragments, vars, level)
+			}
+		}
+
+		cost += selCost
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+	}
+
+	return maxDepth, cost
+}
+
+func fieldMultiplier(f *ast.Field, vars map[string]interface{}) int {
+	for _, arg := range f.Arguments {
+		switch arg.Name.Value {
+		case "ids":
+			if list, ok := argValue(arg.Value, vars).([]interface{}); ok {
+				return max(len(list), 1)
+			}
+		case "limit":
+			switch v := argValue(arg.Value, vars).(type) {
+			case int:
+				return max(v, 1)
+			case float64:
+				return max(int(v), 1)
+			}
+		}
+	}
+
+	if f.Name.Value == "searchUsers" {
+		return defaultPageSize
+	}
+
+	return 1
+}
+
+func argValue(v ast.Value, vars map[string]interface{}) interface{} {
+	if variable, ok := v.(*ast.Variable); ok {
+		return vars[variable.Name.Value]
+	}
+
+	return valueFromAST(v)
+}
+
+func valueFromAST(v ast.Value) interface{} {
+	switch val := v.(type) {
+	case *ast.IntValue:
+		var n int
+		_, _ = fmt.Sscan(val.Value, &n)
+		return n
+	case *ast.ListValue:
+		res := make([]interface{}, 0, len(val.Values))
+		for _, item := range val.Values {
+			res = append(res, valueFromAST(item))
+		}
+		return res
+	default:
+		return v.GetValue()
+	}
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type batchCountingRepo struct {
+	repo
+	calls atomic.Int32
+	users map[string]entity.User
+}
+
+func (r *batchCountingRepo) UsersByIDs(_ context.Context, ids []string) ([]entity.User, error) {
+	r.calls.Add(1)
+
+	var res []entity.User
+	for _, id := range ids {
+		if u, ok := r.users[id]; ok {
+			res = append(res, u)
+		}
+	}
+	return res, nil
+}
+
+func execTestGraphQL(t *testing.T, srv *Server, query string, vars map[string]interface{}) map[string]interface{} {
+	t.Helper()
+
+	ctx := context.WithValue(context.Background(), loaderCtxKey{}, newUserLoader(context.Background(), srv.repo))
+	res := srv.execGraphQL(ctx, graphqlRequest{Query: query, Variables: vars})
+	require.Empty(t, res.Errors)
+
+	raw, err := json.Marshal(res.Data)
+	require.NoError(t, err)
+
+	var data map[string]interface{}
+	require.NoError(t, json.Unmarshal(raw, &data))
+
+	return data
+}
+
+func TestGraphQLBatchesUserLookups(t *testing.T) {
+	t.Parallel()
+
+	r := &batchCountingRepo{users: map[string]entity.User{
+		"1": {ID: "1", FirstName: "Padme"},
+		"2": {ID: "2", FirstName: "Jar Jar"},
+	}}
+	srv := &Server{repo: r}
+	setupRouter(srv)
+
+	data := execTestGraphQL(t, srv, `query($ids: [ID!]!) {
+		a: user(id: "1") { firstName }
+		b: user(id: "404") { firstName }
+		users(ids: $ids) { id }
+	}`, map[string]interface{}{"ids": []interface{}{"2", "1"}})
+
+	assert.EqualValues(t, 1, r.calls.Load())
+	assert.Equal(t, map[string]interface{}{"firstName": "Padme"}, data["a"])
+	assert.Nil(t, data["b"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"id": "2"},
+		map[string]interface{}{"id": "1"},
+	}, data["users"])
+}
+
+func TestGraphQLLoadsNonCanonicalIDs(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	r := &batchCountingRepo{users: map[string]entity.User{
+		id:  {ID: id, FirstName: "Obi-Wan"},
+		"2": {ID: "2", FirstName: "Qui-Gon"},
+	}}
+	srv := &Server{repo: r}
+	setupRouter(srv)
+
+	data := execTestGraphQL(t, srv, `query($upper: ID!, $braced: ID!, $urn: ID!) {
+		a: user(id: $upper) { id }
+		b: user(id: $braced) { id }
+		c: user(id: $urn) { id }
+		d: user(id: "2") { firstName }
+	}`, map[string]interface{}{"upper": strings.ToUpper(id), "braced": "{" + id + "}", "urn": "urn:uuid:" + id})
+
+	assert.EqualValues(t, 1, r.calls.Load())
+	for _, k := range []string{"a", "b", "c"} {
+		assert.Equal(t, map[string]interface{}{"id": id}, data[k], k)
+	}
+	assert.Equal(t, map[string]interface{}{"firstName": "Qui-Gon"}, data["d"])
+}
+
+func TestGraphQLQueryLimits(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{}
+	setupRouter(srv)
+	ctx := context.Background()
+
+	deep := "{ __schema { types { " + strings.Repeat("fields { type { ", 8) + "name" + strings.Repeat(" } }", 8) + " } } }"
+	res := srv.execGraphQL(ctx, graphqlRequest{Query: deep})
+	require.Len(t, res.Errors, 1)
+	assert.Contains(t, res.Errors[0].Message, "query depth")
+
+	expensive := `query($limit: Int) {
+		a: searchUsers(limit: $limit) { items { id firstName lastName createdAt } }
+		b: searchUsers(limit: $limit) { items { id firstName lastName createdAt } }
+		c: searchUsers(limit: $limit) { items { id firstName lastName createdAt } }
+	}`
+	res = srv.execGraphQL(ctx, graphqlRequest{Query: expensive, Variables: map[string]interface{}{"limit": 100}})
+	require.Len(t, res.Errors, 1)
+	assert.Contains(t, res.Errors[0].Message, "query complexity")
+}
+
+func (s *srvSuite) postGraphQL(srvURL string, query string, vars map[string]interface{}) map[string]interface{} {
+	bodyRaw, err := json.Marshal(graphqlRequest{Query: query, Variables: vars})
+	require.NoError(s.T(), err)
+
+	resp, err := s.httpCli.Post(srvURL+"/graphql", "application/json", bytes.NewReader(bodyRaw))
+	require.NoError(s.T(), err)
+
+	defer entity.CloseBody(resp.Body)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+
+	var res struct {
+		Data   map[string]interface{}   `json:"data"`
+		Errors []map[string]interface{} `json:"errors"`
+	}
+	require.NoError(s.T(), json.NewDecoder(resp.Body).Decode(&res))
+	require.Empty(s.T(), res.Errors)
+
+	return res.Data
+}
+
+func (s *srvSuite) TestGraphQLUserLifecycle() {
+	var cl mockedChangelog
+	notified := make(chan struct{}, 3)
+	signal := func(mock.Arguments) { notified <- struct{}{} }
+	cl.On("UserCreated", mock.Anything).Return(nil).Run(signal).Once()
+	cl.On("UserUpdated", mock.Anything).Return(nil).Run(signal).Once()
+	cl.On("UserDeleted", mock.Anything).Return(nil).Run(signal).Once()
+
+	srvURL, closer := s.setupServer(&cl)
+	defer closer()
+
+	data := s.postGraphQL(srvURL, `mutation($input: UserInput!) { createUser(input: $input) { id firstName } }`,
+		map[string]interface{}{"input": map[string]interface{}{"firstName": "Mace", "lastName": "Windu"}})
+	created := data["createUser"].(map[string]interface{})
+	userID := created["id"].(string)
+	assert.Equal(s.T(), "Mace", created["firstName"])
+
+	data = s.postGraphQL(srvURL, `mutation($id: ID!) { updateUser(id: $id, input: {firstName: "Master", lastName: "Windu"}) { firstName } }`,
+		map[string]interface{}{"id": userID})
+	assert.Equal(s.T(), map[string]interface{}{"firstName": "Master"}, data["updateUser"])
+
+	data = s.postGraphQL(srvURL, `query($id: ID!) {
+		user(id: $id) { lastName }
+		searchUsers(name: "mast", limit: 100) { items { id } hasMore }
+	}`, map[string]interface{}{"id": userID})
+	assert.Equal(s.T(), map[string]interface{}{"lastName": "Windu"}, data["user"])
+	assert.Contains(s.T(), data["searchUsers"].(map[string]interface{})["items"], map[string]interface{}{"id": userID})
+
+	data = s.postGraphQL(srvURL, `mutation($id: ID!) { deleteUser(id: $id) }`, map[string]interface{}{"id": userID})
+	assert.Equal(s.T(), true, data["deleteUser"])
+
+	data = s.postGraphQL(srvURL, `query($id: ID!) { user(id: $id) { id } }`, map[string]interface{}{"id": userID})
+	assert.Nil(s.T(), data["user"])
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-time.After(time.Second):
+			s.T().Fatal("timeout")
+		case <-notified:
+		}
+	}
+	cl.AssertExpectations(s.T())
+}
+
+func (s *srvSuite) TestGraphQLUsersWithMalformedID() {
+	srvURL, closer := s.setupServer(nil)
+	defer closer()
+
+	u, err := s.createTestUser(srvURL, entity.User{FirstName: "Plo", LastName: "Koon"})
+	require.NoError(s.T(), err)
+
+	data := s.postGraphQL(srvURL, `query($ids: [ID!]!) { users(ids: $ids) { id } }`,
+		map[string]interface{}{"ids": []string{u.ID, "not-a-uuid", uuid.NewString()}})
+	assert.Equal(s.T(), []interface{}{map[string]interface{}{"id": u.ID}, nil, nil}, data["users"])
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/external/changelog"
+)
+
+const (
+	healthOK          = "ok"
+	healthUnavailable = "unavailable"
+)
+
+var readinessTimeout = 2 * time.Second
+
+// circuitBreaker is a changelog that may consider its receiver down
+type circuitBreaker interface {
+	Circuit() changelog.Circuit
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// healthz tells the process is alive, it doesn't look at any dependency
+func (s *Server) healthz(w http.ResponseWriter, _ *http.Request) {
+	s.respondOK(w, http.StatusOK, healthResponse{Status: healthOK})
+}
+
+// readyz tells whether the server is able to serve requests
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	resp := healthResponse{Status: healthOK, Checks: make(map[string]string)}
+
+	resp.Checks["database"] = healthOK
+	if err := s.repo.Ping(ctx); err != nil {
+		resp.Status = healthUnavailable
+		resp.Checks["database"] = err.Error()
+	}
+
+	// notifications wait in the outbox while the changelog is down, so it's a detail rather than a failure
+	if cb, ok := s.userChangelog.(circuitBreaker); ok {
+		resp.Checks["changelog"] = healthOK
+		if c := cb.Circuit(); c.State == changelog.CircuitOpen {
+			resp.Checks["changelog"] = "circuit open until " + c.OpenUntil.Format(time.RFC3339)
+		} else if c.State != changelog.CircuitClosed {
+			resp.Checks["changelog"] = "circuit " + string(c.State)
+		}
+	}
+
+	resp.Checks["server"] = healthOK
+	if s.shuttingDown.Load() {
+		resp.Status = healthUnavailable
+		resp.Checks["server"] = "shutting down"
+	}
+
+	statusCode := http.StatusOK
+	if resp.Status != healthOK {
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	s.respondOK(w, statusCode, resp)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/api/external/changelog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type unreachableRepo struct {
+	repo
+}
+
+func (unreachableRepo) Ping(context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestReadyzReportsDatabase(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	setupRouter(&Server{repo: unreachableRepo{}}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var resp healthResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, healthResponse{
+		Status: healthUnavailable,
+		Checks: map[string]string{"database": "connection refused", "server": healthOK},
+	}, resp)
+}
+
+type pingingRepo struct {
+	repo
+}
+
+func (pingingRepo) Ping(context.Context) error {
+	return nil
+}
+
+type openCircuit struct {
+	mockedChangelog
+}
+
+func (*openCircuit) Circuit() changelog.Circuit {
+	return changelog.Circuit{State: changelog.CircuitOpen, OpenUntil: time.Date(2023, 11, 5, 10, 0, 0, 0, time.UTC)}
+}
+
+func TestReadyzReportsChangelogCircuit(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	srv := &Server{repo: pingingRepo{}, userChangelog: &openCircuit{}}
+	setupRouter(srv).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	require.Equal(t, http.StatusOK, rec.Code, "notifications wait for the changelog in the outbox")
+
+	var resp healthResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, "circuit open until 2023-11-05T10:00:00Z", resp.Checks["changelog"])
+}
+
+func (s *srvSuite) TestHealth() {
+	srvURL, closer := s.setupServer(nil)
+	defer closer()
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		resp, err := s.httpCli.Get(srvURL + path)
+		require.NoError(s.T(), err)
+
+		defer entity.CloseBody(resp.Body)
+		assert.Equal(s.T(), http.StatusOK, resp.StatusCode, path)
+	}
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+)
+
+// eventHub fans user events out to live subscribers. A subscriber that can't keep up with
+// the stream is dropped: its channel gets closed and it's expected to resume from the persisted log.
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[*eventSubscription]struct{}
+	closed bool
+}
+
+type eventSubscription struct {
+	events chan entity.UserEvent
+}
+
+func (h *eventHub) subscribe(bufSize int) *eventSubscription {
+	sub := &eventSubscription{events: make(chan entity.UserEvent, bufSize)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(sub.events)
+		return sub
+	}
+
+	if h.subs == nil {
+		h.subs = make(map[*eventSubscription]struct{})
+	}
+	h.subs[sub] = struct{}{}
+
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *eventSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+func (h *eventHub) publish(e entity.UserEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		select {
+		case sub.events <- e:
+		default:
+			delete(h.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+// startEventTail publishes the event log to live subscribers in the order it's read until the returned func
+// is called, holding an event back till the previous one of its user is through, so events of a user never
+// overtake each other. The ones made by other replicas show up too.
+// Besides polling every relayInterval it wakes up as soon as this server changes a user.
+func (s *Server) startEventTail() func() {
+	interval := s.relayInterval
+	if interval <= 0 {
+		interval = defaultRelayInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// the log before the start is for replays
+	after := s.publishEventsAfter(ctx, tailCursor{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			after = s.publishEventsAfter(ctx, after)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-s.eventsKick:
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// tailCursor is where the tail is in the event log
+type tailCursor struct {
+	pos entity.EventPosition
+	// started is false until the end of the log at the start is found
+	started bool
+}
+
+// publishEventsAfter returns the cursor past the last published event
+func (s *Server) publishEventsAfter(ctx context.Context, after tailCursor) tailCursor {
+	if !after.started {
+		last, err := s.repo.LastUserEventPosition(ctx)
+		if err != nil {
+			slog.Error("looking for the end of the event log", "err", err)
+			return after
+		}
+		return tailCursor{pos: last, started: true}
+	}
+
+	for ctx.Err() == nil {
+		events, err := s.repo.UserEventsAfter(ctx, after.pos, sseReplayPageSize)
+		if err != nil {
+			slog.Error("tailing the event log", "after", after.pos.Seq, "err", err)
+			return after
+		}
+
+		for _, e := range events {
+			inOrder, err := s.inUserOrder(ctx, e)
+			if err != nil {
+				slog.Error("tailing the event log", "after", after.pos.Seq, "err", err)
+				return after
+			}
+
+			for _, e := range inOrder {
+				s.events.publish(e)
+			}
+			after.pos = e.Position()
+		}
+
+		if len(events) < sseReplayPageSize {
+			break
+		}
+	}
+
+	return after
+}
+
+// inUserOrder returns the event read from the log followed by the ones held back behind it, nothing for
+// an event held back itself: it goes along with the previous event of its user, which comes later in the log.
+// A transaction takes its xid at the first write, so an older one may number an event of a user after
+// a younger one has committed.
+func (s *Server) inUserOrder(ctx context.Context, e entity.UserEvent) ([]entity.UserEvent, error) {
+	if e.HeldBack {
+		return nil, nil
+	}
+	if !e.HoldsBack {
+		return []entity.UserEvent{e}, nil
+	}
+
+	held, err := s.repo.UserEventsHeldBack(ctx, e.Seq)
+	if err != nil {
+		return nil, fmt.Errorf("load events held back behind %d: %w", e.Seq, err)
+	}
+
+	return append([]entity.UserEvent{e}, held...), nil
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"sync"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/google/uuid"
+)
+
+// userLoader batches UserByID lookups made while resolving a single GraphQL request.
+// Load only registers a key and hands back a thunk, the first thunk to be evaluated
+// fetches every key registered so far with one query.
+type userLoader struct {
+	ctx  context.Context
+	repo repo
+
+	mu      sync.Mutex
+	pending []string
+	cache   map[string]*userLoadResult
+}
+
+type userLoadResult struct {
+	user entity.User
+	err  error
+	done bool
+}
+
+func newUserLoader(ctx context.Context, r repo) *userLoader {
+	return &userLoader{
+		ctx:   ctx,
+		repo:  r,
+		cache: make(map[string]*userLoadResult),
+	}
+}
+
+// userKey is the canonical form of the id the storage hands users back with,
+// so the same user asked for by differently spelled ids is fetched once
+func userKey(id string) string {
+	if parsed, err := uuid.Parse(id); err == nil {
+		return parsed.String()
+	}
+
+	return id
+}
+
+func (l *userLoader) Load(id string) func() (interface{}, error) {
+	key := userKey(id)
+
+	l.mu.Lock()
+	if _, ok := l.cache[key]; !ok {
+		l.cache[key] = &userLoadResult{}
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		res := l.cache[key]
+		if !res.done {
+			l.dispatch()
+		}
+
+		if res.err != nil {
+			return nil, res.err
+		}
+
+		return res.user, nil
+	}
+}
+
+// dispatch must be called with the lock held
+func (l *userLoader) dispatch() {
+	ids := l.pending
+	l.pending = nil
+
+	users, err := l.repo.UsersByIDs(l.ctx, ids)
+	for _, id := range ids {
+		l.cache[id].done = true
+		l.cache[id].err = err
+	}
+	if err != nil {
+		return
+	}
+
+	for _, u := range users {
+		// nobody asked for a user the storage came up with on its own
+		if res, ok := l.cache[userKey(u.ID)]; ok {
+			res.user = u
+		}
+	}
+
+	for _, id := range ids {
+		if l.cache[id].user.ID == "" {
+			l.cache[id].err = entity.ErrNotFound
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/external/changelog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsTimeout = time.Second
+
+func (s *Server) metricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "users_dead_letters",
+		Help: "Notifications the changelog didn't accept even after all the retries",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+		defer cancel()
+
+		n, err := s.repo.CountDeadLetters(ctx)
+		if err != nil {
+			slog.Error("count dead letters", "err", err)
+			return math.NaN()
+		}
+
+		return float64(n)
+	}))
+
+	if cb, ok := s.userChangelog.(circuitBreaker); ok {
+		for _, state := range []changelog.CircuitState{changelog.CircuitClosed, changelog.CircuitOpen, changelog.CircuitHalfOpen} {
+			state := state
+			reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Name:        "users_changelog_circuit_state",
+				Help:        "1 for the current state of the changelog circuit breaker",
+				ConstLabels: prometheus.Labels{"state": string(state)},
+			}, func() float64 {
+				if cb.Circuit().State == state {
+					return 1
+				}
+				return 0
+			}))
+		}
+
+		reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "users_changelog_circuit_changes_total",
+			Help: "State changes of the changelog circuit breaker",
+		}, func() float64 {
+			return float64(cb.Circuit().Changes)
+		}))
+	}
+
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,401 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	_ "embed"
+
+	"github.com/gorilla/mux"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const (
+	openAPIResource   = "openapi.json"
+	maxValidatedBytes = 1 << 20
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+func (s *Server) serveOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(openAPISpec); err != nil {
+		slog.Error("write openapi spec", "err", err)
+	}
+}
+
+type openAPIParam struct {
+	name     string
+	in       string
+	required bool
+	schema   *jsonschema.Schema
+}
+
+type openAPIOperation struct {
+	params          []openAPIParam
+	body            *jsonschema.Schema
+	bodyRequired    bool
+	responses       map[string]*jsonschema.Schema
+	captureResponse bool
+}
+
+// openAPIValidator checks requests and responses of the routes against the embedded spec
+type openAPIValidator struct {
+	// keyed by path template and lowercase method, e.g. "/users/{id}" and "get"
+	operations map[string]map[string]*openAPIOperation
+}
+
+func newOpenAPIValidator() (*openAPIValidator, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		return nil, fmt.Errorf("decode spec: %w", err)
+	}
+
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	c.AssertFormat = true
+	if err := c.AddResource(openAPIResource, bytes.NewReader(openAPISpec)); err != nil {
+		return nil, fmt.Errorf("load spec: %w", err)
+	}
+
+	b := specBuilder{doc: doc, compiler: c}
+	v := &openAPIValidator{operations: make(map[string]map[string]*openAPIOperation)}
+	paths, _ := doc["paths"].(map[string]interface{})
+	for path, rawItem := range paths {
+		item, itemPtr := b.resolve(rawItem, "/paths/"+escapePointer(path))
+		v.operations[path] = make(map[string]*openAPIOperation)
+		for method, rawOp := range item {
+			if method == "parameters" {
+				continue
+			}
+
+			op, err := b.operation(item, itemPtr, rawOp, itemPtr+"/"+method)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", strings.ToUpper(method), path, err)
+			}
+			v.operations[path][method] = op
+		}
+	}
+
+	return v, nil
+}
+
+func (v *openAPIValidator) operation(path, method string) (*openAPIOperation, bool) {
+	op, ok := v.operations[path][strings.ToLower(method)]
+	return op, ok
+}
+
+type specBuilder struct {
+	doc      map[string]interface{}
+	compiler *jsonschema.Compiler
+}
+
+// resolve follows a local $ref, if any, and returns the node along with its JSON pointer
+func (b specBuilder) resolve(node interface{}, ptr string) (map[string]interface{}, string) {
+	m, _ := node.(map[string]interface{})
+	ref, ok := m["$ref"].(string)
+	if !ok || !strings.HasPrefix(ref, "#/") {
+		return m, ptr
+	}
+
+	var cur interface{} = b.doc
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		next, _ := cur.(map[string]interface{})
+		cur = next[token]
+	}
+
+	return b.resolve(cur, strings.TrimPrefix(ref, "#"))
+}
+
+func (b specBuilder) compile(ptr string) (*jsonschema.Schema, error) {
+	return b.compiler.Compile(openAPIResource + "#" + ptr)
+}
+
+func (b specBuilder) operation(item map[string]interface{}, itemPtr string, rawOp interface{}, opPtr string) (*openAPIOperation, error) {
+	opNode, opPtr := b.resolve(rawOp, opPtr)
+	op := &openAPIOperation{responses: make(map[string]*jsonschema.Schema), captureResponse: true}
+
+	var params []interface{}
+	var paramPtrs []string
+	for i, p := range asSlice(item["parameters"]) {
+		params = append(params, p)
+		paramPtrs = append(paramPtrs, itemPtr+"/parameters/"+strconv.Itoa(i))
+	}
+	for i, p := range asSlice(opNode["parameters"]) {
+		params = append(params, p)
+		paramPtrs = append(paramPtrs, opPtr+"/parameters/"+strconv.Itoa(i))
+	}
+
+	for i, raw := range params {
+		p, ptr := b.resolve(raw, paramPtrs[i])
+		schema, err := b.compile(ptr + "/schema")
+		if err != nil {
+			return nil, fmt.Errorf("parameter %v: %w", p["name"], err)
+		}
+
+		required, _ := p["required"].(bool)
+		op.params = append(op.params, openAPIParam{
+			name:     p["name"].(string),
+			in:       p["in"].(string),
+			required: required,
+			schema:   schema,
+		})
+	}
+
+	if rawBody, ok := opNode["requestBody"]; ok {
+		body, ptr := b.resolve(rawBody, opPtr+"/requestBody")
+		op.bodyRequired, _ = body["required"].(bool)
+		if _, ok := jsonContent(body); ok {
+			schema, err := b.compile(ptr + "/content/application~1json/schema")
+			if err != nil {
+				return nil, fmt.Errorf("request body: %w", err)
+			}
+			op.body = schema
+		}
+	}
+
+	responses, _ := opNode["responses"].(map[string]interface{})
+	for status, rawResp := range responses {
+		resp, ptr := b.resolve(rawResp, opPtr+"/responses/"+status)
+		content, _ := resp["content"].(map[string]interface{})
+		if status == strconv.Itoa(http.StatusSwitchingProtocols) {
+			op.captureResponse = false
+		}
+
+		for contentType := range content {
+			if contentType != "application/json" {
+				// streams must not be buffered
+				op.captureResponse = false
+			}
+		}
+
+		// a nil schema stands for a documented response without a JSON body
+		op.responses[status] = nil
+		if _, ok := jsonContent(resp); ok {
+			schema, err := b.compile(ptr + "/content/application~1json/schema")
+			if err != nil {
+				return nil, fmt.Errorf("response %s: %w", status, err)
+			}
+			op.responses[status] = schema
+		}
+	}
+
+	return op, nil
+}
+
+func jsonContent(node map[string]interface{}) (interface{}, bool) {
+	content, _ := node["content"].(map[string]interface{})
+	c, ok := content["application/json"]
+	return c, ok
+}
+
+func asSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
+
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
+
+func (v *openAPIValidator) validateRequest(r *http.Request, op *openAPIOperation) error {
+	vars := mux.Vars(r)
+	for _, p := range op.params {
+		var values []string
+		switch p.in {
+		case "path":
+			if val, ok := vars[p.name]; ok {
+				values = []string{val}
+			}
+		case "query":
+			values = r.URL.Query()[p.name]
+		case "header":
+			values = r.Header.Values(p.name)
+		}
+
+		if len(values) == 0 {
+			if p.required {
+				return fmt.Errorf("%s parameter %s is required", p.in, p.name)
+			}
+			continue
+		}
+
+		var val interface{} = values[0]
+		if slices.Contains(p.schema.Types, "array") {
+			items := make([]interface{}, 0, len(values))
+			for _, v := range values {
+				items = append(items, v)
+			}
+			val = items
+		}
+
+		if err := p.schema.Validate(val); err != nil {
+			return fmt.Errorf("%s parameter %s: %s", p.in, p.name, validationDetails(err))
+		}
+	}
+
+	if op.body == nil {
+		return nil
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedBytes))
+	if err != nil {
+		return fmt.Errorf("read request body: %w", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+
+	if len(bytes.TrimSpace(raw)) == 0 {
+		if op.bodyRequired {
+			return errors.New("request body is required")
+		}
+		return nil
+	}
+
+	doc, err := decodeJSONDoc(raw)
+	if err != nil {
+		return fmt.Errorf("decode request body: %w", err)
+	}
+
+	if err := op.body.Validate(doc); err != nil {
+		return fmt.Errorf("request body: %s", validationDetails(err))
+	}
+
+	return nil
+}
+
+func (v *openAPIValidator) validateResponse(status int, body []byte, op *openAPIOperation) error {
+	schema, declared := op.responses[strconv.Itoa(status)]
+	if !declared {
+		return fmt.Errorf("undocumented status %d", status)
+	}
+	if schema == nil {
+		return nil
+	}
+
+	doc, err := decodeJSONDoc(body)
+	if err != nil {
+		return fmt.Errorf("decode response body: %w", err)
+	}
+
+	if err := schema.Validate(doc); err != nil {
+		return fmt.Errorf("response body: %s", validationDetails(err))
+	}
+
+	return nil
+}
+
+// decodeJSONDoc keeps numbers as json.Number as the validator expects
+func decodeJSONDoc(raw []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+
+	var doc interface{}
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+func validationDetails(err error) string {
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return err.Error()
+	}
+
+	var details []string
+	var walk func(e *jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			loc := e.InstanceLocation
+			if loc == "" {
+				loc = "/"
+			}
+			details = append(details, loc+": "+e.Message)
+		}
+		for _, c := range e.Causes {
+			walk(c)
+		}
+	}
+	walk(ve)
+
+	return strings.Join(details, "; ")
+}
+
+// validateOpenAPI is a middleware rejecting requests that don't match the spec
+// and logging responses that don't match it
+func (s *Server) validateOpenAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		op, ok := s.openAPI.operation(path, r.Method)
+		if !ok {
+			slog.Warn("no openapi operation for route", "method", r.Method, "path", path)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if s.validateRequests {
+			if err := s.openAPI.validateRequest(r, op); err != nil {
+				s.respondNotOK(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+				return
+			}
+		}
+
+		if !s.validateResponses || !op.captureResponse {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &capturingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if err := s.openAPI.validateResponse(rec.status, rec.body.Bytes(), op); err != nil {
+			slog.Error("response doesn't match openapi spec", "method", r.Method, "path", path, "status", rec.status, "err", err)
+		}
+	})
+}
+
+type capturingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *capturingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	if w.body.Len() < maxValidatedBytes {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
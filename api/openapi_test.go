@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPICoversAllRoutes(t *testing.T) {
+	t.Parallel()
+
+	v, err := newOpenAPIValidator()
+	require.NoError(t, err)
+
+	r := setupRouter(&Server{})
+	require.NoError(t, r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		require.NoError(t, err)
+
+		methods, err := route.GetMethods()
+		require.NoError(t, err, "route %s has no methods", path)
+
+		for _, m := range methods {
+			_, ok := v.operation(path, m)
+			assert.True(t, ok, "%s %s is missing in openapi.json", m, path)
+		}
+		return nil
+	}))
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	setupRouter(&Server{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var doc map[string]interface{}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&doc))
+	assert.Equal(t, "3.1.0", doc["openapi"])
+}
+
+func TestOpenAPIRequestValidation(t *testing.T) {
+	t.Parallel()
+
+	r := setupRouter(&Server{validateRequests: true})
+
+	for name, tc := range map[string]struct {
+		method string
+		target string
+		body   string
+		errMsg string
+	}{
+		"missing last name": {
+			method: http.MethodPost,
+			target: "/users",
+			body:   `{"first_name": "Boba"}`,
+			errMsg: "missing properties: 'last_name'",
+		},
+		"empty body": {
+			method: http.MethodPost,
+			target: "/users",
+			errMsg: "request body is required",
+		},
+		"malformed user id": {
+			method: http.MethodGet,
+			target: "/users/boba",
+			errMsg: "path parameter id",
+		},
+		"malformed last event id": {
+			method: http.MethodGet,
+			target: "/users/events?last_event_id=latest",
+			errMsg: "query parameter last_event_id",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body)))
+
+			require.Equal(t, http.StatusBadRequest, rec.Code)
+
+			var errResp statusResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&errResp))
+			assert.Contains(t, errResp.Text, tc.errMsg)
+		})
+	}
+}
+
+func TestOpenAPIResponseValidation(t *testing.T) {
+	t.Parallel()
+
+	v, err := newOpenAPIValidator()
+	require.NoError(t, err)
+
+	op, ok := v.operation("/users/{id}", http.MethodGet)
+	require.True(t, ok)
+
+	valid := `{"id": "8d6f1ad6-7d5e-4b43-a87f-1bd7e6b8f0a4", "first_name": "Jango", "last_name": "Fett", "created_at": "2023-11-05T10:00:00Z"}`
+	assert.NoError(t, v.validateResponse(http.StatusOK, []byte(valid), op))
+	assert.ErrorContains(t, v.validateResponse(http.StatusOK, []byte(`{"id": "8d6f1ad6-7d5e-4b43-a87f-1bd7e6b8f0a4"}`), op), "missing properties")
+	assert.ErrorContains(t, v.validateResponse(http.StatusTeapot, nil, op), "undocumented status 418")
+}
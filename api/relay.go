@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/api/external/changelog"
+	"github.com/andyklimenko/testify-usage-example/api/storage"
+)
+
+const (
+	defaultRelayInterval = time.Second
+	relayBatchSize       = 100
+)
+
+// errBatchFilling holds notifications back while there's time left for their batch to fill up
+var errBatchFilling = errors.New("notification batch is filling up")
+
+// batchNotifier is a changelog that takes several notifications in one request
+type batchNotifier interface {
+	Batching() changelog.BatchPolicy
+	NotifyBatch(ctx context.Context, events []entity.UserEvent) []error
+}
+
+// startRelay delivers the outbox to the changelog in the background until the returned func is called.
+// Besides polling every relayInterval, or more often if a batch may wait less, it wakes up as soon as
+// this server changes a user.
+func (s *Server) startRelay() func() {
+	interval := s.relayInterval
+	if interval <= 0 {
+		interval = defaultRelayInterval
+	}
+	if w := s.batching().MaxWait; w > 0 && w < interval {
+		interval = w
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.relayPending(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-s.relayKick:
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// kickRelay makes the relays look into the outbox, the webhook deliveries and the event log right away.
+// Changes are recorded along with their notifications when they're committed, so this is all there's left to do.
+func (s *Server) kickRelay() {
+	for _, kick := range []chan struct{}{s.relayKick, s.webhookKick, s.eventsKick} {
+		select {
+		case kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Server) relayPending(ctx context.Context) {
+	limit := max(relayBatchSize, s.batching().MaxSize)
+	for ctx.Err() == nil {
+		n, err := s.repo.RelayOutbox(ctx, limit, func(events []entity.UserEvent) []error {
+			return s.deliverPending(ctx, events)
+		})
+		if errors.Is(err, errBatchFilling) {
+			return
+		}
+		if err != nil {
+			slog.Error("relaying user notifications", "sent", n, "err", err)
+			return
+		}
+
+		if n < limit {
+			return
+		}
+	}
+}
+
+// batching is zero unless the changelog takes notifications in batches
+func (s *Server) batching() changelog.BatchPolicy {
+	if bn, ok := s.userChangelog.(batchNotifier); ok {
+		return bn.Batching()
+	}
+
+	return changelog.BatchPolicy{}
+}
+
+// deliverPending delivers the notifications in order, in batches if the changelog takes them,
+// and tells the outcome of each of them. It stops at the first failure that isn't a dead letter,
+// the later notifications of a user with a dead letter become dead letters without being sent.
+func (s *Server) deliverPending(ctx context.Context, events []entity.UserEvent) []error {
+	errs := make([]error, len(events))
+
+	bn, ok := s.userChangelog.(batchNotifier)
+	if !ok || bn.Batching().MaxSize <= 1 {
+		// the notifications of a user after its dead letter would overtake it
+		held := make(map[string]bool)
+		for i, e := range events {
+			if held[e.User.ID] {
+				errs[i] = storage.DeadLetter(storage.ErrHeldBack, 0)
+				continue
+			}
+
+			errs[i] = s.deliverOrDeadLetter(ctx, e)
+			if storage.IsDeadLetter(errs[i]) {
+				held[e.User.ID] = true
+			} else if errs[i] != nil {
+				break
+			}
+		}
+		return errs
+	}
+
+	if s.holdBatch(bn.Batching(), len(events)) {
+		for i := range errs {
+			errs[i] = errBatchFilling
+		}
+		return errs
+	}
+
+	// the receiver may have taken the later notifications of a user along with the rejected one,
+	// they're sent again once it's replayed
+	held := make(map[string]bool)
+	for i, err := range bn.NotifyBatch(ctx, events) {
+		if held[events[i].User.ID] {
+			errs[i] = storage.DeadLetter(storage.ErrHeldBack, 0)
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		if errs[i] = s.deadLetterFailed(ctx, events[i], err); !storage.IsDeadLetter(errs[i]) {
+			break
+		}
+		held[events[i].User.ID] = true
+	}
+
+	return errs
+}
+
+// holdBatch tells whether pending notifications should wait for more to fill their batch up.
+// Only the relay goroutine calls it.
+func (s *Server) holdBatch(p changelog.BatchPolicy, pending int) bool {
+	if pending >= p.MaxSize || p.MaxWait <= 0 {
+		s.batchHeldSince = time.Time{}
+		return false
+	}
+
+	if s.batchHeldSince.IsZero() {
+		s.batchHeldSince = time.Now()
+	}
+	if time.Since(s.batchHeldSince) < p.MaxWait {
+		return true
+	}
+
+	s.batchHeldSince = time.Time{}
+	return false
+}
+
+// deliverOrDeadLetter gives up on a notification the changelog failed to take even after its retries,
+// unless the relay itself is stopping or the changelog is considered down
+func (s *Server) deliverOrDeadLetter(ctx context.Context, e entity.UserEvent) error {
+	return s.deadLetterFailed(ctx, e, s.deliverNotification(ctx, e))
+}
+
+// deadLetterFailed marks the failure of the notification as final, see deliverOrDeadLetter
+func (s *Server) deadLetterFailed(ctx context.Context, e entity.UserEvent, err error) error {
+	if err == nil || ctx.Err() != nil || errors.Is(err, changelog.ErrCircuitOpen) || errors.Is(err, changelog.ErrNotSent) {
+		return err
+	}
+
+	attempts := 1
+	var counted interface{ Attempts() int }
+	if errors.As(err, &counted) {
+		attempts = counted.Attempts()
+	}
+
+	slog.Error("notification failed for good, dead-lettering it", "seq", e.Seq, "attempts", attempts, "err", err)
+	return storage.DeadLetter(err, attempts)
+}
+
+func (s *Server) deliverNotification(ctx context.Context, e entity.UserEvent) error {
+	switch e.Type {
+	case entity.EventUserCreated:
+		return s.userChangelog.UserCreated(ctx, e)
+	case entity.EventUserUpdated:
+		return s.userChangelog.UserUpdated(ctx, e)
+	case entity.EventUserDeleted:
+		return s.userChangelog.UserDeleted(ctx, e)
+	}
+
+	return fmt.Errorf("unknown notification type %q", e.Type)
+}
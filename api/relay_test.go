@@ -0,0 +1,430 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/api/external/changelog"
+	"github.com/andyklimenko/testify-usage-example/api/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type batchOutboxRepo struct {
+	repo
+	batches []int
+	calls   int
+}
+
+func (r *batchOutboxRepo) RelayOutbox(_ context.Context, _ int, _ func(events []entity.UserEvent) []error) (int, error) {
+	n := r.batches[r.calls]
+	r.calls++
+	if n < 0 {
+		return 0, errors.New("connection refused")
+	}
+	return n, nil
+}
+
+func TestRelayPendingDrainsFullBatches(t *testing.T) {
+	t.Parallel()
+
+	r := &batchOutboxRepo{batches: []int{relayBatchSize, relayBatchSize, 3, relayBatchSize}}
+	(&Server{repo: r}).relayPending(context.Background())
+	assert.Equal(t, 3, r.calls)
+
+	r = &batchOutboxRepo{batches: []int{relayBatchSize, -1, relayBatchSize}}
+	(&Server{repo: r}).relayPending(context.Background())
+	assert.Equal(t, 2, r.calls, "a failure waits for the next round")
+}
+
+func TestDeliverUnknownNotification(t *testing.T) {
+	t.Parallel()
+
+	err := (&Server{}).deliverNotification(context.Background(), entity.UserEvent{Type: "RENAMED"})
+	assert.EqualError(t, err, `unknown notification type "RENAMED"`)
+}
+
+func TestDeliverOrDeadLetter(t *testing.T) {
+	t.Parallel()
+
+	var cl mockedChangelog
+	cl.On("UserCreated", mock.Anything).Return(errors.New("receiver is down")).Once()
+	srv := &Server{userChangelog: &cl}
+
+	err := srv.deliverOrDeadLetter(context.Background(), entity.UserEvent{Type: entity.EventUserCreated})
+	require.Error(t, err)
+	assert.EqualError(t, errors.Unwrap(err), "receiver is down", "the failure is a dead letter")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cl.On("UserCreated", mock.Anything).Return(context.Canceled).Once()
+	err = srv.deliverOrDeadLetter(ctx, entity.UserEvent{Type: entity.EventUserCreated})
+	assert.Equal(t, context.Canceled, err, "a stopping relay tries again later")
+
+	cl.On("UserCreated", mock.Anything).Return(changelog.ErrCircuitOpen).Once()
+	err = srv.deliverOrDeadLetter(context.Background(), entity.UserEvent{Type: entity.EventUserCreated})
+	assert.Equal(t, changelog.ErrCircuitOpen, err, "the relay waits for the changelog to come back")
+}
+
+func TestDeliverPendingHoldsBackUser(t *testing.T) {
+	t.Parallel()
+
+	var cl mockedChangelog
+	rejected := entity.User{ID: "rejected"}
+	cl.On("UserCreated", rejected).Return(errors.New("rejected")).Once()
+	cl.On("UserCreated", entity.User{ID: "other"}).Return(nil).Once()
+	srv := &Server{userChangelog: &cl}
+
+	errs := srv.deliverPending(context.Background(), []entity.UserEvent{
+		{Seq: 1, Type: entity.EventUserCreated, User: rejected},
+		{Seq: 2, Type: entity.EventUserCreated, User: entity.User{ID: "other"}},
+		{Seq: 3, Type: entity.EventUserDeleted, User: rejected},
+	})
+	assert.True(t, storage.IsDeadLetter(errs[0]))
+	assert.NoError(t, errs[1], "other users go on")
+	assert.True(t, storage.IsDeadLetter(errs[2]))
+	assert.ErrorIs(t, errs[2], storage.ErrHeldBack, "the later event of the user isn't sent")
+	cl.AssertExpectations(t)
+}
+
+// batchChangelog takes notifications in batches and answers every batch with errs
+type batchChangelog struct {
+	mockedChangelog
+	policy  changelog.BatchPolicy
+	batches [][]entity.UserEvent
+	errs    []error
+}
+
+func (c *batchChangelog) Batching() changelog.BatchPolicy {
+	return c.policy
+}
+
+func (c *batchChangelog) NotifyBatch(_ context.Context, events []entity.UserEvent) []error {
+	c.batches = append(c.batches, events)
+	if c.errs == nil {
+		return make([]error, len(events))
+	}
+	return c.errs
+}
+
+func TestDeliverPendingInBatches(t *testing.T) {
+	t.Parallel()
+
+	cl := &batchChangelog{policy: changelog.BatchPolicy{MaxSize: 4, MaxWait: time.Hour}}
+	srv := &Server{userChangelog: cl}
+	events := []entity.UserEvent{{Seq: 1, User: entity.User{ID: "1"}}, {Seq: 2, User: entity.User{ID: "2"}},
+		{Seq: 3, User: entity.User{ID: "3"}}, {Seq: 4, User: entity.User{ID: "4"}}}
+
+	for _, err := range srv.deliverPending(context.Background(), events[:2]) {
+		assert.ErrorIs(t, err, errBatchFilling)
+	}
+	assert.Empty(t, cl.batches, "a batch waits to fill up")
+
+	srv.batchHeldSince = time.Now().Add(-time.Hour)
+	assert.Equal(t, make([]error, 2), srv.deliverPending(context.Background(), events[:2]), "unless it's waited enough")
+
+	cl.errs = []error{nil, errors.New("rejected"), changelog.ErrNotSent, changelog.ErrNotSent}
+	errs := srv.deliverPending(context.Background(), events)
+	require.Len(t, cl.batches, 2)
+	assert.Equal(t, events, cl.batches[1], "a full batch goes right away")
+	assert.NoError(t, errs[0])
+	assert.True(t, storage.IsDeadLetter(errs[1]))
+	assert.ErrorIs(t, errs[2], changelog.ErrNotSent, "the relay stops there")
+}
+
+func TestDeliverPendingHoldsBackUserInPartialBatch(t *testing.T) {
+	t.Parallel()
+
+	rejected, other := entity.User{ID: "rejected"}, entity.User{ID: "other"}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") == "application/json" {
+			// the batch goes in a JSON array, the first one is resent alone
+			var batch []json.RawMessage
+			if json.NewDecoder(r.Body).Decode(&batch) == nil {
+				w.WriteHeader(http.StatusMultiStatus)
+				fmt.Fprint(w, `{"results": [{"status": 503}, {"status": 200}, {"status": 200}]}`)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+
+	cl := changelog.New(receiver.URL, changelog.WithRetry(changelog.RetryPolicy{}),
+		changelog.WithBatching(changelog.BatchPolicy{MaxSize: 3}))
+	srv := &Server{userChangelog: cl}
+
+	errs := srv.deliverPending(context.Background(), []entity.UserEvent{
+		{Seq: 1, Type: entity.EventUserCreated, User: rejected},
+		{Seq: 2, Type: entity.EventUserCreated, User: other},
+		{Seq: 3, Type: entity.EventUserDeleted, User: rejected},
+	})
+	require.Len(t, errs, 3)
+	assert.True(t, storage.IsDeadLetter(errs[0]))
+	assert.NoError(t, errs[1], "other users go on")
+	assert.True(t, storage.IsDeadLetter(errs[2]))
+	assert.ErrorIs(t, errs[2], storage.ErrHeldBack, "the later event of the user waits for the replay though it's been taken")
+}
+
+func (s *srvSuite) TestFailedNotificationsAreDeadLettered() {
+	_, err := s.repo.PurgeDeadLetters(context.Background())
+	s.Require().NoError(err)
+
+	var cl mockedChangelog
+	delivered := make(chan entity.User, 2)
+	record := func(args mock.Arguments) { delivered <- args.Get(0).(entity.User) }
+	cl.On("UserCreated", mock.Anything).Return(errors.New("receiver is down")).Run(record).Once()
+	cl.On("UserCreated", mock.Anything).Return(nil).Run(record).Once()
+
+	srvURL, closer := s.setupServer(&cl)
+	defer closer()
+
+	created, err := s.createTestUser(srvURL, entity.User{FirstName: "Kuiil", LastName: "Ugnaught"})
+	require.NoError(s.T(), err)
+
+	receive := func() {
+		select {
+		case <-time.After(time.Second):
+			s.T().Fatal("timeout")
+		case u := <-delivered:
+			assert.Equal(s.T(), created.ID, u.ID)
+		}
+	}
+	receive()
+
+	var page entity.DeadLetterPage
+	s.Require().Eventually(func() bool {
+		resp, err := s.httpCli.Get(srvURL + "/admin/dead-letters")
+		s.Require().NoError(err)
+		defer resp.Body.Close()
+
+		s.Require().Equal(http.StatusOK, resp.StatusCode)
+		s.Require().NoError(json.NewDecoder(resp.Body).Decode(&page))
+		return len(page.Items) == 1
+	}, time.Second, 20*time.Millisecond)
+	assert.Equal(s.T(), created.ID, page.Items[0].Event.User.ID)
+	assert.Equal(s.T(), "receiver is down", page.Items[0].LastError)
+
+	resp, err := s.httpCli.Post(fmt.Sprintf("%s/admin/dead-letters/%d/replay", srvURL, page.Items[0].ID), "", nil)
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	receive()
+
+	n, err := s.repo.CountDeadLetters(context.Background())
+	s.Require().NoError(err)
+	assert.Zero(s.T(), n)
+	cl.AssertExpectations(s.T())
+}
+
+func (s *srvSuite) TestRelayClaimsOutbox() {
+	s.drainOutbox()
+	ctx := context.Background()
+	u, err := s.repo.InsertUser(ctx, entity.User{FirstName: "Greef", LastName: "Karga"})
+	s.Require().NoError(err)
+
+	// a failed delivery gives the claim up right away
+	n, err := s.repo.RelayOutbox(ctx, relayBatchSize, func(events []entity.UserEvent) []error {
+		errs := make([]error, len(events))
+		for i := range errs {
+			errs[i] = errors.New("receiver is down")
+		}
+		return errs
+	})
+	s.Require().ErrorContains(err, "receiver is down")
+	s.Zero(n)
+
+	n, err = s.repo.RelayOutbox(ctx, relayBatchSize, func(events []entity.UserEvent) []error {
+		s.Require().Len(events, 1)
+		s.Equal(u.ID, events[0].User.ID)
+
+		// another relay gets nothing while the claim is held, and nothing is locked meanwhile
+		n, err := s.repo.RelayOutbox(ctx, relayBatchSize, func(events []entity.UserEvent) []error {
+			s.Fail("notifications are claimed twice")
+			return make([]error, len(events))
+		})
+		s.NoError(err)
+		s.Zero(n)
+		s.NoError(s.repo.DeleteUser(ctx, u.ID))
+
+		return make([]error, len(events))
+	})
+	s.Require().NoError(err)
+	s.Equal(1, n)
+
+	n, err = s.repo.RelayOutbox(ctx, relayBatchSize, func(events []entity.UserEvent) []error {
+		s.Require().Len(events, 1)
+		s.Equal(entity.EventUserDeleted, events[0].Type)
+		return make([]error, len(events))
+	})
+	s.Require().NoError(err)
+	s.Equal(1, n)
+}
+
+func (s *srvSuite) TestReplayedDeadLetterKeepsSeq() {
+	s.drainOutbox()
+	ctx := context.Background()
+	_, err := s.repo.PurgeDeadLetters(ctx)
+	s.Require().NoError(err)
+
+	_, err = s.repo.InsertUser(ctx, entity.User{FirstName: "Migs", LastName: "Mayfeld"})
+	s.Require().NoError(err)
+
+	var seq int64
+	n, err := s.repo.RelayOutbox(ctx, relayBatchSize, func(events []entity.UserEvent) []error {
+		s.Require().Len(events, 1)
+		seq = events[0].Seq
+		return []error{storage.DeadLetter(errors.New("rejected"), 1)}
+	})
+	s.Require().NoError(err)
+	s.Equal(1, n)
+
+	replayed, err := s.repo.ReplayDeadLetters(ctx)
+	s.Require().NoError(err)
+	s.Equal(1, replayed)
+
+	// the changelog gets the same event once again, down to its CloudEvents id
+	n, err = s.repo.RelayOutbox(ctx, relayBatchSize, func(events []entity.UserEvent) []error {
+		s.Require().Len(events, 1)
+		s.Equal(seq, events[0].Seq)
+		return make([]error, len(events))
+	})
+	s.Require().NoError(err)
+	s.Equal(1, n)
+}
+
+func (s *srvSuite) TestDeadLetterHoldsBackUser() {
+	s.drainOutbox()
+	ctx := context.Background()
+	_, err := s.repo.PurgeDeadLetters(ctx)
+	s.Require().NoError(err)
+
+	u, err := s.repo.InsertUser(ctx, entity.User{FirstName: "Omera", LastName: "Sorgan"})
+	s.Require().NoError(err)
+	_, err = s.repo.UpdateUser(ctx, u.ID, entity.User{FirstName: "Omera", LastName: "of Sorgan"})
+	s.Require().NoError(err)
+
+	n, err := s.repo.RelayOutbox(ctx, relayBatchSize, func(events []entity.UserEvent) []error {
+		s.Require().Len(events, 2)
+		return []error{storage.DeadLetter(errors.New("rejected"), 1), errors.New("receiver is down")}
+	})
+	s.Require().ErrorContains(err, "receiver is down")
+	s.Equal(1, n)
+
+	// the update would overtake the creation
+	n, err = s.repo.RelayOutbox(ctx, relayBatchSize, func(events []entity.UserEvent) []error {
+		s.Fail("a held back notification is delivered")
+		return make([]error, len(events))
+	})
+	s.Require().NoError(err)
+	s.Zero(n)
+
+	held, err := s.repo.DeadLetters(ctx, 10, 0)
+	s.Require().NoError(err)
+	s.Require().Len(held, 2)
+	s.Equal(int64(2), held[1].Event.UserSeq)
+	s.Equal(storage.ErrHeldBack.Error(), held[1].LastError)
+
+	_, err = s.repo.ReplayDeadLetters(ctx)
+	s.Require().NoError(err)
+
+	n, err = s.repo.RelayOutbox(ctx, relayBatchSize, func(events []entity.UserEvent) []error {
+		s.Require().Len(events, 2)
+		s.Equal(int64(1), events[0].UserSeq)
+		s.Equal(int64(2), events[1].UserSeq)
+		return make([]error, len(events))
+	})
+	s.Require().NoError(err)
+	s.Equal(2, n)
+}
+
+// orderedChangelog records the notifications it gets in the order they come
+type orderedChangelog struct {
+	mu     sync.Mutex
+	events []entity.UserEvent
+}
+
+func (c *orderedChangelog) record(e entity.UserEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.events = append(c.events, e)
+	return nil
+}
+
+func (c *orderedChangelog) UserCreated(_ context.Context, e entity.UserEvent) error {
+	return c.record(e)
+}
+
+func (c *orderedChangelog) UserUpdated(_ context.Context, e entity.UserEvent) error {
+	return c.record(e)
+}
+
+func (c *orderedChangelog) UserDeleted(_ context.Context, e entity.UserEvent) error {
+	return c.record(e)
+}
+
+func (c *orderedChangelog) ofUser(id string) []entity.UserEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var res []entity.UserEvent
+	for _, e := range c.events {
+		if e.User.ID == id {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+func (s *srvSuite) TestEventsOfUserKeepOrder() {
+	var cl orderedChangelog
+	srvURL, closer := s.setupServer(&cl)
+	defer closer()
+
+	stream := s.openEventStream(srvURL+"/users/events", 0)
+	defer stream.close()
+
+	created, err := s.createTestUser(srvURL, entity.User{FirstName: "Mon", LastName: "Mothma"})
+	require.NoError(s.T(), err)
+
+	for _, lastName := range []string{"Chandrila", "Rebellion"} {
+		body, err := json.Marshal(entity.User{FirstName: "Mon", LastName: lastName})
+		require.NoError(s.T(), err)
+
+		req, err := http.NewRequest(http.MethodPut, srvURL+"/users/"+created.ID, bytes.NewReader(body))
+		require.NoError(s.T(), err)
+
+		resp, err := s.httpCli.Do(req)
+		require.NoError(s.T(), err)
+		entity.CloseBody(resp.Body)
+		require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	}
+
+	require.Eventually(s.T(), func() bool {
+		return len(cl.ofUser(created.ID)) == 3
+	}, time.Second, 10*time.Millisecond)
+
+	for i, e := range cl.ofUser(created.ID) {
+		assert.Equal(s.T(), int64(i+1), e.UserSeq)
+	}
+	assert.Equal(s.T(), entity.EventUserCreated, cl.ofUser(created.ID)[0].Type)
+
+	// live events come in the same order with the same numbers
+	for i := 1; i <= 3; i++ {
+		var e entity.UserEvent
+		require.NoError(s.T(), json.Unmarshal([]byte(stream.next(s.T()).data), &e))
+		assert.Equal(s.T(), created.ID, e.User.ID)
+		assert.Equal(s.T(), int64(i), e.UserSeq)
+	}
+}
@@ -16,17 +16,18 @@ func (s *Server) respondNotOK(w http.ResponseWriter, statusCode int, err error)
 		Code: statusCode,
 		Text: err.Error(),
 	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		slog.Error("marshal error response", err)
+		slog.Error("marshal error response", "err", err)
 		return
 	}
 }
 
 func (s *Server) respondOK(w http.ResponseWriter, statusCode int, resp interface{}) {
-	w.WriteHeader(statusCode)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
 	if resp == nil {
 		return
 	}
@@ -34,6 +35,6 @@ func (s *Server) respondOK(w http.ResponseWriter, statusCode int, resp interface
 	e := json.NewEncoder(w)
 	e.SetEscapeHTML(false)
 	if err := e.Encode(resp); err != nil {
-		slog.Error("marshal response", err)
+		slog.Error("marshal response", "err", err)
 	}
 }
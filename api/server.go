@@ -3,44 +3,112 @@ package api
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/api/storage"
 	"github.com/andyklimenko/testify-usage-example/config"
 	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
 )
 
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var errBadRequest = errors.New("bad request")
+
 type repo interface {
 	InsertUser(ctx context.Context, u entity.User) (entity.User, error)
 	UserByID(ctx context.Context, id string) (entity.User, error)
-	UpdateUser(ctx context.Context, id string, u entity.User) (entity.User, error)
+	UpdateUser(ctx context.Context, id string, u entity.User) (entity.UserUpdate, error)
 	DeleteUser(ctx context.Context, id string) error
+	LastUserEventPosition(ctx context.Context) (entity.EventPosition, error)
+	UserEventPosition(ctx context.Context, seq int64) (entity.EventPosition, error)
+	UserEventsAfter(ctx context.Context, after entity.EventPosition, limit int) ([]entity.UserEvent, error)
+	UserEventsHeldBack(ctx context.Context, seq int64) ([]entity.UserEvent, error)
+	RelayOutbox(ctx context.Context, limit int, deliver func(events []entity.UserEvent) []error) (int, error)
+	DeadLetters(ctx context.Context, limit, offset int) ([]entity.DeadLetter, error)
+	DeadLetterByID(ctx context.Context, id int64) (entity.DeadLetter, error)
+	CountDeadLetters(ctx context.Context) (int, error)
+	ReplayDeadLetter(ctx context.Context, id int64) error
+	ReplayDeadLetters(ctx context.Context) (int, error)
+	PurgeDeadLetters(ctx context.Context) (int, error)
+	InsertWebhook(ctx context.Context, w entity.Webhook) (entity.Webhook, error)
+	Webhooks(ctx context.Context) ([]entity.Webhook, error)
+	WebhookByID(ctx context.Context, id string) (entity.Webhook, error)
+	RotateWebhookSecret(ctx context.Context, id, secret string, grace time.Duration) (entity.Webhook, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	DueWebhooks(ctx context.Context) ([]string, error)
+	RelayWebhook(ctx context.Context, id string, limit int, deliver func(w entity.Webhook, e entity.UserEvent) error) (int, error)
+	WebhookDeadLetters(ctx context.Context, id string, limit, offset int) ([]entity.DeadLetter, error)
+	ReplayWebhookDeadLetters(ctx context.Context, id string) (int, error)
+	UsersByIDs(ctx context.Context, ids []string) ([]entity.User, error)
+	ListUsers(ctx context.Context, f entity.UserFilter) ([]entity.User, error)
+	SearchUsers(ctx context.Context, q entity.UserSearch) ([]entity.UserMatch, error)
+	Ping(ctx context.Context) error
+	// WithTx runs fn in a transaction joined by the calls made with fn's ctx
+	WithTx(ctx context.Context, fn func(ctx context.Context) error, opts ...storage.TxOption) error
+	// AfterCommit defers fn until the transaction of ctx commits
+	AfterCommit(ctx context.Context, fn func())
 }
 
 type userChangelog interface {
-	UserCreated(u entity.User) error
-	UserUpdated(u entity.User) error
-	UserDeleted(u entity.User) error
+	UserCreated(ctx context.Context, e entity.UserEvent) error
+	UserUpdated(ctx context.Context, e entity.UserEvent) error
+	UserDeleted(ctx context.Context, e entity.UserEvent) error
 }
 
 type Server struct {
 	httpSrv       *http.Server
 	repo          repo
 	userChangelog userChangelog
+	events        eventHub
+	tokens        []string
+	// allowedOrigins may open a websocket besides the server's own origin
+	allowedOrigins []string
+	shuttingDown   atomic.Bool
+	// pinPrimaryFor is zero without replicas
+	pinPrimaryFor time.Duration
+	graphqlSchema graphql.Schema
+
+	relayInterval time.Duration
+	relayKick     chan struct{}
+	webhooks      webhookSender
+	// allowPrivateWebhooks lets webhooks target loopback, link-local and private addresses
+	allowPrivateWebhooks bool
+	webhookKick          chan struct{}
+	eventsKick           chan struct{}
+	// batchHeldSince is when the relay started holding a batch back to fill it up
+	batchHeldSince time.Time
+
+	openAPI           *openAPIValidator
+	validateRequests  bool
+	validateResponses bool
 }
 
 func (s *Server) Start() error {
+	stopRelay := s.startRelay()
+	defer stopRelay()
+	stopWebhooks := s.startWebhooks()
+	defer stopWebhooks()
+	stopEventTail := s.startEventTail()
+	defer stopEventTail()
+
 	termCh := make(chan os.Signal, 1)
 	signal.Notify(termCh, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-termCh
 		if err := s.stop(); err != nil {
-			slog.Error("failed to stop the server gracefully", err)
+			slog.Error("failed to stop the server gracefully", "err", err)
 		}
 	}()
 
@@ -52,6 +120,11 @@ func (s *Server) Start() error {
 }
 
 func (s *Server) stop() error {
+	s.shuttingDown.Store(true)
+
+	// event streams never end on their own, so let them go first
+	s.events.close()
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 
@@ -59,20 +132,75 @@ func (s *Server) stop() error {
 }
 
 func setupRouter(s *Server) *mux.Router {
+	schema, err := newGraphQLSchema(s)
+	if err != nil {
+		panic(fmt.Errorf("graphql schema: %w", err))
+	}
+	s.graphqlSchema = schema
+
 	r := mux.NewRouter()
 
 	r.HandleFunc("/users", s.createUser).Methods(http.MethodPost)
+	r.HandleFunc("/users", s.listUsers).Methods(http.MethodGet)
+	r.HandleFunc("/users/events", s.streamUserEvents).Methods(http.MethodGet)
+	r.HandleFunc("/users/search", s.searchUsers).Methods(http.MethodGet)
 	r.HandleFunc("/users/{id}", s.getUser).Methods(http.MethodGet)
 	r.HandleFunc("/users/{id}", s.updateUser).Methods(http.MethodPut)
 	r.HandleFunc("/users/{id}", s.deleteUser).Methods(http.MethodDelete)
+	r.HandleFunc("/ws", s.watchUsers).Methods(http.MethodGet)
+	r.HandleFunc("/graphql", s.serveGraphQL).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/openapi.json", s.serveOpenAPI).Methods(http.MethodGet)
+	r.HandleFunc("/admin/dead-letters", s.adminOnly(s.listDeadLetters)).Methods(http.MethodGet)
+	r.HandleFunc("/admin/dead-letters", s.adminOnly(s.purgeDeadLetters)).Methods(http.MethodDelete)
+	r.HandleFunc("/admin/dead-letters/replay", s.adminOnly(s.replayDeadLetters)).Methods(http.MethodPost)
+	r.HandleFunc("/admin/dead-letters/{id}", s.adminOnly(s.getDeadLetter)).Methods(http.MethodGet)
+	r.HandleFunc("/admin/dead-letters/{id}/replay", s.adminOnly(s.replayDeadLetter)).Methods(http.MethodPost)
+	r.HandleFunc("/webhooks", s.adminOnly(s.createWebhook)).Methods(http.MethodPost)
+	r.HandleFunc("/webhooks", s.adminOnly(s.listWebhooks)).Methods(http.MethodGet)
+	r.HandleFunc("/webhooks/{id}", s.adminOnly(s.getWebhook)).Methods(http.MethodGet)
+	r.HandleFunc("/webhooks/{id}", s.adminOnly(s.deleteWebhook)).Methods(http.MethodDelete)
+	r.HandleFunc("/webhooks/{id}/rotate-secret", s.adminOnly(s.rotateWebhookSecret)).Methods(http.MethodPost)
+	r.HandleFunc("/webhooks/{id}/dead-letters", s.adminOnly(s.listWebhookDeadLetters)).Methods(http.MethodGet)
+	r.HandleFunc("/webhooks/{id}/dead-letters/replay", s.adminOnly(s.replayWebhookDeadLetters)).Methods(http.MethodPost)
+	r.Handle("/metrics", s.metricsHandler()).Methods(http.MethodGet)
+	r.HandleFunc("/healthz", s.healthz).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", s.readyz).Methods(http.MethodGet)
+
+	if s.pinPrimaryFor > 0 {
+		r.Use(s.readYourWrites)
+	}
+
+	if s.validateRequests || s.validateResponses {
+		validator, err := newOpenAPIValidator()
+		if err != nil {
+			panic(fmt.Errorf("openapi spec: %w", err))
+		}
+		s.openAPI = validator
+		r.Use(s.validateOpenAPI)
+	}
 
 	return r
 }
 
 func New(cfg config.Config, s repo, changelog userChangelog) *Server {
 	srv := &Server{
-		repo:          s,
-		userChangelog: changelog,
+		repo:                 s,
+		userChangelog:        changelog,
+		tokens:               cfg.Server.Tokens,
+		allowedOrigins:       cfg.Server.AllowedOrigins,
+		relayInterval:        cfg.Notify.RelayInterval,
+		relayKick:            make(chan struct{}, 1),
+		webhooks:             newWebhookSender(cfg.Notify),
+		allowPrivateWebhooks: cfg.Notify.WebhookAllowPrivate,
+		webhookKick:          make(chan struct{}, 1),
+		eventsKick:           make(chan struct{}, 1),
+
+		validateRequests:  cfg.Server.ValidateRequests,
+		validateResponses: cfg.Server.ValidateResponses,
+	}
+
+	if len(cfg.DB.ReplicaDSNs) > 0 {
+		srv.pinPrimaryFor = cfg.DB.PinPrimaryFor
 	}
 
 	srv.httpSrv = &http.Server{
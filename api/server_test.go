@@ -1,18 +1,34 @@
 package api
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/api/external/webhook"
 	"github.com/andyklimenko/testify-usage-example/api/storage"
 	"github.com/andyklimenko/testify-usage-example/api/storage/database"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/suite"
 )
 
+// testToken guards the admin endpoints of the suite's servers, the suite's client sends it along with every request
+const testToken = "test-token"
+
+type withToken struct {
+	token string
+}
+
+func (t withToken) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
 type srvSuite struct {
 	suite.Suite
 
@@ -26,7 +42,7 @@ func TestServer(t *testing.T) {
 }
 
 func (s *srvSuite) SetupSuite() {
-	s.httpCli = &http.Client{Timeout: time.Second}
+	s.httpCli = &http.Client{Timeout: time.Second, Transport: withToken{token: testToken}}
 	db := database.DB()
 	s.repo = storage.New(db)
 }
@@ -35,12 +51,45 @@ func (s *srvSuite) setupServer(changelog userChangelog) (string, func()) {
 	srv := &Server{
 		repo:          s.repo,
 		userChangelog: changelog,
+		tokens:        []string{testToken},
+		relayInterval: 50 * time.Millisecond,
+		relayKick:     make(chan struct{}, 1),
+		webhooks:      webhook.New(webhook.WithPrivateTargets()),
+		// the receivers run on localhost
+		allowPrivateWebhooks: true,
+		webhookKick:          make(chan struct{}, 1),
+		eventsKick:           make(chan struct{}, 1),
 	}
 	testSrv := httptest.NewServer(setupRouter(srv))
 	srv.httpSrv = testSrv.Config
 
+	if changelog == nil {
+		return testSrv.URL, testSrv.Close
+	}
+
+	// notifications left by the previous tests must not reach this changelog
+	s.drainOutbox()
+	stopRelay := srv.startRelay()
+	stopWebhooks := srv.startWebhooks()
+	stopEventTail := srv.startEventTail()
+
 	return testSrv.URL, func() {
 		testSrv.Close()
+		stopRelay()
+		stopWebhooks()
+		stopEventTail()
+	}
+}
+
+func (s *srvSuite) drainOutbox() {
+	for {
+		n, err := s.repo.RelayOutbox(context.Background(), relayBatchSize, func(events []entity.UserEvent) []error {
+			return make([]error, len(events))
+		})
+		s.Require().NoError(err)
+		if n == 0 {
+			return
+		}
 	}
 }
 
@@ -1,10 +1,21 @@
 package database
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"log/slog"
+	"time"
 
+	"github.com/andyklimenko/testify-usage-example/config"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+)
+
+const (
+	connectBaseDelay = 250 * time.Millisecond
+	connectMaxDelay  = 5 * time.Second
 )
 
 func DbConnect(driver string, dsn string) (*sqlx.DB, error) {
@@ -18,3 +29,114 @@ func DbConnect(driver string, dsn string) (*sqlx.DB, error) {
 	}
 	return db, nil
 }
+
+// Connect opens the configured database with its pool settings and waits for it to come up
+// for cfg.ConnectWait, so the service may start before Postgres does
+func Connect(ctx context.Context, cfg config.DB) (*sqlx.DB, error) {
+	db, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pingWithRetry(ctx, db, cfg.ConnectTimeout, cfg.ConnectWait); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("ping db: %w", err)
+	}
+
+	return db, nil
+}
+
+// Open sets up the pool of the configured database without connecting to it
+func Open(cfg config.DB) (*sqlx.DB, error) {
+	db, err := open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect to db: %w", err)
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	return db, nil
+}
+
+func open(cfg config.DB) (*sqlx.DB, error) {
+	if cfg.Driver != "postgres" {
+		return sqlx.Open(cfg.Driver, cfg.DSN)
+	}
+
+	c, err := pq.NewConnector(cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	var connector driver.Connector = c
+	if cfg.StatementTimeout > 0 {
+		connector = sessionConnector{
+			Connector: c,
+			setup:     fmt.Sprintf("SET statement_timeout = %d", cfg.StatementTimeout.Milliseconds()),
+		}
+	}
+
+	return sqlx.NewDb(sql.OpenDB(connector), cfg.Driver), nil
+}
+
+// sessionConnector runs setup on every new connection before the pool hands it out
+type sessionConnector struct {
+	driver.Connector
+	setup string
+}
+
+func (c sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		return conn, nil
+	}
+
+	if _, err := execer.ExecContext(ctx, c.setup, nil); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("set up session: %w", err)
+	}
+
+	return conn, nil
+}
+
+func pingWithRetry(ctx context.Context, db *sqlx.DB, timeout, wait time.Duration) error {
+	deadline := time.Now().Add(wait)
+	delay := connectBaseDelay
+	for {
+		err := ping(ctx, db, timeout)
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		slog.Warn("database isn't available yet", "retry_in", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay = min(2*delay, connectMaxDelay)
+	}
+}
+
+func ping(ctx context.Context, db *sqlx.DB, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return db.PingContext(ctx)
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	qInsertDeadLetter = `INSERT INTO user_dead_letters(event_seq, event_type, user_id, payload, before, user_seq, last_error, attempts, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	qDeadLetters      = "SELECT * FROM user_dead_letters ORDER BY id LIMIT $1 OFFSET $2"
+	qDeadLetterByID   = "SELECT * FROM user_dead_letters WHERE id=$1"
+	qCountDeadLetters = "SELECT count(*) FROM user_dead_letters"
+	qPurgeDeadLetters = "DELETE FROM user_dead_letters"
+	// pending notifications of a user whose earlier one is a dead letter join it, unless another relay holds a claim
+	qHoldBackOutbox = `WITH held AS (
+			UPDATE user_outbox o SET sent_at = now() WHERE sent_at IS NULL
+				AND NOT EXISTS (SELECT 1 FROM user_outbox WHERE sent_at IS NULL AND claimed_until > now())
+				AND EXISTS (SELECT 1 FROM user_dead_letters d WHERE d.user_id = o.user_id AND d.user_seq < o.user_seq)
+			RETURNING COALESCE(event_seq, seq) AS event_seq, event_type, user_id, payload, before, user_seq, created_at
+		)
+		INSERT INTO user_dead_letters(event_seq, event_type, user_id, payload, before, user_seq, last_error, attempts, created_at)
+		SELECT event_seq, event_type, user_id, payload, before, user_seq, $1, 0, created_at FROM held`
+	// replaying puts notifications back into the outbox in the order of every user's changes,
+	// they keep the seq they got first
+	qReplayDeadLetters = `WITH replayed AS (DELETE FROM user_dead_letters RETURNING event_seq, event_type, user_id, payload, before, user_seq, created_at)
+		INSERT INTO user_outbox(event_seq, event_type, user_id, payload, before, user_seq, created_at)
+		SELECT event_seq, event_type, user_id, payload, before, user_seq, created_at FROM replayed ORDER BY user_seq, event_seq`
+	qReplayDeadLetter = `WITH replayed AS (DELETE FROM user_dead_letters WHERE id=$1
+			RETURNING event_seq, event_type, user_id, payload, before, user_seq, created_at)
+		INSERT INTO user_outbox(event_seq, event_type, user_id, payload, before, user_seq, created_at)
+		SELECT event_seq, event_type, user_id, payload, before, user_seq, created_at FROM replayed`
+)
+
+// ErrHeldBack is the failure of notifications held back behind an earlier dead letter of the same user
+var ErrHeldBack = errors.New("held back behind an earlier dead letter of the user")
+
+// deadLetterError is a final delivery failure
+type deadLetterError struct {
+	err      error
+	attempts int
+}
+
+func (e *deadLetterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *deadLetterError) Unwrap() error {
+	return e.err
+}
+
+// DeadLetter marks a delivery failure as final, RelayOutbox moves such a notification
+// to the dead letters and goes on with the next one
+func DeadLetter(err error, attempts int) error {
+	return &deadLetterError{err: err, attempts: attempts}
+}
+
+// IsDeadLetter tells whether the failure is marked as final with DeadLetter
+func IsDeadLetter(err error) bool {
+	var dl *deadLetterError
+	return errors.As(err, &dl)
+}
+
+type dbDeadLetter struct {
+	ID        int64     `db:"id"`
+	EventSeq  int64     `db:"event_seq"`
+	EventType string    `db:"event_type"`
+	UserID    string    `db:"user_id"`
+	Payload   []byte    `db:"payload"`
+	Before    []byte    `db:"before"`
+	UserSeq   int64     `db:"user_seq"`
+	LastError string    `db:"last_error"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+	FailedAt  time.Time `db:"failed_at"`
+}
+
+func (d dbDeadLetter) entity() (entity.DeadLetter, error) {
+	e, err := dbUserEvent{
+		Seq:       d.EventSeq,
+		EventType: d.EventType,
+		UserID:    d.UserID,
+		Payload:   d.Payload,
+		Before:    d.Before,
+		UserSeq:   d.UserSeq,
+		CreatedAt: d.CreatedAt,
+	}.entity()
+	if err != nil {
+		return entity.DeadLetter{}, err
+	}
+
+	return entity.DeadLetter{
+		ID:        d.ID,
+		Event:     e,
+		LastError: d.LastError,
+		Attempts:  d.Attempts,
+		FailedAt:  d.FailedAt,
+	}, nil
+}
+
+func insertDeadLetter(ctx context.Context, tx *sqlx.Tx, r dbOutboxEntry, failure *deadLetterError) error {
+	_, err := tx.ExecContext(ctx, qInsertDeadLetter, r.EventSeq, r.EventType, r.UserID, r.Payload, r.Before, r.UserSeq, failure.Error(), failure.attempts, r.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("dead-letter notification %d: %w", r.EventSeq, err)
+	}
+
+	return nil
+}
+
+func (s *Storage) DeadLetters(ctx context.Context, limit, offset int) ([]entity.DeadLetter, error) {
+	var rows []dbDeadLetter
+	if err := sqlx.SelectContext(ctx, s.ext(ctx), &rows, qDeadLetters, limit, offset); err != nil {
+		return nil, err
+	}
+
+	res := make([]entity.DeadLetter, 0, len(rows))
+	for _, r := range rows {
+		d, err := r.entity()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+
+	return res, nil
+}
+
+func (s *Storage) DeadLetterByID(ctx context.Context, id int64) (entity.DeadLetter, error) {
+	var row dbDeadLetter
+	if err := sqlx.GetContext(ctx, s.ext(ctx), &row, qDeadLetterByID, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = entity.ErrNotFound
+		}
+		return entity.DeadLetter{}, err
+	}
+
+	return row.entity()
+}
+
+func (s *Storage) CountDeadLetters(ctx context.Context) (int, error) {
+	var n int
+	err := sqlx.GetContext(ctx, s.ext(ctx), &n, qCountDeadLetters)
+	return n, err
+}
+
+// ReplayDeadLetter puts the notification back into the outbox, so it's delivered once again under its original seq
+func (s *Storage) ReplayDeadLetter(ctx context.Context, id int64) error {
+	res, err := s.ext(ctx).ExecContext(ctx, qReplayDeadLetter, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return entity.ErrNotFound
+	}
+
+	return nil
+}
+
+// ReplayDeadLetters puts every dead letter back into the outbox and returns how many there were
+func (s *Storage) ReplayDeadLetters(ctx context.Context) (int, error) {
+	return s.execCount(ctx, qReplayDeadLetters)
+}
+
+// PurgeDeadLetters drops every dead letter and returns how many there were
+func (s *Storage) PurgeDeadLetters(ctx context.Context) (int, error) {
+	return s.execCount(ctx, qPurgeDeadLetters)
+}
+
+func (s *Storage) execCount(ctx context.Context, query string) (int, error) {
+	res, err := s.ext(ctx).ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
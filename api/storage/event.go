@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/jmoiron/sqlx"
+)
+
+// logWatermark is the oldest transaction still writing, the log before it is final
+const logWatermark = "txid_snapshot_xmin(txid_current_snapshot())"
+
+const (
+	qNextUserSeq     = "INSERT INTO user_sequences(user_id, seq) VALUES($1, 1) ON CONFLICT (user_id) DO UPDATE SET seq = user_sequences.seq + 1 RETURNING seq"
+	qInsertUserEvent = "INSERT INTO user_events(seq, event_type, user_id, payload, user_seq) VALUES($1, $2, $3, $4, $5)"
+	// the log is read in the order of the transactions which recorded the events and only up to the watermark,
+	// so a transaction which commits later can't put anything before what's been read. An older transaction may
+	// still take the next user_seq of a user after a younger one commits, the flags tell where that happened.
+	qUserEventsAfter = `SELECT seq, event_type, user_id, payload, created_at, user_seq, xid,
+			EXISTS (SELECT 1 FROM user_events p WHERE p.user_id = e.user_id AND p.user_seq = e.user_seq - 1
+				AND (p.xid, p.seq) > (e.xid, e.seq)) AS held_back,
+			EXISTS (SELECT 1 FROM user_events n WHERE n.user_id = e.user_id AND n.user_seq = e.user_seq + 1
+				AND (n.xid, n.seq) < (e.xid, e.seq)) AS holds_back
+		FROM user_events e
+		WHERE (xid, seq) > ($1, $2) AND xid < ` + logWatermark + ` ORDER BY xid, seq LIMIT $3`
+	// every next event of the user which comes earlier in the log than the previous one, they are all final
+	// as the one they follow is
+	qUserEventsHeldBack = `WITH RECURSIVE held AS (
+			SELECT n.* FROM user_events n JOIN user_events e ON n.user_id = e.user_id AND n.user_seq = e.user_seq + 1
+				AND (n.xid, n.seq) < (e.xid, e.seq)
+			WHERE e.seq = $1
+			UNION ALL
+			SELECT n.* FROM user_events n JOIN held e ON n.user_id = e.user_id AND n.user_seq = e.user_seq + 1
+				AND (n.xid, n.seq) < (e.xid, e.seq)
+		)
+		SELECT seq, event_type, user_id, payload, created_at, user_seq, xid, TRUE AS held_back FROM held ORDER BY user_seq`
+	qLastUserEventPosition = `SELECT xid, seq FROM user_events WHERE xid < ` + logWatermark + ` ORDER BY xid DESC, seq DESC LIMIT 1`
+	// a held back event goes along with the first one of its user it waits for, reading on starts from there.
+	// The events recorded before the log kept transactions have zero xid.
+	qUserEventPosition = `WITH RECURSIVE waits AS (
+			SELECT user_id, user_seq, xid, seq FROM user_events WHERE seq = $1
+			UNION ALL
+			SELECT p.user_id, p.user_seq, p.xid, p.seq FROM user_events p JOIN waits e ON p.user_id = e.user_id
+				AND p.user_seq = e.user_seq - 1 AND (p.xid, p.seq) > (e.xid, e.seq)
+		)
+		SELECT COALESCE((SELECT xid FROM waits ORDER BY user_seq LIMIT 1), 0) AS xid,
+			COALESCE((SELECT seq FROM waits ORDER BY user_seq LIMIT 1), $1::bigint) AS seq`
+)
+
+type dbUserEvent struct {
+	Seq       int64     `db:"seq"`
+	EventType string    `db:"event_type"`
+	UserID    string    `db:"user_id"`
+	Payload   []byte    `db:"payload"`
+	CreatedAt time.Time `db:"created_at"`
+	UserSeq   int64     `db:"user_seq"`
+	// Before is set only for updates passing through the outbox
+	Before []byte `db:"before"`
+	// TxID, HeldBack and HoldsBack are set only for the event log
+	TxID      int64 `db:"xid"`
+	HeldBack  bool  `db:"held_back"`
+	HoldsBack bool  `db:"holds_back"`
+}
+
+type dbEventPosition struct {
+	TxID int64 `db:"xid"`
+	Seq  int64 `db:"seq"`
+}
+
+func (e dbUserEvent) entity() (entity.UserEvent, error) {
+	res := entity.UserEvent{
+		Seq:       e.Seq,
+		Type:      entity.EventType(e.EventType),
+		UserSeq:   e.UserSeq,
+		CreatedAt: e.CreatedAt,
+		TxID:      e.TxID,
+		HeldBack:  e.HeldBack,
+		HoldsBack: e.HoldsBack,
+	}
+	if err := json.Unmarshal(e.Payload, &res.User); err != nil {
+		return entity.UserEvent{}, fmt.Errorf("decode payload of event %d: %w", e.Seq, err)
+	}
+
+	if len(e.Before) > 0 {
+		var before entity.User
+		if err := json.Unmarshal(e.Before, &before); err != nil {
+			return entity.UserEvent{}, fmt.Errorf("decode previous state of event %d: %w", e.Seq, err)
+		}
+		res.Before = &before
+		res.Changed = entity.UserUpdate{Before: before, After: res.User}.Changed()
+	}
+
+	return res, nil
+}
+
+// recordEvent records the change of a user in the outbox and in the event log under the same seq in the same
+// transaction as the change itself. The change gets the next number in the sequence of the user, whose row
+// stays locked till the commit, so changes of the same user are numbered in the order of commits.
+// before is the user prior to an update, nil for the rest.
+func recordEvent(ctx context.Context, tx *sqlx.Tx, t entity.EventType, u entity.User, before *entity.User) error {
+	var userSeq int64
+	if err := tx.GetContext(ctx, &userSeq, qNextUserSeq, u.ID); err != nil {
+		return fmt.Errorf("next sequence of user %s: %w", u.ID, err)
+	}
+
+	seq, err := appendOutbox(ctx, tx, t, u, before, userSeq)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(u)
+	if err != nil {
+		return fmt.Errorf("encode event payload: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, qInsertUserEvent, seq, t, u.ID, payload, userSeq); err != nil {
+		return fmt.Errorf("append to event log: %w", err)
+	}
+
+	return nil
+}
+
+// LastUserEventPosition is where the final part of the log ends, zero when it's empty
+func (s *Storage) LastUserEventPosition(ctx context.Context) (entity.EventPosition, error) {
+	var pos dbEventPosition
+	if err := sqlx.GetContext(ctx, s.ext(ctx), &pos, qLastUserEventPosition); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entity.EventPosition{}, nil
+		}
+		return entity.EventPosition{}, err
+	}
+
+	return entity.EventPosition(pos), nil
+}
+
+// UserEventPosition is where reading the log goes on after the event with the seq. That's the event itself
+// unless it was held back behind an earlier one of its user, see UserEventsHeldBack. An unknown seq is taken
+// for one of the events recorded before the log kept transactions.
+func (s *Storage) UserEventPosition(ctx context.Context, seq int64) (entity.EventPosition, error) {
+	var pos dbEventPosition
+	if err := sqlx.GetContext(ctx, s.ext(ctx), &pos, qUserEventPosition, seq); err != nil {
+		return entity.EventPosition{}, err
+	}
+
+	return entity.EventPosition(pos), nil
+}
+
+// UserEventsAfter returns up to limit events following the position in the log. Only the final part
+// of the log is read: events of transactions still writing, and of any which start later, all come
+// after it, so reading on from the last returned event never misses one. The events come in the order
+// of the log, HeldBack and HoldsBack mark the ones which don't follow the order of their user.
+func (s *Storage) UserEventsAfter(ctx context.Context, after entity.EventPosition, limit int) ([]entity.UserEvent, error) {
+	var rows []dbUserEvent
+	if err := sqlx.SelectContext(ctx, s.ext(ctx), &rows, qUserEventsAfter, after.TxID, after.Seq, limit); err != nil {
+		return nil, err
+	}
+
+	return userEvents(rows)
+}
+
+// UserEventsHeldBack returns the events of the user held back behind the one with the seq in the order
+// of user_seq: an older transaction took their user_seq after the younger one which recorded the event had
+// committed, so they come earlier in the log but must go right after it.
+func (s *Storage) UserEventsHeldBack(ctx context.Context, seq int64) ([]entity.UserEvent, error) {
+	var rows []dbUserEvent
+	if err := sqlx.SelectContext(ctx, s.ext(ctx), &rows, qUserEventsHeldBack, seq); err != nil {
+		return nil, err
+	}
+
+	return userEvents(rows)
+}
+
+func userEvents(rows []dbUserEvent) ([]entity.UserEvent, error) {
+	res := make([]entity.UserEvent, 0, len(rows))
+	for _, r := range rows {
+		e, err := r.entity()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,132 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+// DriftPolicy decides what happens when an already applied migration file was edited afterwards
+type DriftPolicy string
+
+const (
+	DriftFail DriftPolicy = "fail"
+	DriftWarn DriftPolicy = "warn"
+)
+
+var ErrDrift = errors.New("applied migrations were edited")
+
+const (
+	qCreateChecksums = `CREATE TABLE IF NOT EXISTS schema_migration_checksums(
+		id VARCHAR(255) PRIMARY KEY,
+		checksum CHAR(64) NOT NULL,
+		recorded_at timestamp NOT NULL DEFAULT now()
+	)`
+	qGetChecksums   = "SELECT id, checksum FROM schema_migration_checksums"
+	qInsertChecksum = "INSERT INTO schema_migration_checksums(id, checksum) VALUES(?, ?)"
+	qDeleteChecksum = "DELETE FROM schema_migration_checksums WHERE id=?"
+)
+
+func recordedChecksums(db *sqlx.DB) (map[string]string, error) {
+	if _, err := db.Exec(qCreateChecksums); err != nil {
+		return nil, fmt.Errorf("create checksums table: %w", err)
+	}
+
+	var rows []struct {
+		ID       string `db:"id"`
+		Checksum string `db:"checksum"`
+	}
+	if err := db.Select(&rows, qGetChecksums); err != nil {
+		return nil, fmt.Errorf("get checksums: %w", err)
+	}
+
+	res := make(map[string]string, len(rows))
+	for _, r := range rows {
+		res[r.ID] = r.Checksum
+	}
+
+	return res, nil
+}
+
+// drifted returns ids of applied migrations whose files differ from what was applied
+func drifted(db *sqlx.DB, driver string) ([]string, error) {
+	recorded, err := recordedChecksums(db)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := migrate.GetMigrationRecords(db.DB, driver)
+	if err != nil {
+		return nil, fmt.Errorf("get applied migrations: %w", err)
+	}
+
+	var ids []string
+	for _, r := range records {
+		sum, ok := recorded[r.Id]
+		if ok && sum != checksums[r.Id] {
+			ids = append(ids, r.Id)
+		}
+	}
+
+	return ids, nil
+}
+
+func checkDrift(db *sqlx.DB, driver string, policy DriftPolicy) error {
+	ids, err := drifted(db, driver)
+	if err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if policy == DriftWarn {
+		slog.Warn("applied migrations were edited", "ids", ids)
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrDrift, strings.Join(ids, ", "))
+}
+
+// syncChecksums records checksums of applied migrations and forgets rolled back ones.
+// Migrations applied before checksums were introduced get their current checksum as a baseline.
+func syncChecksums(db *sqlx.DB, driver string) error {
+	recorded, err := recordedChecksums(db)
+	if err != nil {
+		return err
+	}
+
+	records, err := migrate.GetMigrationRecords(db.DB, driver)
+	if err != nil {
+		return fmt.Errorf("get applied migrations: %w", err)
+	}
+
+	applied := make(map[string]struct{}, len(records))
+	for _, r := range records {
+		applied[r.Id] = struct{}{}
+		if _, ok := recorded[r.Id]; ok {
+			continue
+		}
+
+		if _, err := db.Exec(db.Rebind(qInsertChecksum), r.Id, checksums[r.Id]); err != nil {
+			return fmt.Errorf("record checksum of %s: %w", r.Id, err)
+		}
+	}
+
+	for id := range recorded {
+		if _, ok := applied[id]; ok {
+			continue
+		}
+
+		if _, err := db.Exec(db.Rebind(qDeleteChecksum), id); err != nil {
+			return fmt.Errorf("forget checksum of %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+// lockID is an arbitrary key of the advisory lock every instance takes before migrating
+const lockID int64 = 0x7573657273
+
+const lockPollInterval = 500 * time.Millisecond
+
+var ErrLockTimeout = errors.New("timed out waiting for the migration lock")
+
+// withLock runs fn holding a session-level advisory lock, so only one instance migrates at a time.
+// The lock lives on a dedicated connection while the migrations themselves use the pool.
+func withLock(db *sqlx.DB, driver string, wait time.Duration, fn func() error) error {
+	if driver != "postgres" {
+		return fn()
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(wait)
+	for waited := false; ; waited = true {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", lockID).Scan(&acquired); err != nil {
+			return fmt.Errorf("try migration lock: %w", err)
+		}
+
+		if acquired {
+			if waited {
+				slog.Info("got migration lock after another instance released it")
+			}
+			break
+		}
+
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+
+		if !waited {
+			slog.Info("another instance is migrating, waiting for it", "wait", wait)
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockID); err != nil {
+			slog.Error("release migration lock", "err", err)
+		}
+	}()
+
+	return fn()
+}
+
+// source returns the migrations with lock_timeout and statement_timeout set for each of them.
+// SET LOCAL only lasts until the migration's transaction ends, so pooled connections aren't affected.
+func source(driver string, o options) migrate.MigrationSource {
+	if driver != "postgres" || (o.lockTimeout == 0 && o.statementTimeout == 0) {
+		return migrations
+	}
+
+	var settings []string
+	if o.lockTimeout > 0 {
+		settings = append(settings, fmt.Sprintf("SET LOCAL lock_timeout = %d", o.lockTimeout.Milliseconds()))
+	}
+	if o.statementTimeout > 0 {
+		settings = append(settings, fmt.Sprintf("SET LOCAL statement_timeout = %d", o.statementTimeout.Milliseconds()))
+	}
+
+	res := &migrate.MemoryMigrationSource{}
+	for _, m := range migrations.Migrations {
+		cp := *m
+		if !cp.DisableTransactionUp {
+			cp.Up = append(append([]string{}, settings...), m.Up...)
+		}
+		if !cp.DisableTransactionDown {
+			cp.Down = append(append([]string{}, settings...), m.Down...)
+		}
+		res.Migrations = append(res.Migrations, &cp)
+	}
+
+	return res
+}
@@ -1,26 +1,59 @@
 package migrations
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
 	migrate "github.com/rubenv/sql-migrate"
 )
 
-var migrations = &migrate.MemoryMigrationSource{
-	Migrations: []*migrate.Migration{
-		{
-			Id: "01-initial",
-			Up: []string{
-				`CREATE EXTENSION "uuid-ossp";
-				CREATE TABLE users(
-					id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
-					first_name VARCHAR(128) NOT NULL,
-					last_name VARCHAR(128) NOT NULL,
-					created_at timestamp NOT NULL DEFAULT now()
-				);`,
-			},
-			Down: []string{
-				`DELETE TABLE users;
-				DROP EXTENSION "uuid-ossp";`,
-			},
-		},
-	},
+// every file holds both directions separated by "-- +migrate Up" and "-- +migrate Down" comments,
+// the file name without the extension is the migration id
+//
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+var migrations, checksums = mustLoad(sqlFiles)
+
+func mustLoad(fsys fs.FS) (*migrate.MemoryMigrationSource, map[string]string) {
+	src, sums, err := load(fsys)
+	if err != nil {
+		panic(fmt.Errorf("load embedded migrations: %w", err))
+	}
+
+	return src, sums
+}
+
+func load(fsys fs.FS) (*migrate.MemoryMigrationSource, map[string]string, error) {
+	files, err := fs.Glob(fsys, "sql/*.sql")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	src := &migrate.MemoryMigrationSource{}
+	sums := make(map[string]string, len(files))
+	for _, name := range files {
+		raw, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		id := strings.TrimSuffix(path.Base(name), ".sql")
+		m, err := migrate.ParseMigration(id, bytes.NewReader(raw))
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse %s: %w", name, err)
+		}
+
+		sum := sha256.Sum256(raw)
+		sums[id] = hex.EncodeToString(sum[:])
+		src.Migrations = append(src.Migrations, m)
+	}
+
+	return src, sums, nil
 }
@@ -0,0 +1,55 @@
+package migrations
+
+import "time"
+
+const (
+	defaultLockWait         = time.Minute
+	defaultLockTimeout      = 5 * time.Second
+	defaultStatementTimeout = time.Minute
+)
+
+type Option func(o *options)
+
+type options struct {
+	drift            DriftPolicy
+	lockWait         time.Duration
+	lockTimeout      time.Duration
+	statementTimeout time.Duration
+}
+
+// WithDriftPolicy sets what to do about edited migrations, DriftFail is the default
+func WithDriftPolicy(p DriftPolicy) Option {
+	return func(o *options) {
+		o.drift = p
+	}
+}
+
+// WithLockWait sets how long to wait for another instance to finish migrating, a minute by default
+func WithLockWait(d time.Duration) Option {
+	return func(o *options) {
+		o.lockWait = d
+	}
+}
+
+// WithTimeouts sets lock_timeout and statement_timeout of every migration, zero leaves the server default.
+// They're 5 seconds and a minute by default so a migration can't block the traffic for long.
+func WithTimeouts(lock, statement time.Duration) Option {
+	return func(o *options) {
+		o.lockTimeout = lock
+		o.statementTimeout = statement
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		drift:            DriftFail,
+		lockWait:         defaultLockWait,
+		lockTimeout:      defaultLockTimeout,
+		statementTimeout: defaultStatementTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
@@ -1,11 +1,148 @@
 package migrations
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
 	"github.com/jmoiron/sqlx"
 	migrate "github.com/rubenv/sql-migrate"
 )
 
-func Up(db *sqlx.DB, driver string) error {
-	_, err := migrate.Exec(db.DB, driver, migrations, migrate.Up)
-	return err
+// MigrationStatus of a single migration, AppliedAt is nil for pending ones.
+// Drifted tells the migration file was edited after it had been applied.
+type MigrationStatus struct {
+	ID        string
+	AppliedAt *time.Time
+	Drifted   bool
+}
+
+var ErrSchemaBehind = errors.New("schema has pending migrations")
+
+// Up applies all pending migrations and makes sure none is left, e.g. because
+// another instance holding the lock applied an older set of them
+func Up(db *sqlx.DB, driver string, opts ...Option) error {
+	o := newOptions(opts)
+	return withLock(db, driver, o.lockWait, func() error {
+		if _, err := upSteps(db, driver, 0, o); err != nil {
+			return err
+		}
+
+		pending, _, err := migrate.PlanMigration(db.DB, driver, migrations, migrate.Up, 0)
+		if err != nil {
+			return fmt.Errorf("plan migrations: %w", err)
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%w: %d left", ErrSchemaBehind, len(pending))
+		}
+
+		return nil
+	})
+}
+
+// UpSteps applies the given number of pending migrations, 0 means all of them.
+// It returns the number of migrations applied.
+func UpSteps(db *sqlx.DB, driver string, steps int, opts ...Option) (int, error) {
+	o := newOptions(opts)
+
+	var n int
+	err := withLock(db, driver, o.lockWait, func() error {
+		var err error
+		n, err = upSteps(db, driver, steps, o)
+		return err
+	})
+
+	return n, err
+}
+
+// Down rolls back the given number of the most recent migrations, 0 means all of them.
+// It returns the number of migrations rolled back.
+func Down(db *sqlx.DB, driver string, steps int, opts ...Option) (int, error) {
+	o := newOptions(opts)
+
+	var n int
+	err := withLock(db, driver, o.lockWait, func() error {
+		var err error
+		n, err = down(db, driver, steps, o)
+		return err
+	})
+
+	return n, err
+}
+
+// Redo rolls back the most recent migration and applies it again
+func Redo(db *sqlx.DB, driver string, opts ...Option) error {
+	o := newOptions(opts)
+	return withLock(db, driver, o.lockWait, func() error {
+		n, err := down(db, driver, 1, o)
+		if err != nil {
+			return fmt.Errorf("roll back: %w", err)
+		}
+		if n == 0 {
+			return nil
+		}
+
+		if _, err := upSteps(db, driver, 1, o); err != nil {
+			return fmt.Errorf("apply again: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func upSteps(db *sqlx.DB, driver string, steps int, o options) (int, error) {
+	if err := checkDrift(db, driver, o.drift); err != nil {
+		return 0, err
+	}
+
+	n, err := migrate.ExecMax(db.DB, driver, source(driver, o), migrate.Up, steps)
+	if syncErr := syncChecksums(db, driver); syncErr != nil && err == nil {
+		err = syncErr
+	}
+
+	return n, err
+}
+
+func down(db *sqlx.DB, driver string, steps int, o options) (int, error) {
+	n, err := migrate.ExecMax(db.DB, driver, source(driver, o), migrate.Down, steps)
+	if syncErr := syncChecksums(db, driver); syncErr != nil && err == nil {
+		err = syncErr
+	}
+
+	return n, err
+}
+
+// Status lists all known migrations in order along with when they were applied
+func Status(db *sqlx.DB, driver string) ([]MigrationStatus, error) {
+	known, err := migrations.FindMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("find migrations: %w", err)
+	}
+
+	records, err := migrate.GetMigrationRecords(db.DB, driver)
+	if err != nil {
+		return nil, fmt.Errorf("get applied migrations: %w", err)
+	}
+
+	driftedIDs, err := drifted(db, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[string]time.Time, len(records))
+	for _, r := range records {
+		applied[r.Id] = r.AppliedAt
+	}
+
+	res := make([]MigrationStatus, 0, len(known))
+	for _, m := range known {
+		st := MigrationStatus{ID: m.Id, Drifted: slices.Contains(driftedIDs, m.Id)}
+		if at, ok := applied[m.Id]; ok {
+			st.AppliedAt = &at
+		}
+		res = append(res, st)
+	}
+
+	return res, nil
 }
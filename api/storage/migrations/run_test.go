@@ -0,0 +1,145 @@
+package migrations_test
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/andyklimenko/testify-usage-example/api/storage/database"
+	"github.com/andyklimenko/testify-usage-example/api/storage/migrations"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const driver = "postgres"
+
+// schemaSnapshot lists everything a migration may create, the bookkeeping tables are left out
+func schemaSnapshot(t *testing.T, db *sqlx.DB) []string {
+	t.Helper()
+
+	var objects []string
+	require.NoError(t, db.Select(&objects, `
+		SELECT 'table ' || table_name || '.' || column_name || ' ' || data_type
+			FROM information_schema.columns
+			WHERE table_schema = 'public' AND table_name NOT IN ('gorp_migrations', 'schema_migration_checksums')
+		UNION ALL
+		SELECT 'index ' || indexname || ' ' || indexdef
+			FROM pg_indexes
+			WHERE schemaname = 'public' AND tablename NOT IN ('gorp_migrations', 'schema_migration_checksums')
+		UNION ALL
+		SELECT 'extension ' || extname FROM pg_extension WHERE extname <> 'plpgsql'
+	`))
+	sort.Strings(objects)
+
+	return objects
+}
+
+// TestRoundTrip applies every migration, rolls it back and applies it again,
+// the rollback has to bring the schema back to exactly what it was before
+func TestRoundTrip(t *testing.T) {
+	db := database.DB()
+	require.NotNil(t, db)
+
+	_, err := migrations.Down(db, driver, 0)
+	require.NoError(t, err)
+
+	statuses, err := migrations.Status(db, driver)
+	require.NoError(t, err)
+	require.NotEmpty(t, statuses)
+
+	for _, st := range statuses {
+		require.Nil(t, st.AppliedAt, "%s wasn't rolled back", st.ID)
+	}
+
+	for _, st := range statuses {
+		before := schemaSnapshot(t, db)
+
+		n, err := migrations.UpSteps(db, driver, 1)
+		require.NoError(t, err, "applying %s", st.ID)
+		require.Equal(t, 1, n)
+		applied := schemaSnapshot(t, db)
+
+		n, err = migrations.Down(db, driver, 1)
+		require.NoError(t, err, "rolling back %s", st.ID)
+		require.Equal(t, 1, n)
+		assert.Equal(t, before, schemaSnapshot(t, db), "%s isn't rolled back cleanly", st.ID)
+
+		n, err = migrations.UpSteps(db, driver, 1)
+		require.NoError(t, err, "applying %s once again", st.ID)
+		require.Equal(t, 1, n)
+		assert.Equal(t, applied, schemaSnapshot(t, db), "%s isn't applied the same way twice", st.ID)
+	}
+
+	require.NoError(t, migrations.Redo(db, driver))
+
+	statuses, err = migrations.Status(db, driver)
+	require.NoError(t, err)
+	for _, st := range statuses {
+		assert.NotNil(t, st.AppliedAt, "%s is pending", st.ID)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	db := database.DB()
+	require.NotNil(t, db)
+	require.NoError(t, migrations.Up(db, driver))
+
+	var id, sum string
+	require.NoError(t, db.QueryRow("SELECT id, checksum FROM schema_migration_checksums ORDER BY id LIMIT 1").Scan(&id, &sum))
+
+	// pretend the file was edited after it had been applied
+	_, err := db.Exec("UPDATE schema_migration_checksums SET checksum=$1 WHERE id=$2", strings.Repeat("0", 64), id)
+	require.NoError(t, err)
+	defer func() {
+		_, err := db.Exec("UPDATE schema_migration_checksums SET checksum=$1 WHERE id=$2", sum, id)
+		require.NoError(t, err)
+	}()
+
+	assert.ErrorIs(t, migrations.Up(db, driver), migrations.ErrDrift)
+	assert.NoError(t, migrations.Up(db, driver, migrations.WithDriftPolicy(migrations.DriftWarn)))
+
+	statuses, err := migrations.Status(db, driver)
+	require.NoError(t, err)
+	for _, st := range statuses {
+		assert.Equal(t, st.ID == id, st.Drifted, st.ID)
+	}
+}
+
+// TestConcurrentUp runs Up from several instances at once, only one of them should migrate
+func TestConcurrentUp(t *testing.T) {
+	db := database.DB()
+	require.NotNil(t, db)
+
+	_, err := migrations.Down(db, driver, 0)
+	require.NoError(t, err)
+
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- migrations.Up(db, driver)
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	statuses, err := migrations.Status(db, driver)
+	require.NoError(t, err)
+	for _, st := range statuses {
+		assert.NotNil(t, st.AppliedAt, "%s is pending", st.ID)
+	}
+}
+
+func TestMain(m *testing.M) {
+	closer, repoErr := database.InitDockerDB()
+	if repoErr != nil {
+		os.Exit(-1)
+	}
+	status := m.Run()
+	if closer != nil {
+		closer()
+	}
+	os.Exit(status)
+}
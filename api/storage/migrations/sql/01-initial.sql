@@ -0,0 +1,12 @@
+-- +migrate Up
+CREATE EXTENSION "uuid-ossp";
+CREATE TABLE users(
+	id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+	first_name VARCHAR(128) NOT NULL,
+	last_name VARCHAR(128) NOT NULL,
+	created_at timestamp NOT NULL DEFAULT now()
+);
+
+-- +migrate Down
+DROP TABLE users;
+DROP EXTENSION "uuid-ossp";
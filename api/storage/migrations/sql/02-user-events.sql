@@ -0,0 +1,12 @@
+-- +migrate Up
+CREATE TABLE user_events(
+	seq BIGSERIAL PRIMARY KEY,
+	event_type VARCHAR(16) NOT NULL,
+	user_id uuid NOT NULL,
+	payload jsonb NOT NULL,
+	created_at timestamp NOT NULL DEFAULT now()
+);
+CREATE INDEX user_events_user_id_idx ON user_events(user_id, seq);
+
+-- +migrate Down
+DROP TABLE user_events;
@@ -0,0 +1,9 @@
+-- +migrate Up
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX users_full_name_trgm_idx ON users USING GIN ((first_name || ' ' || last_name) gin_trgm_ops);
+CREATE INDEX users_full_name_tsv_idx ON users USING GIN (to_tsvector('simple', first_name || ' ' || last_name));
+
+-- +migrate Down
+DROP INDEX users_full_name_tsv_idx;
+DROP INDEX users_full_name_trgm_idx;
+DROP EXTENSION pg_trgm;
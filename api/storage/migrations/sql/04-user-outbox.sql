@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE user_outbox(
+	seq BIGSERIAL PRIMARY KEY,
+	event_type VARCHAR(16) NOT NULL,
+	user_id uuid NOT NULL,
+	payload jsonb NOT NULL,
+	created_at timestamp NOT NULL DEFAULT now(),
+	sent_at timestamp
+);
+CREATE INDEX user_outbox_pending_idx ON user_outbox(seq) WHERE sent_at IS NULL;
+
+-- +migrate Down
+DROP TABLE user_outbox;
@@ -0,0 +1,15 @@
+-- +migrate Up
+CREATE TABLE user_dead_letters(
+	id BIGSERIAL PRIMARY KEY,
+	outbox_seq BIGINT NOT NULL,
+	event_type VARCHAR(16) NOT NULL,
+	user_id uuid NOT NULL,
+	payload jsonb NOT NULL,
+	last_error TEXT NOT NULL,
+	attempts INT NOT NULL,
+	created_at timestamp NOT NULL,
+	failed_at timestamp NOT NULL DEFAULT now()
+);
+
+-- +migrate Down
+DROP TABLE user_dead_letters;
@@ -0,0 +1,26 @@
+-- +migrate Up
+CREATE TABLE webhooks(
+	id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+	url TEXT NOT NULL,
+	event_types VARCHAR(16)[] NOT NULL,
+	secret TEXT NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT true,
+	created_at timestamp NOT NULL DEFAULT now()
+);
+CREATE TABLE webhook_deliveries(
+	seq BIGSERIAL PRIMARY KEY,
+	webhook_id uuid NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	event_type VARCHAR(16) NOT NULL,
+	user_id uuid NOT NULL,
+	payload jsonb NOT NULL,
+	created_at timestamp NOT NULL DEFAULT now(),
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	next_attempt_at timestamp NOT NULL DEFAULT now(),
+	sent_at timestamp
+);
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries(webhook_id, seq) WHERE sent_at IS NULL;
+
+-- +migrate Down
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
@@ -0,0 +1,5 @@
+-- +migrate Up
+ALTER TABLE webhooks ADD COLUMN previous_secret TEXT, ADD COLUMN previous_secret_expires_at timestamp;
+
+-- +migrate Down
+ALTER TABLE webhooks DROP COLUMN previous_secret, DROP COLUMN previous_secret_expires_at;
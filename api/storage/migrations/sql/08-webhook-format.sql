@@ -0,0 +1,8 @@
+-- +migrate Up
+ALTER TABLE webhooks ADD COLUMN format VARCHAR(32) NOT NULL DEFAULT 'legacy';
+-- deliveries of the same event share the seq of its outbox entry
+ALTER TABLE webhook_deliveries ADD COLUMN event_seq BIGINT;
+
+-- +migrate Down
+ALTER TABLE webhook_deliveries DROP COLUMN event_seq;
+ALTER TABLE webhooks DROP COLUMN format;
@@ -0,0 +1,9 @@
+-- +migrate Up
+ALTER TABLE user_outbox ADD COLUMN before jsonb;
+ALTER TABLE webhook_deliveries ADD COLUMN before jsonb;
+ALTER TABLE user_dead_letters ADD COLUMN before jsonb;
+
+-- +migrate Down
+ALTER TABLE user_dead_letters DROP COLUMN before;
+ALTER TABLE webhook_deliveries DROP COLUMN before;
+ALTER TABLE user_outbox DROP COLUMN before;
@@ -0,0 +1,33 @@
+-- +migrate Up
+CREATE TABLE user_sequences(
+	user_id uuid PRIMARY KEY,
+	seq bigint NOT NULL
+);
+
+ALTER TABLE user_outbox ADD COLUMN user_seq bigint NOT NULL DEFAULT 0;
+ALTER TABLE user_events ADD COLUMN user_seq bigint NOT NULL DEFAULT 0;
+ALTER TABLE webhook_deliveries ADD COLUMN user_seq bigint NOT NULL DEFAULT 0;
+ALTER TABLE user_dead_letters ADD COLUMN user_seq bigint NOT NULL DEFAULT 0;
+
+-- number what's already there in the order it happened
+UPDATE user_outbox o SET user_seq = n.user_seq
+FROM (SELECT seq, row_number() OVER (PARTITION BY user_id ORDER BY seq) AS user_seq FROM user_outbox) n
+WHERE o.seq = n.seq;
+UPDATE user_events e SET user_seq = n.user_seq
+FROM (SELECT seq, row_number() OVER (PARTITION BY user_id ORDER BY seq) AS user_seq FROM user_events) n
+WHERE e.seq = n.seq;
+UPDATE webhook_deliveries d SET user_seq = o.user_seq FROM user_outbox o WHERE o.seq = d.event_seq;
+UPDATE user_dead_letters d SET user_seq = o.user_seq FROM user_outbox o WHERE o.seq = d.outbox_seq;
+INSERT INTO user_sequences(user_id, seq) SELECT user_id, max(user_seq) FROM user_outbox GROUP BY user_id;
+
+ALTER TABLE user_outbox ALTER COLUMN user_seq DROP DEFAULT;
+ALTER TABLE user_events ALTER COLUMN user_seq DROP DEFAULT;
+ALTER TABLE webhook_deliveries ALTER COLUMN user_seq DROP DEFAULT;
+ALTER TABLE user_dead_letters ALTER COLUMN user_seq DROP DEFAULT;
+
+-- +migrate Down
+ALTER TABLE user_dead_letters DROP COLUMN user_seq;
+ALTER TABLE webhook_deliveries DROP COLUMN user_seq;
+ALTER TABLE user_events DROP COLUMN user_seq;
+ALTER TABLE user_outbox DROP COLUMN user_seq;
+DROP TABLE user_sequences;
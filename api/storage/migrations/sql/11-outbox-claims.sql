@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE user_outbox ADD COLUMN claimed_by uuid;
+ALTER TABLE user_outbox ADD COLUMN claimed_until timestamp;
+
+-- +migrate Down
+ALTER TABLE user_outbox DROP COLUMN claimed_until;
+ALTER TABLE user_outbox DROP COLUMN claimed_by;
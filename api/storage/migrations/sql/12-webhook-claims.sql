@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE webhook_deliveries ADD COLUMN claimed_by uuid;
+ALTER TABLE webhook_deliveries ADD COLUMN claimed_until timestamp;
+
+-- +migrate Down
+ALTER TABLE webhook_deliveries DROP COLUMN claimed_until;
+ALTER TABLE webhook_deliveries DROP COLUMN claimed_by;
@@ -0,0 +1,20 @@
+-- +migrate Up
+CREATE TABLE webhook_dead_letters(
+	id BIGSERIAL PRIMARY KEY,
+	webhook_id uuid NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	delivery_seq BIGINT NOT NULL,
+	event_seq BIGINT NOT NULL,
+	event_type VARCHAR(16) NOT NULL,
+	user_id uuid NOT NULL,
+	payload jsonb NOT NULL,
+	before jsonb,
+	user_seq BIGINT NOT NULL,
+	last_error TEXT NOT NULL,
+	attempts INT NOT NULL,
+	created_at timestamp NOT NULL,
+	failed_at timestamp NOT NULL DEFAULT now()
+);
+CREATE INDEX webhook_dead_letters_webhook_idx ON webhook_dead_letters(webhook_id, id);
+
+-- +migrate Down
+DROP TABLE webhook_dead_letters;
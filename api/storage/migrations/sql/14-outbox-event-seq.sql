@@ -0,0 +1,8 @@
+-- +migrate Up
+-- a replayed notification keeps the seq it got first, NULL stands for its own seq
+ALTER TABLE user_outbox ADD COLUMN event_seq BIGINT;
+ALTER TABLE user_dead_letters RENAME COLUMN outbox_seq TO event_seq;
+
+-- +migrate Down
+ALTER TABLE user_dead_letters RENAME COLUMN event_seq TO outbox_seq;
+ALTER TABLE user_outbox DROP COLUMN event_seq;
@@ -0,0 +1,28 @@
+-- +migrate Up
+-- the event log and the outbox are numbered from one sequence, so an event has the same seq in both
+CREATE SEQUENCE user_event_seq;
+SELECT setval('user_event_seq', GREATEST((SELECT max(seq) FROM user_events), (SELECT max(seq) FROM user_outbox), 0) + 1, false);
+ALTER TABLE user_events ALTER COLUMN seq SET DEFAULT nextval('user_event_seq');
+ALTER TABLE user_outbox ALTER COLUMN seq SET DEFAULT nextval('user_event_seq');
+
+-- the log is read in the order of the transactions which recorded the events, the ones already there come first
+ALTER TABLE user_events ADD COLUMN xid BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE user_events ALTER COLUMN xid SET DEFAULT txid_current();
+CREATE INDEX user_events_xid_idx ON user_events(xid, seq);
+
+CREATE INDEX user_outbox_pending_user_idx ON user_outbox(user_id, user_seq) WHERE sent_at IS NULL;
+
+-- sequences seeded from the outbox alone may lag behind the log
+INSERT INTO user_sequences(user_id, seq)
+SELECT user_id, max(user_seq) FROM user_events GROUP BY user_id
+ON CONFLICT (user_id) DO UPDATE SET seq = GREATEST(user_sequences.seq, EXCLUDED.seq);
+
+-- +migrate Down
+DROP INDEX user_outbox_pending_user_idx;
+DROP INDEX user_events_xid_idx;
+ALTER TABLE user_events DROP COLUMN xid;
+SELECT setval('user_outbox_seq_seq', GREATEST((SELECT max(seq) FROM user_outbox), 0) + 1, false);
+SELECT setval('user_events_seq_seq', GREATEST((SELECT max(seq) FROM user_events), 0) + 1, false);
+ALTER TABLE user_outbox ALTER COLUMN seq SET DEFAULT nextval('user_outbox_seq_seq');
+ALTER TABLE user_events ALTER COLUMN seq SET DEFAULT nextval('user_events_seq_seq');
+DROP SEQUENCE user_event_seq;
@@ -0,0 +1,21 @@
+-- +migrate Up
+-- migration 10 numbered the outbox apart from the log, which goes further back. Every outbox entry was written
+-- right after its log event in the same transaction, so the n-th entry of a user in a transaction
+-- is the n-th event of the user in it. Replayed entries take the number of the one they came from.
+CREATE TEMPORARY TABLE outbox_user_seq AS
+SELECT o.seq, e.user_seq FROM (
+	SELECT seq, user_id, created_at, row_number() OVER (PARTITION BY user_id, created_at ORDER BY seq) AS n
+	FROM user_outbox WHERE event_seq IS NULL
+) o JOIN (
+	SELECT user_id, created_at, user_seq, row_number() OVER (PARTITION BY user_id, created_at ORDER BY seq) AS n
+	FROM user_events
+) e ON e.user_id = o.user_id AND e.created_at = o.created_at AND e.n = o.n;
+
+UPDATE user_outbox o SET user_seq = n.user_seq FROM outbox_user_seq n WHERE n.seq = COALESCE(o.event_seq, o.seq);
+UPDATE webhook_deliveries d SET user_seq = n.user_seq FROM outbox_user_seq n WHERE n.seq = d.event_seq;
+UPDATE webhook_dead_letters d SET user_seq = n.user_seq FROM outbox_user_seq n WHERE n.seq = d.event_seq;
+UPDATE user_dead_letters d SET user_seq = n.user_seq FROM outbox_user_seq n WHERE n.seq = d.event_seq;
+DROP TABLE outbox_user_seq;
+
+-- +migrate Down
+-- the numbers given by migration 10 aren't kept, the ones taken from the log stay
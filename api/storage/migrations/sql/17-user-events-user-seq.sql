@@ -0,0 +1,6 @@
+-- +migrate Up
+-- an event whose previous one of the user comes later in the log is held back behind it
+CREATE INDEX user_events_user_seq_idx ON user_events(user_id, user_seq);
+
+-- +migrate Down
+DROP INDEX user_events_user_seq_idx;
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// outboxLockID is an arbitrary key of the lock only one relay at a time holds while claiming notifications
+const outboxLockID int64 = 0x6f7574626f78
+
+// outboxClaimFor is how long claimed notifications stay with their relay, it outlasts the retries of a batch
+// until the breaker opens. A relay which is gone by then has its notifications sent again by another one.
+const outboxClaimFor = 5 * time.Minute
+
+const (
+	qInsertOutbox = "INSERT INTO user_outbox(event_type, user_id, payload, before, user_seq) VALUES($1, $2, $3, $4, $5) RETURNING seq"
+	qLockOutbox   = "SELECT pg_try_advisory_xact_lock($1)"
+	// nothing is claimed while another relay holds a claim, so notifications go out in order.
+	// A notification waits for the pending ones of the same user with a lower user_seq, even if they
+	// came to the outbox later, the way replayed dead letters do.
+	qClaimOutbox = `UPDATE user_outbox SET claimed_by = $1, claimed_until = now() + $2 * interval '1 second'
+		WHERE seq IN (
+				SELECT seq FROM user_outbox o WHERE sent_at IS NULL AND NOT EXISTS (
+					SELECT 1 FROM user_outbox p WHERE p.sent_at IS NULL AND p.user_id = o.user_id AND p.user_seq < o.user_seq AND p.seq > o.seq
+				) ORDER BY seq LIMIT $3
+			)
+			AND NOT EXISTS (SELECT 1 FROM user_outbox WHERE sent_at IS NULL AND claimed_until > now())
+		RETURNING seq, COALESCE(event_seq, seq) AS event_seq, event_type, user_id, payload, before, user_seq, created_at`
+	qMarkOutboxSent = "UPDATE user_outbox SET sent_at = now(), claimed_by = NULL, claimed_until = NULL WHERE seq = ANY($1)"
+	qReleaseOutbox  = "UPDATE user_outbox SET claimed_by = NULL, claimed_until = NULL WHERE seq = ANY($1) AND claimed_by = $2"
+)
+
+type dbOutboxEntry struct {
+	dbUserEvent
+	// EventSeq is the seq the notification got first, it stays the same when the notification is replayed
+	EventSeq int64 `db:"event_seq"`
+}
+
+// appendOutbox records a notification about the change for the changelog and every subscribed webhook
+// in the same transaction as the change itself and returns the seq of the event
+func appendOutbox(ctx context.Context, tx *sqlx.Tx, t entity.EventType, u entity.User, before *entity.User, userSeq int64) (int64, error) {
+	payload, err := json.Marshal(u)
+	if err != nil {
+		return 0, fmt.Errorf("encode outbox payload: %w", err)
+	}
+
+	var beforePayload []byte
+	if before != nil {
+		if beforePayload, err = json.Marshal(before); err != nil {
+			return 0, fmt.Errorf("encode outbox payload: %w", err)
+		}
+	}
+
+	var seq int64
+	if err := tx.GetContext(ctx, &seq, qInsertOutbox, t, u.ID, payload, beforePayload, userSeq); err != nil {
+		return 0, fmt.Errorf("append to outbox: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, qFanOutWebhooks, t, u.ID, payload, seq, beforePayload, userSeq); err != nil {
+		return 0, fmt.Errorf("fan out to webhooks: %w", err)
+	}
+
+	return seq, nil
+}
+
+// RelayOutbox hands up to limit pending notifications to deliver in order and marks delivered ones as sent.
+// deliver tells the outcome of every notification, nil for a delivered one. A notification that failed with
+// DeadLetter is moved to the dead letters, any other failure stops the relay so the order is kept.
+// The later notifications of a user with a dead letter go to the dead letters as well without being handed
+// to deliver, so the user's notifications never overtake each other. Replaying the dead letters lets them go.
+// It returns how many were sent or dead-lettered along with the failure.
+// The notifications are claimed in one short transaction, delivered outside of any and marked in another one,
+// so a slow receiver holds no locks. Only one relay at a time holds a claim, the others get nothing,
+// so several replicas may run a relay safely. A notification is sent again if marking it fails.
+func (s *Storage) RelayOutbox(ctx context.Context, limit int, deliver func(events []entity.UserEvent) []error) (int, error) {
+	claim := uuid.NewString()
+	rows, events, err := s.claimOutbox(ctx, claim, limit)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	var sent, unsent []int64
+	deadLetters := make(map[int]*deadLetterError)
+	var deliverErr error
+	for i, err := range deliver(events) {
+		if deliverErr != nil {
+			unsent = append(unsent, rows[i].Seq)
+			continue
+		}
+
+		if err != nil {
+			var dl *deadLetterError
+			if !errors.As(err, &dl) {
+				deliverErr = fmt.Errorf("deliver notification %d: %w", events[i].Seq, err)
+				unsent = append(unsent, rows[i].Seq)
+				continue
+			}
+			deadLetters[i] = dl
+		}
+		sent = append(sent, rows[i].Seq)
+	}
+
+	err = s.recordRelayed(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		for i, dl := range deadLetters {
+			if err := insertDeadLetter(ctx, tx, rows[i], dl); err != nil {
+				return err
+			}
+		}
+
+		if len(sent) > 0 {
+			if _, err := tx.ExecContext(ctx, qMarkOutboxSent, pq.Array(sent)); err != nil {
+				return fmt.Errorf("mark notifications sent: %w", err)
+			}
+		}
+
+		if len(unsent) > 0 {
+			if _, err := tx.ExecContext(ctx, qReleaseOutbox, pq.Array(unsent), claim); err != nil {
+				return fmt.Errorf("release notifications: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(sent), deliverErr
+}
+
+// claimOutbox claims up to limit pending notifications unless another relay holds a claim
+func (s *Storage) claimOutbox(ctx context.Context, claim string, limit int) ([]dbOutboxEntry, []entity.UserEvent, error) {
+	var rows []dbOutboxEntry
+	var events []entity.UserEvent
+	err := s.inTx(ctx, func(tx *sqlx.Tx) error {
+		rows, events = nil, nil
+
+		var locked bool
+		if err := tx.GetContext(ctx, &locked, qLockOutbox, outboxLockID); err != nil {
+			return fmt.Errorf("lock outbox: %w", err)
+		}
+		if !locked {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, qHoldBackOutbox, ErrHeldBack.Error()); err != nil {
+			return fmt.Errorf("hold back notifications: %w", err)
+		}
+
+		if err := tx.SelectContext(ctx, &rows, qClaimOutbox, claim, outboxClaimFor.Seconds(), limit); err != nil {
+			return fmt.Errorf("claim pending notifications: %w", err)
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Seq < rows[j].Seq })
+
+		for _, r := range rows {
+			e, err := r.entity()
+			if err != nil {
+				return err
+			}
+			e.Seq = r.EventSeq
+			events = append(events, e)
+		}
+
+		return nil
+	})
+
+	return rows, events, err
+}
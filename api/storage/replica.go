@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// replicaCooldown is how long a failed replica is left alone before it's tried again
+const replicaCooldown = 10 * time.Second
+
+type Option func(s *Storage)
+
+// WithReplicas sends plain reads to the given read-only replicas in turn
+func WithReplicas(dbs ...*sqlx.DB) Option {
+	return func(s *Storage) {
+		for _, db := range dbs {
+			s.replicas = append(s.replicas, &replica{db: db})
+		}
+	}
+}
+
+type replica struct {
+	db *sqlx.DB
+	// downUntil is unix nanoseconds
+	downUntil atomic.Int64
+}
+
+type primaryCtxKey struct{}
+
+// WithPrimary makes reads done with ctx go to the primary, so a client sees its own writes
+// no matter how far the replicas lag behind
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryCtxKey{}, true)
+}
+
+func primaryRequested(ctx context.Context) bool {
+	pinned, _ := ctx.Value(primaryCtxKey{}).(bool)
+	return pinned
+}
+
+// read runs fn against a replica if there's a healthy one and falls back to the primary when the replica fails.
+// Reads inside of a transaction stay on it.
+func (s *Storage) read(ctx context.Context, fn func(q sqlx.ExtContext) error) error {
+	if txFromCtx(ctx) != nil || primaryRequested(ctx) {
+		return fn(s.ext(ctx))
+	}
+
+	r := s.pickReplica(time.Now())
+	if r == nil {
+		return fn(s.db)
+	}
+
+	err := fn(r.db)
+	if err == nil || ctx.Err() != nil || !replicaFailed(err) {
+		return err
+	}
+
+	slog.Warn("replica failed, reading from the primary", "err", err)
+	r.downUntil.Store(time.Now().Add(replicaCooldown).UnixNano())
+
+	return fn(s.db)
+}
+
+func (s *Storage) pickReplica(now time.Time) *replica {
+	n := uint64(len(s.replicas))
+	if n == 0 {
+		return nil
+	}
+
+	start := s.nextReplica.Add(1)
+	for i := uint64(0); i < n; i++ {
+		r := s.replicas[(start+i)%n]
+		if now.UnixNano() >= r.downUntil.Load() {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// replicaFailed tells a broken replica from a query that would fail on the primary as well
+func replicaFailed(err error) bool {
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		// no answer from the server at all
+		return true
+	}
+
+	switch {
+	case strings.HasPrefix(string(pqErr.Code), "08"), strings.HasPrefix(string(pqErr.Code), "57"):
+		// connection exceptions, shutdowns and the like
+		return true
+	case pqErr.Code == pqSerializationFailure:
+		// a conflict with the recovery on a hot standby
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"html"
+	"strings"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/jmoiron/sqlx"
+)
+
+// the expressions must stay the same as in the indexes of 03-user-search.sql
+const qSearchUsers = `
+SELECT id, first_name, last_name, created_at,
+	similarity(first_name || ' ' || last_name, $1)
+		+ ts_rank(to_tsvector('simple', first_name || ' ' || last_name), plainto_tsquery('simple', $1)) AS score,
+	CASE WHEN $4 THEN ts_headline('simple', first_name || ' ' || last_name, plainto_tsquery('simple', $1), $5)
+		ELSE '' END AS highlight
+FROM users
+WHERE (first_name || ' ' || last_name) % $1
+	OR to_tsvector('simple', first_name || ' ' || last_name) @@ plainto_tsquery('simple', $1)
+ORDER BY score DESC, created_at, id
+LIMIT $2 OFFSET $3`
+
+// ts_headline doesn't escape the names, so it marks the words with control characters
+// which are swapped for the tags once the rest is escaped
+const (
+	highlightStart   = "\x02"
+	highlightStop    = "\x03"
+	highlightOptions = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", HighlightAll=true"
+)
+
+var highlightTags = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+func highlightHTML(headline string) string {
+	return highlightTags.Replace(html.EscapeString(headline))
+}
+
+type dbUserMatch struct {
+	dbUser
+	Score     float64 `db:"score"`
+	Highlight string  `db:"highlight"`
+}
+
+func (s *Storage) SearchUsers(ctx context.Context, q entity.UserSearch) ([]entity.UserMatch, error) {
+	var rows []dbUserMatch
+	err := s.read(ctx, func(db sqlx.ExtContext) error {
+		rows = nil
+		return sqlx.SelectContext(ctx, db, &rows, qSearchUsers, q.Query, q.Limit, q.Offset, q.Highlight, highlightOptions)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]entity.UserMatch, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, entity.UserMatch{User: r.entity(), Score: r.Score, Highlight: highlightHTML(r.Highlight)})
+	}
+
+	return res, nil
+}
@@ -3,23 +3,137 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type Storage struct {
 	db *sqlx.DB
+
+	replicas    []*replica
+	nextReplica atomic.Uint64
+
+	webhookMaxAttempts int
 }
 
-func New(db *sqlx.DB) *Storage {
-	return &Storage{db: db}
+func New(db *sqlx.DB, opts ...Option) *Storage {
+	s := &Storage{db: db, webhookMaxAttempts: defaultWebhookMaxAttempts}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// ext is what queries run against, the transaction of WithTx if ctx carries one
+func (s *Storage) ext(ctx context.Context) sqlx.ExtContext {
+	if t := txFromCtx(ctx); t != nil {
+		return t.tx
+	}
+
+	return s.db
+}
+
+// inTx joins the transaction of WithTx if ctx carries one and starts a new one otherwise
+func (s *Storage) inTx(ctx context.Context, executor dbExecutor) error {
+	if t := txFromCtx(ctx); t != nil {
+		return executor(t.tx)
+	}
+
+	return runInTx(ctx, s.db, executor)
 }
 
 type dbExecutor func(tx *sqlx.Tx) error
 
-func runInTx(db *sqlx.DB, executor dbExecutor) error {
-	tx, err := db.BeginTxx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+// recordRelayed records the outcome of delivering claimed rows in a transaction of its own.
+// It goes on even if the relay is stopping, otherwise the delivered rows would be sent once again.
+func (s *Storage) recordRelayed(ctx context.Context, record func(ctx context.Context, tx *sqlx.Tx) error) error {
+	ctx = context.WithoutCancel(ctx)
+	return s.inTx(ctx, func(tx *sqlx.Tx) error {
+		return record(ctx, tx)
+	})
+}
+
+const (
+	txMaxAttempts = 3
+	txBaseDelay   = 10 * time.Millisecond
+	txMaxDelay    = 200 * time.Millisecond
+)
+
+type txOptions struct {
+	sql.TxOptions
+	maxAttempts int
+}
+
+// TxOption tunes a transaction started by WithTx
+type TxOption func(o *txOptions)
+
+// TxIsolation runs the transaction at the given isolation level instead of READ COMMITTED
+func TxIsolation(level sql.IsolationLevel) TxOption {
+	return func(o *txOptions) {
+		o.Isolation = level
+	}
+}
+
+// TxReadOnly makes the transaction read-only
+func TxReadOnly() TxOption {
+	return func(o *txOptions) {
+		o.ReadOnly = true
+	}
+}
+
+// TxMaxAttempts bounds how many times the transaction runs when it hits serialization failures or deadlocks,
+// n below 1 keeps the default
+func TxMaxAttempts(n int) TxOption {
+	return func(o *txOptions) {
+		if n >= 1 {
+			o.maxAttempts = n
+		}
+	}
+}
+
+func newTxOptions(opts []TxOption) txOptions {
+	o := txOptions{TxOptions: sql.TxOptions{Isolation: sql.LevelReadCommitted}, maxAttempts: txMaxAttempts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// runInTx runs executor in a READ COMMITTED transaction unless told otherwise.
+// Serialization failures and deadlocks make the whole transaction run again,
+// so executor must not have side effects besides the ones on tx.
+func runInTx(ctx context.Context, db *sqlx.DB, executor dbExecutor, opts ...TxOption) error {
+	o := newTxOptions(opts)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = runOnce(ctx, db, executor, &o.TxOptions)
+		if err == nil || !retryable(err) || attempt >= o.maxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-time.After(txBackoff(attempt)):
+		}
+	}
+}
+
+func runOnce(ctx context.Context, db *sqlx.DB, executor dbExecutor, opts *sql.TxOptions) error {
+	tx, err := db.BeginTxx(ctx, opts)
 	if err != nil {
 		return err
 	}
@@ -32,3 +146,28 @@ func runInTx(db *sqlx.DB, executor dbExecutor) error {
 	}
 	return tx.Commit()
 }
+
+const (
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+)
+
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	return pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected
+}
+
+// txBackoff doubles the delay with every attempt and picks a random point in its upper half,
+// so transactions that conflicted once don't collide again
+func txBackoff(attempt int) time.Duration {
+	d := txBaseDelay << (attempt - 1)
+	if d <= 0 || d > txMaxDelay {
+		d = txMaxDelay
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
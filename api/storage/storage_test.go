@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// commitFailingConn fails the commits with the errors queued up in commitErrs
+// and keeps the options of the last transaction it began
+type commitFailingConn struct {
+	commitErrs []error
+	commits    int
+	txOpts     driver.TxOptions
+}
+
+func (c *commitFailingConn) Connect(context.Context) (driver.Conn, error) {
+	return c, nil
+}
+
+func (c *commitFailingConn) Driver() driver.Driver {
+	return nil
+}
+
+func (c *commitFailingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *commitFailingConn) Close() error {
+	return nil
+}
+
+func (c *commitFailingConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *commitFailingConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.txOpts = opts
+	return c, nil
+}
+
+func (c *commitFailingConn) Rollback() error {
+	return nil
+}
+
+func (c *commitFailingConn) Commit() error {
+	c.commits++
+	if len(c.commitErrs) == 0 {
+		return nil
+	}
+
+	err := c.commitErrs[0]
+	c.commitErrs = c.commitErrs[1:]
+	return err
+}
+
+func TestRunInTxRetriesSerializationFailures(t *testing.T) {
+	t.Parallel()
+
+	serializationFailure := &pq.Error{Code: pqSerializationFailure}
+	tests := []struct {
+		name       string
+		commitErrs []error
+		wantRuns   int
+		wantErr    error
+	}{
+		{name: "retried until committed", commitErrs: []error{serializationFailure, serializationFailure}, wantRuns: 3},
+		{name: "gives up", commitErrs: []error{serializationFailure, serializationFailure, serializationFailure}, wantRuns: txMaxAttempts, wantErr: serializationFailure},
+		{name: "not retryable", commitErrs: []error{&pq.Error{Code: "23505"}}, wantRuns: 1, wantErr: &pq.Error{Code: "23505"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn := &commitFailingConn{commitErrs: tt.commitErrs}
+			db := sqlx.NewDb(sql.OpenDB(conn), "postgres")
+			defer db.Close()
+
+			runs := 0
+			err := runInTx(context.Background(), db, func(*sqlx.Tx) error {
+				runs++
+				return nil
+			})
+			assert.Equal(t, tt.wantRuns, runs)
+			assert.Equal(t, tt.wantRuns, conn.commits)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWithTxOptions(t *testing.T) {
+	t.Parallel()
+
+	serializationFailure := &pq.Error{Code: pqSerializationFailure}
+	tests := []struct {
+		name       string
+		opts       []TxOption
+		commitErrs []error
+		wantOpts   driver.TxOptions
+		wantRuns   int
+	}{
+		{name: "defaults", wantOpts: driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelReadCommitted)}, wantRuns: 1},
+		{
+			name:     "serializable read-only",
+			opts:     []TxOption{TxIsolation(sql.LevelSerializable), TxReadOnly()},
+			wantOpts: driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelSerializable), ReadOnly: true},
+			wantRuns: 1,
+		},
+		{
+			name:       "single attempt",
+			opts:       []TxOption{TxMaxAttempts(1)},
+			commitErrs: []error{serializationFailure},
+			wantOpts:   driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelReadCommitted)},
+			wantRuns:   1,
+		},
+		{
+			name:       "bad attempts keep the default",
+			opts:       []TxOption{TxMaxAttempts(0)},
+			commitErrs: []error{serializationFailure},
+			wantOpts:   driver.TxOptions{Isolation: driver.IsolationLevel(sql.LevelReadCommitted)},
+			wantRuns:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn := &commitFailingConn{commitErrs: tt.commitErrs}
+			db := sqlx.NewDb(sql.OpenDB(conn), "postgres")
+			defer db.Close()
+
+			runs := 0
+			_ = New(db).WithTx(context.Background(), func(context.Context) error {
+				runs++
+				return nil
+			}, tt.opts...)
+			assert.Equal(t, tt.wantOpts, conn.txOpts)
+			assert.Equal(t, tt.wantRuns, runs)
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, retryable(&pq.Error{Code: pqSerializationFailure}))
+	assert.True(t, retryable(fmt.Errorf("execute update: %w", &pq.Error{Code: pqDeadlockDetected})))
+	assert.False(t, retryable(&pq.Error{Code: "23505"}))
+	assert.False(t, retryable(errors.New("connection reset")))
+}
+
+func TestTxBackoff(t *testing.T) {
+	t.Parallel()
+
+	for attempt := 1; attempt < 70; attempt++ {
+		d := txBackoff(attempt)
+		assert.Greater(t, d, time.Duration(0), attempt)
+		assert.LessOrEqual(t, d, txMaxDelay, attempt)
+	}
+}
+
+func TestReplicaFailed(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, replicaFailed(errors.New("dial tcp: connection refused")))
+	assert.True(t, replicaFailed(&pq.Error{Code: "57P03"}))
+	assert.True(t, replicaFailed(&pq.Error{Code: "08006"}))
+	assert.False(t, replicaFailed(sql.ErrNoRows))
+	assert.False(t, replicaFailed(&pq.Error{Code: "22P02"}))
+}
+
+func TestPickReplica(t *testing.T) {
+	t.Parallel()
+
+	a, b := &sqlx.DB{}, &sqlx.DB{}
+	s := New(nil, WithReplicas(a, b))
+	now := time.Now()
+
+	first, second := s.pickReplica(now), s.pickReplica(now)
+	assert.NotSame(t, first, second)
+	assert.Same(t, first, s.pickReplica(now))
+
+	first.downUntil.Store(now.Add(time.Second).UnixNano())
+	assert.Same(t, second, s.pickReplica(now))
+	assert.Same(t, second, s.pickReplica(now))
+
+	second.downUntil.Store(now.Add(time.Second).UnixNano())
+	assert.Nil(t, s.pickReplica(now))
+	assert.NotNil(t, s.pickReplica(now.Add(time.Second)))
+
+	assert.Nil(t, New(nil).pickReplica(now))
+}
+
+func TestHighlightHTML(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "John <mark>Doe</mark>", highlightHTML("John "+highlightStart+"Doe"+highlightStop))
+	assert.Equal(t, "&lt;script&gt; <mark>&lt;b&gt;Doe&amp;</mark>",
+		highlightHTML("<script> "+highlightStart+"<b>Doe&"+highlightStop))
+	assert.Empty(t, highlightHTML(""))
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type txCtxKey struct{}
+
+// boundTx is the transaction of WithTx along with the hooks waiting for it to commit
+type boundTx struct {
+	tx          *sqlx.Tx
+	afterCommit []func()
+}
+
+func txFromCtx(ctx context.Context) *boundTx {
+	t, _ := ctx.Value(txCtxKey{}).(*boundTx)
+	return t
+}
+
+// WithTx runs fn in a single transaction which is committed unless fn returns an error.
+// Storage calls made with the ctx handed to fn join the transaction.
+// fn may be called several times when the transaction is retried, so it must not have side effects
+// besides the ones on the storage, anything else belongs to AfterCommit.
+// A WithTx inside of fn joins the outer transaction, its opts are ignored.
+func (s *Storage) WithTx(ctx context.Context, fn func(ctx context.Context) error, opts ...TxOption) error {
+	if txFromCtx(ctx) != nil {
+		return fn(ctx)
+	}
+
+	var committed []func()
+	err := runInTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		t := &boundTx{tx: tx}
+		if err := fn(context.WithValue(ctx, txCtxKey{}, t)); err != nil {
+			return err
+		}
+
+		committed = t.afterCommit
+		return nil
+	}, opts...)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range committed {
+		f()
+	}
+
+	return nil
+}
+
+// AfterCommit defers fn until the transaction of ctx commits, it's never called if the transaction is rolled back.
+// Without a transaction fn is called right away.
+func (s *Storage) AfterCommit(ctx context.Context, fn func()) {
+	t := txFromCtx(ctx)
+	if t == nil {
+		fn()
+		return
+	}
+
+	t.afterCommit = append(t.afterCommit, fn)
+}
@@ -5,15 +5,20 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 const (
 	qInsertUser          = "INSERT INTO users(first_name, last_name) VALUES($1, $2) RETURNING *"
 	qGetUserByID         = "SELECT * FROM users WHERE id=$1"
+	qGetUsersByIDs       = "SELECT * FROM users WHERE id = ANY($1)"
+	qListUsers           = "SELECT * FROM users WHERE first_name ILIKE $1 OR last_name ILIKE $1 ORDER BY created_at, id LIMIT $2 OFFSET $3"
 	qGetUserByIdWithLock = "SELECT * FROM users WHERE id=$1 FOR UPDATE"
 	qUpdateUser          = "UPDATE users SET first_name=$1, last_name=$2 WHERE id=$3 RETURNING *"
 	qDeleteUser          = "DELETE FROM users WHERE id=$1"
@@ -36,19 +41,29 @@ func (u dbUser) entity() entity.User {
 }
 
 func (s *Storage) InsertUser(ctx context.Context, u entity.User) (entity.User, error) {
-	var res dbUser
-	if err := s.db.GetContext(ctx, &res, qInsertUser, u.FirstName, u.LastName); err != nil {
+	err := s.inTx(ctx, func(tx *sqlx.Tx) error {
+		var res dbUser
+		if err := tx.GetContext(ctx, &res, qInsertUser, u.FirstName, u.LastName); err != nil {
+			return err
+		}
+
+		u.ID = res.ID
+		u.CreatedAt = res.CreatedAt
+		return recordEvent(ctx, tx, entity.EventUserCreated, u, nil)
+	})
+	if err != nil {
 		return entity.User{}, err
 	}
 
-	u.ID = res.ID
-	u.CreatedAt = res.CreatedAt
 	return u, nil
 }
 
 func (s *Storage) UserByID(ctx context.Context, id string) (entity.User, error) {
 	var res dbUser
-	if err := s.db.GetContext(ctx, &res, qGetUserByID, id); err != nil {
+	err := s.read(ctx, func(q sqlx.ExtContext) error {
+		return sqlx.GetContext(ctx, q, &res, qGetUserByID, id)
+	})
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			err = entity.ErrNotFound
 		}
@@ -58,6 +73,58 @@ func (s *Storage) UserByID(ctx context.Context, id string) (entity.User, error)
 	return res.entity(), nil
 }
 
+// UsersByIDs skips ids that aren't UUIDs, so they end up not found instead of failing the whole batch
+func (s *Storage) UsersByIDs(ctx context.Context, ids []string) ([]entity.User, error) {
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if parsed, err := uuid.Parse(id); err == nil {
+			valid = append(valid, parsed.String())
+		}
+	}
+	if len(valid) == 0 {
+		return []entity.User{}, nil
+	}
+
+	var rows []dbUser
+	err := s.read(ctx, func(q sqlx.ExtContext) error {
+		rows = nil
+		return sqlx.SelectContext(ctx, q, &rows, qGetUsersByIDs, pq.Array(valid))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usersFromRows(rows), nil
+}
+
+func (s *Storage) ListUsers(ctx context.Context, f entity.UserFilter) ([]entity.User, error) {
+	var rows []dbUser
+	err := s.read(ctx, func(q sqlx.ExtContext) error {
+		rows = nil
+		return sqlx.SelectContext(ctx, q, &rows, qListUsers, "%"+escapeLike(f.Name)+"%", f.Limit, f.Offset)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usersFromRows(rows), nil
+}
+
+func usersFromRows(rows []dbUser) []entity.User {
+	res := make([]entity.User, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, r.entity())
+	}
+
+	return res
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
 func (s *Storage) userByIDTx(ctx context.Context, tx *sqlx.Tx, id string) (dbUser, error) {
 	var res dbUser
 	if err := tx.GetContext(ctx, &res, qGetUserByIdWithLock, id); err != nil {
@@ -70,10 +137,12 @@ func (s *Storage) userByIDTx(ctx context.Context, tx *sqlx.Tx, id string) (dbUse
 	return res, nil
 }
 
-func (s *Storage) UpdateUser(ctx context.Context, id string, u entity.User) (entity.User, error) {
-	var updated entity.User
-	txErr := runInTx(s.db, func(tx *sqlx.Tx) error {
-		if _, err := s.userByIDTx(ctx, tx, id); err != nil {
+// UpdateUser returns the user as it was before the update along with the updated one
+func (s *Storage) UpdateUser(ctx context.Context, id string, u entity.User) (entity.UserUpdate, error) {
+	var update entity.UserUpdate
+	txErr := s.inTx(ctx, func(tx *sqlx.Tx) error {
+		existing, err := s.userByIDTx(ctx, tx, id)
+		if err != nil {
 			return err
 		}
 
@@ -82,16 +151,17 @@ func (s *Storage) UpdateUser(ctx context.Context, id string, u entity.User) (ent
 			return fmt.Errorf("execute update: %w", err)
 		}
 
-		updated = res.entity()
-		return nil
+		update = entity.UserUpdate{Before: existing.entity(), After: res.entity()}
+		return recordEvent(ctx, tx, entity.EventUserUpdated, update.After, &update.Before)
 	})
 
-	return updated, txErr
+	return update, txErr
 }
 
 func (s *Storage) DeleteUser(ctx context.Context, id string) error {
-	return runInTx(s.db, func(tx *sqlx.Tx) error {
-		if _, err := s.userByIDTx(ctx, tx, id); err != nil {
+	return s.inTx(ctx, func(tx *sqlx.Tx) error {
+		existing, err := s.userByIDTx(ctx, tx, id)
+		if err != nil {
 			return err
 		}
 
@@ -99,6 +169,6 @@ func (s *Storage) DeleteUser(ctx context.Context, id string) error {
 			return fmt.Errorf("execute delete: %w", err)
 		}
 
-		return nil
+		return recordEvent(ctx, tx, entity.EventUserDeleted, existing.entity(), nil)
 	})
 }
@@ -0,0 +1,418 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// webhookColumns leave out the previous secret once it has expired
+const webhookColumns = `id, url, event_types, format, secret, active, created_at,
+	CASE WHEN previous_secret_expires_at > now() THEN previous_secret END AS previous_secret,
+	CASE WHEN previous_secret_expires_at > now() THEN previous_secret_expires_at END AS previous_secret_expires_at`
+
+const (
+	qInsertWebhook       = "INSERT INTO webhooks(url, event_types, format, secret, active) VALUES($1, $2, $3, $4, $5) RETURNING " + webhookColumns
+	qListWebhooks        = "SELECT " + webhookColumns + " FROM webhooks ORDER BY created_at, id"
+	qGetWebhookByID      = "SELECT " + webhookColumns + " FROM webhooks WHERE id=$1"
+	qDeleteWebhook       = "DELETE FROM webhooks WHERE id=$1"
+	qRotateWebhookSecret = `UPDATE webhooks SET previous_secret = secret, secret = $2,
+		previous_secret_expires_at = now() + make_interval(secs => $3) WHERE id=$1 RETURNING ` + webhookColumns
+	// every active webhook gets its own copy of the notification, so subscribers are served independently
+	qFanOutWebhooks = `INSERT INTO webhook_deliveries(webhook_id, event_type, user_id, payload, event_seq, before, user_seq)
+		SELECT id, $1::varchar(16), $2, $3, $4, $5::jsonb, $6 FROM webhooks WHERE active AND $1::varchar(16) = ANY(event_types)`
+	// webhooks whose next delivery is due, that's the oldest one among the first pending deliveries of every user
+	qDueWebhooks = `SELECT webhook_id FROM (
+			SELECT DISTINCT ON (webhook_id) webhook_id, next_attempt_at FROM (
+				SELECT DISTINCT ON (webhook_id, user_id) webhook_id, seq, next_attempt_at FROM webhook_deliveries
+				WHERE sent_at IS NULL ORDER BY webhook_id, user_id, user_seq
+			) user_heads ORDER BY webhook_id, seq
+		) heads WHERE next_attempt_at <= now()`
+	qLockWebhook = "SELECT pg_try_advisory_xact_lock(hashtextextended('webhook ' || $1, 0))"
+	// a delivery waits for the pending ones of the same user with a lower user_seq, even if they were queued later
+	qPendingWebhookDelivery = `SELECT seq, COALESCE(event_seq, seq) AS event_seq, event_type, user_id, payload, before, user_seq, created_at,
+			next_attempt_at <= now() AS due FROM webhook_deliveries d
+		WHERE webhook_id=$1 AND sent_at IS NULL AND NOT EXISTS (
+			SELECT 1 FROM webhook_deliveries p WHERE p.webhook_id = d.webhook_id AND p.sent_at IS NULL
+				AND p.user_id = d.user_id AND p.user_seq < d.user_seq AND p.seq > d.seq
+		) ORDER BY seq LIMIT $2`
+	qWebhookClaimed           = "SELECT EXISTS(SELECT 1 FROM webhook_deliveries WHERE webhook_id=$1 AND sent_at IS NULL AND claimed_until > now())"
+	qClaimWebhookDeliveries   = "UPDATE webhook_deliveries SET claimed_by = $2, claimed_until = now() + $3 * interval '1 second' WHERE seq = ANY($1)"
+	qMarkWebhookDelivered     = "UPDATE webhook_deliveries SET sent_at = now(), claimed_by = NULL, claimed_until = NULL WHERE seq = ANY($1)"
+	qReleaseWebhookDeliveries = "UPDATE webhook_deliveries SET claimed_by = NULL, claimed_until = NULL WHERE seq = ANY($1) AND claimed_by = $2"
+	// the delay doubles with every failed attempt up to the maximum
+	qPostponeWebhookDelivery = `UPDATE webhook_deliveries SET attempts = attempts + 1, last_error = $2,
+		next_attempt_at = now() + make_interval(secs => least($3 * power(2, attempts), $4)),
+		claimed_by = NULL, claimed_until = NULL WHERE seq = $1`
+	// a delivery failed for the last allowed time is moved to the dead letters of its webhook
+	qDeadLetterWebhookDelivery = `WITH failed AS (DELETE FROM webhook_deliveries WHERE seq = $1 AND attempts + 1 >= $3
+			RETURNING webhook_id, seq, COALESCE(event_seq, seq) AS event_seq, event_type, user_id, payload, before, user_seq, attempts, created_at)
+		INSERT INTO webhook_dead_letters(webhook_id, delivery_seq, event_seq, event_type, user_id, payload, before, user_seq, last_error, attempts, created_at)
+		SELECT webhook_id, seq, event_seq, event_type, user_id, payload, before, user_seq, $2, attempts + 1, created_at FROM failed`
+	// pending deliveries of a user whose earlier one is a dead letter join it
+	qHoldBackWebhookDeliveries = `WITH held AS (DELETE FROM webhook_deliveries w WHERE webhook_id = $1 AND sent_at IS NULL
+				AND EXISTS (SELECT 1 FROM webhook_dead_letters d WHERE d.webhook_id = w.webhook_id AND d.user_id = w.user_id AND d.user_seq < w.user_seq)
+			RETURNING webhook_id, seq, COALESCE(event_seq, seq) AS event_seq, event_type, user_id, payload, before, user_seq, attempts, created_at)
+		INSERT INTO webhook_dead_letters(webhook_id, delivery_seq, event_seq, event_type, user_id, payload, before, user_seq, last_error, attempts, created_at)
+		SELECT webhook_id, seq, event_seq, event_type, user_id, payload, before, user_seq, $2, attempts, created_at FROM held`
+	qWebhookDeadLetters = `SELECT id, delivery_seq, event_seq, event_type, user_id, payload, before, user_seq, last_error, attempts, created_at, failed_at
+		FROM webhook_dead_letters WHERE webhook_id=$1 ORDER BY id LIMIT $2 OFFSET $3`
+	// replaying queues the deliveries again in the order of every user's changes, keeping the seq of the event
+	qReplayWebhookDeadLetters = `WITH replayed AS (DELETE FROM webhook_dead_letters WHERE webhook_id=$1
+			RETURNING webhook_id, delivery_seq, event_seq, event_type, user_id, payload, before, user_seq, created_at)
+		INSERT INTO webhook_deliveries(webhook_id, event_type, user_id, payload, event_seq, before, user_seq, created_at)
+		SELECT webhook_id, event_type, user_id, payload, event_seq, before, user_seq, created_at FROM replayed ORDER BY user_seq, delivery_seq`
+)
+
+const (
+	webhookRetryBaseDelay = time.Second
+	webhookRetryMaxDelay  = 5 * time.Minute
+	// webhookClaimFor is how long claimed deliveries stay with their relay
+	webhookClaimFor = 5 * time.Minute
+	// defaultWebhookMaxAttempts gives a receiver about 8 minutes to come back
+	defaultWebhookMaxAttempts = 10
+)
+
+// WithWebhookMaxAttempts sets how many times a webhook delivery is tried before it's moved to the dead letters
+// of the webhook, n below 1 keeps the default
+func WithWebhookMaxAttempts(n int) Option {
+	return func(s *Storage) {
+		if n >= 1 {
+			s.webhookMaxAttempts = n
+		}
+	}
+}
+
+type dbWebhook struct {
+	ID         string         `db:"id"`
+	URL        string         `db:"url"`
+	EventTypes pq.StringArray `db:"event_types"`
+	Format     string         `db:"format"`
+	Secret     string         `db:"secret"`
+	Active     bool           `db:"active"`
+	CreatedAt  time.Time      `db:"created_at"`
+
+	PreviousSecret          sql.NullString `db:"previous_secret"`
+	PreviousSecretExpiresAt sql.NullTime   `db:"previous_secret_expires_at"`
+}
+
+func (w dbWebhook) entity() entity.Webhook {
+	res := entity.Webhook{
+		ID:         w.ID,
+		URL:        w.URL,
+		EventTypes: make([]entity.EventType, 0, len(w.EventTypes)),
+		Format:     w.Format,
+		Secret:     w.Secret,
+		Active:     w.Active,
+		CreatedAt:  w.CreatedAt,
+	}
+	if w.PreviousSecret.Valid && w.PreviousSecretExpiresAt.Valid {
+		res.PreviousSecret = w.PreviousSecret.String
+		res.PreviousSecretExpiresAt = &w.PreviousSecretExpiresAt.Time
+	}
+	for _, t := range w.EventTypes {
+		res.EventTypes = append(res.EventTypes, entity.EventType(t))
+	}
+
+	return res
+}
+
+type dbWebhookDelivery struct {
+	dbUserEvent
+	// EventSeq is the seq of the outbox entry the delivery was made of
+	EventSeq int64 `db:"event_seq"`
+	Due      bool  `db:"due"`
+}
+
+type dbWebhookDeadLetter struct {
+	ID          int64     `db:"id"`
+	DeliverySeq int64     `db:"delivery_seq"`
+	EventSeq    int64     `db:"event_seq"`
+	EventType   string    `db:"event_type"`
+	UserID      string    `db:"user_id"`
+	Payload     []byte    `db:"payload"`
+	Before      []byte    `db:"before"`
+	UserSeq     int64     `db:"user_seq"`
+	LastError   string    `db:"last_error"`
+	Attempts    int       `db:"attempts"`
+	CreatedAt   time.Time `db:"created_at"`
+	FailedAt    time.Time `db:"failed_at"`
+}
+
+func (d dbWebhookDeadLetter) entity() (entity.DeadLetter, error) {
+	return dbDeadLetter{
+		ID:        d.ID,
+		EventSeq:  d.EventSeq,
+		EventType: d.EventType,
+		UserID:    d.UserID,
+		Payload:   d.Payload,
+		Before:    d.Before,
+		UserSeq:   d.UserSeq,
+		LastError: d.LastError,
+		Attempts:  d.Attempts,
+		CreatedAt: d.CreatedAt,
+		FailedAt:  d.FailedAt,
+	}.entity()
+}
+
+func (s *Storage) InsertWebhook(ctx context.Context, w entity.Webhook) (entity.Webhook, error) {
+	types := make(pq.StringArray, 0, len(w.EventTypes))
+	for _, t := range w.EventTypes {
+		types = append(types, string(t))
+	}
+
+	var res dbWebhook
+	if err := sqlx.GetContext(ctx, s.ext(ctx), &res, qInsertWebhook, w.URL, types, w.Format, w.Secret, w.Active); err != nil {
+		return entity.Webhook{}, err
+	}
+
+	return res.entity(), nil
+}
+
+func (s *Storage) Webhooks(ctx context.Context) ([]entity.Webhook, error) {
+	var rows []dbWebhook
+	if err := sqlx.SelectContext(ctx, s.ext(ctx), &rows, qListWebhooks); err != nil {
+		return nil, err
+	}
+
+	res := make([]entity.Webhook, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, r.entity())
+	}
+
+	return res, nil
+}
+
+func (s *Storage) WebhookByID(ctx context.Context, id string) (entity.Webhook, error) {
+	var res dbWebhook
+	if err := sqlx.GetContext(ctx, s.ext(ctx), &res, qGetWebhookByID, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = entity.ErrNotFound
+		}
+		return entity.Webhook{}, err
+	}
+
+	return res.entity(), nil
+}
+
+// RotateWebhookSecret replaces the secret of the webhook, the replaced one is kept as the previous secret for the grace period
+func (s *Storage) RotateWebhookSecret(ctx context.Context, id, secret string, grace time.Duration) (entity.Webhook, error) {
+	var res dbWebhook
+	if err := sqlx.GetContext(ctx, s.ext(ctx), &res, qRotateWebhookSecret, id, secret, grace.Seconds()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = entity.ErrNotFound
+		}
+		return entity.Webhook{}, err
+	}
+
+	return res.entity(), nil
+}
+
+// DeleteWebhook drops the webhook along with its pending deliveries
+func (s *Storage) DeleteWebhook(ctx context.Context, id string) error {
+	res, err := s.ext(ctx).ExecContext(ctx, qDeleteWebhook, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return entity.ErrNotFound
+	}
+
+	return nil
+}
+
+// DueWebhooks returns ids of the webhooks having deliveries to be made right now
+func (s *Storage) DueWebhooks(ctx context.Context) ([]string, error) {
+	var ids []string
+	if err := sqlx.SelectContext(ctx, s.ext(ctx), &ids, qDueWebhooks); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// RelayWebhook hands up to limit pending deliveries of the webhook to deliver in order and marks delivered ones as sent.
+// The first failure stops the relay and postpones the failed delivery with exponential backoff, so the order is kept.
+// A delivery which fails for the last allowed attempt is moved to the dead letters of the webhook instead,
+// and the ones after it go on, except for the later ones of the same user, which join it there.
+// It returns how many were sent along with the failure. Deliveries are claimed and marked the way RelayOutbox
+// does it with a claim per webhook, so a slow subscriber doesn't even hold up deleting its webhook.
+func (s *Storage) RelayWebhook(ctx context.Context, id string, limit int, deliver func(w entity.Webhook, e entity.UserEvent) error) (int, error) {
+	claim := uuid.NewString()
+	hook, rows, err := s.claimWebhookDeliveries(ctx, id, claim, limit)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	var sent, unsent []int64
+	var failed int64
+	var deliverErr, failure error
+	for _, r := range rows {
+		if deliverErr != nil {
+			unsent = append(unsent, r.Seq)
+			continue
+		}
+
+		e, err := r.entity()
+		if err == nil {
+			e.Seq = r.EventSeq
+			err = deliver(hook, e)
+		}
+		if err != nil {
+			deliverErr = fmt.Errorf("deliver %d to webhook %s: %w", r.Seq, id, err)
+			failed, failure = r.Seq, err
+			continue
+		}
+		sent = append(sent, r.Seq)
+	}
+
+	err = s.recordRelayed(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+		if len(sent) > 0 {
+			if _, err := tx.ExecContext(ctx, qMarkWebhookDelivered, pq.Array(sent)); err != nil {
+				return fmt.Errorf("mark deliveries sent: %w", err)
+			}
+		}
+
+		if failure != nil {
+			if err := s.failDelivery(ctx, tx, failed, failure); err != nil {
+				return err
+			}
+		}
+
+		if len(unsent) > 0 {
+			if _, err := tx.ExecContext(ctx, qReleaseWebhookDeliveries, pq.Array(unsent), claim); err != nil {
+				return fmt.Errorf("release deliveries: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(sent), deliverErr
+}
+
+// claimWebhookDeliveries claims up to limit pending deliveries of an active webhook as long as the oldest one is due
+// and no other relay holds a claim
+func (s *Storage) claimWebhookDeliveries(ctx context.Context, id, claim string, limit int) (entity.Webhook, []dbWebhookDelivery, error) {
+	var hook dbWebhook
+	var rows []dbWebhookDelivery
+	err := s.inTx(ctx, func(tx *sqlx.Tx) error {
+		rows = nil
+
+		var locked bool
+		if err := tx.GetContext(ctx, &locked, qLockWebhook, id); err != nil {
+			return fmt.Errorf("lock webhook: %w", err)
+		}
+		if !locked {
+			return nil
+		}
+
+		if err := tx.GetContext(ctx, &hook, qGetWebhookByID, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// deleted along with its deliveries in the meantime
+				return nil
+			}
+			return fmt.Errorf("get webhook: %w", err)
+		}
+		if !hook.Active {
+			return nil
+		}
+
+		var claimed bool
+		if err := tx.GetContext(ctx, &claimed, qWebhookClaimed, id); err != nil {
+			return fmt.Errorf("look for claimed deliveries: %w", err)
+		}
+		if claimed {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, qHoldBackWebhookDeliveries, id, ErrHeldBack.Error()); err != nil {
+			return fmt.Errorf("hold back deliveries: %w", err)
+		}
+
+		var pending []dbWebhookDelivery
+		if err := tx.SelectContext(ctx, &pending, qPendingWebhookDelivery, id, limit); err != nil {
+			return fmt.Errorf("get pending deliveries: %w", err)
+		}
+		if len(pending) == 0 || !pending[0].Due {
+			return nil
+		}
+
+		seqs := make([]int64, 0, len(pending))
+		for _, r := range pending {
+			seqs = append(seqs, r.Seq)
+		}
+		if _, err := tx.ExecContext(ctx, qClaimWebhookDeliveries, pq.Array(seqs), claim, webhookClaimFor.Seconds()); err != nil {
+			return fmt.Errorf("claim deliveries: %w", err)
+		}
+
+		rows = pending
+		return nil
+	})
+
+	return hook.entity(), rows, err
+}
+
+// failDelivery dead-letters the delivery once it's out of attempts and postpones it otherwise
+func (s *Storage) failDelivery(ctx context.Context, tx *sqlx.Tx, seq int64, failure error) error {
+	res, err := tx.ExecContext(ctx, qDeadLetterWebhookDelivery, seq, failure.Error(), s.webhookMaxAttempts)
+	if err != nil {
+		return fmt.Errorf("dead-letter delivery %d: %w", seq, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, qPostponeWebhookDelivery, seq, failure.Error(),
+		webhookRetryBaseDelay.Seconds(), webhookRetryMaxDelay.Seconds())
+	if err != nil {
+		return fmt.Errorf("postpone delivery %d: %w", seq, err)
+	}
+
+	return nil
+}
+
+// WebhookDeadLetters returns the deliveries of the webhook which failed all the attempts, oldest first
+func (s *Storage) WebhookDeadLetters(ctx context.Context, id string, limit, offset int) ([]entity.DeadLetter, error) {
+	var rows []dbWebhookDeadLetter
+	if err := sqlx.SelectContext(ctx, s.ext(ctx), &rows, qWebhookDeadLetters, id, limit, offset); err != nil {
+		return nil, err
+	}
+
+	res := make([]entity.DeadLetter, 0, len(rows))
+	for _, r := range rows {
+		d, err := r.entity()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+
+	return res, nil
+}
+
+// ReplayWebhookDeadLetters queues every dead letter of the webhook for delivery again and returns how many there were
+func (s *Storage) ReplayWebhookDeadLetters(ctx context.Context, id string) (int, error) {
+	res, err := s.ext(ctx).ExecContext(ctx, qReplayWebhookDeadLetters, id)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -1,10 +1,14 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/andyklimenko/testify-usage-example/api/entity"
 	"github.com/gorilla/mux"
@@ -14,6 +18,7 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 	var u entity.User
 	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
 		s.respondNotOK(w, http.StatusBadRequest, fmt.Errorf("decode request body: %w", err))
+		return
 	}
 
 	createdUser, err := s.repo.InsertUser(r.Context(), u)
@@ -22,7 +27,7 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go s.onUserCreated(createdUser)
+	s.kickRelay()
 
 	s.respondOK(w, http.StatusCreated, createdUser)
 }
@@ -53,6 +58,7 @@ func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 	var u entity.User
 	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
 		s.respondNotOK(w, http.StatusBadRequest, fmt.Errorf("decode request body: %w", err))
+		return
 	}
 
 	userID, ok := mux.Vars(r)["id"]
@@ -63,8 +69,8 @@ func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 
 	res, err := s.repo.UpdateUser(r.Context(), userID, u)
 	if err == nil {
-		go s.onUserUpdated(res)
-		s.respondOK(w, http.StatusOK, res)
+		s.kickRelay()
+		s.respondOK(w, http.StatusOK, res.After)
 		return
 	}
 
@@ -84,28 +90,13 @@ func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existing, err := s.repo.UserByID(r.Context(), userID)
-	if err != nil {
-		statusCode := statusByErr(err)
-
-		err = fmt.Errorf("looking for a user: %w", err)
-		if errors.Is(err, entity.ErrNotFound) {
-			err = fmt.Errorf("user %s not found", userID)
-		}
-
-		s.respondNotOK(w, statusCode, err)
-		return
-	}
-
-	err = s.repo.DeleteUser(r.Context(), userID)
+	err := s.deleteUserTx(r.Context(), userID)
 	if err == nil {
-		go s.onUserDeleted(existing)
 		s.respondOK(w, http.StatusOK, nil)
 		return
 	}
 
 	statusCode := statusByErr(err)
-	err = fmt.Errorf("delete user by id %s: %w", userID, err)
 	if errors.Is(err, entity.ErrNotFound) {
 		err = fmt.Errorf("user %s not found", userID)
 	}
@@ -113,10 +104,161 @@ func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
 	s.respondNotOK(w, statusCode, err)
 }
 
+// deleteUserTx looks the user up and deletes it in one transaction and hurries the relays up
+// once the deletion is committed
+func (s *Server) deleteUserTx(ctx context.Context, userID string) error {
+	return s.repo.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.repo.UserByID(ctx, userID); err != nil {
+			return fmt.Errorf("looking for a user: %w", err)
+		}
+
+		if err := s.repo.DeleteUser(ctx, userID); err != nil {
+			return fmt.Errorf("delete user by id %s: %w", userID, err)
+		}
+
+		s.repo.AfterCommit(ctx, s.kickRelay)
+		return nil
+	})
+}
+
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := entity.UserFilter{Name: q.Get("name")}
+
+	var err error
+	if f.Limit, f.Offset, err = pageParams(q); err != nil {
+		s.respondNotOK(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := s.usersPage(r.Context(), f)
+	if err != nil {
+		s.respondNotOK(w, statusByErr(err), err)
+		return
+	}
+
+	s.respondOK(w, http.StatusOK, page)
+}
+
+func (s *Server) searchUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	search := entity.UserSearch{Query: strings.TrimSpace(q.Get("q"))}
+
+	var err error
+	if search.Limit, search.Offset, err = pageParams(q); err != nil {
+		s.respondNotOK(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if raw := q.Get("highlight"); raw != "" {
+		if search.Highlight, err = strconv.ParseBool(raw); err != nil {
+			s.respondNotOK(w, http.StatusBadRequest, fmt.Errorf("invalid highlight %q", raw))
+			return
+		}
+	}
+
+	page, err := s.searchPage(r.Context(), search)
+	if err != nil {
+		s.respondNotOK(w, statusByErr(err), err)
+		return
+	}
+
+	s.respondOK(w, http.StatusOK, page)
+}
+
+// pageParams parses limit and offset query params, the limit defaults to defaultPageSize
+func pageParams(q url.Values) (int, int, error) {
+	limit, offset := defaultPageSize, 0
+
+	var err error
+	if raw := q.Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil {
+			return 0, 0, fmt.Errorf("invalid limit %q", raw)
+		}
+	}
+
+	if raw := q.Get("offset"); raw != "" {
+		if offset, err = strconv.Atoi(raw); err != nil {
+			return 0, 0, fmt.Errorf("invalid offset %q", raw)
+		}
+	}
+
+	return limit, offset, nil
+}
+
+func checkPage(limit, offset int) error {
+	if limit < 1 || limit > maxPageSize {
+		return fmt.Errorf("limit must be between 1 and %d: %w", maxPageSize, errBadRequest)
+	}
+	if offset < 0 {
+		return fmt.Errorf("offset can't be negative: %w", errBadRequest)
+	}
+
+	return nil
+}
+
+// fetchPage fetches one item more than limit, which tells whether there's another page
+func fetchPage[T any](limit int, fetch func(limit int) ([]T, error)) ([]T, bool, error) {
+	items, err := fetch(limit + 1)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(items) > limit {
+		return items[:limit], true, nil
+	}
+
+	return items, false, nil
+}
+
+func (s *Server) usersPage(ctx context.Context, f entity.UserFilter) (entity.UserPage, error) {
+	if err := checkPage(f.Limit, f.Offset); err != nil {
+		return entity.UserPage{}, err
+	}
+
+	users, more, err := fetchPage(f.Limit, func(limit int) ([]entity.User, error) {
+		page := f
+		page.Limit = limit
+		return s.repo.ListUsers(ctx, page)
+	})
+	if err != nil {
+		return entity.UserPage{}, fmt.Errorf("list users: %w", err)
+	}
+
+	return entity.UserPage{Items: users, Limit: f.Limit, Offset: f.Offset, HasMore: more}, nil
+}
+
+func (s *Server) searchPage(ctx context.Context, q entity.UserSearch) (entity.UserSearchPage, error) {
+	if q.Query == "" {
+		return entity.UserSearchPage{}, fmt.Errorf("empty search query: %w", errBadRequest)
+	}
+	if err := checkPage(q.Limit, q.Offset); err != nil {
+		return entity.UserSearchPage{}, err
+	}
+
+	limit := q.Limit
+	q.Limit++
+	matches, err := s.repo.SearchUsers(ctx, q)
+	if err != nil {
+		return entity.UserSearchPage{}, fmt.Errorf("search users: %w", err)
+	}
+
+	page := entity.UserSearchPage{Items: matches, Limit: limit, Offset: q.Offset}
+	if len(matches) > limit {
+		page.Items = matches[:limit]
+		page.HasMore = true
+	}
+
+	return page, nil
+}
+
 func statusByErr(err error) int {
 	if errors.Is(err, entity.ErrNotFound) {
 		return http.StatusNotFound
 	}
 
+	if errors.Is(err, errBadRequest) {
+		return http.StatusBadRequest
+	}
+
 	return http.StatusInternalServerError
 }
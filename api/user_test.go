@@ -2,9 +2,12 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/andyklimenko/testify-usage-example/api/entity"
@@ -18,16 +21,17 @@ type mockedChangelog struct {
 	mock.Mock
 }
 
-func (m *mockedChangelog) UserCreated(u entity.User) error {
-	return m.Called(u).Error(0)
+func (m *mockedChangelog) UserCreated(_ context.Context, e entity.UserEvent) error {
+	return m.Called(e.User).Error(0)
 }
 
-func (m *mockedChangelog) UserUpdated(u entity.User) error {
-	return m.Called(u).Error(0)
+// UserUpdated passes the whole event to expectations so they can check the previous state of the user
+func (m *mockedChangelog) UserUpdated(_ context.Context, e entity.UserEvent) error {
+	return m.Called(e).Error(0)
 }
 
-func (m *mockedChangelog) UserDeleted(u entity.User) error {
-	return m.Called(u).Error(0)
+func (m *mockedChangelog) UserDeleted(_ context.Context, e entity.UserEvent) error {
+	return m.Called(e.User).Error(0)
 }
 
 func (s *srvSuite) createTestUser(srvURL string, u entity.User) (entity.User, error) {
@@ -181,9 +185,13 @@ func (s *srvSuite) TestUpdateUser() {
 	require.NoError(s.T(), err)
 
 	cl.On("UserUpdated",
-		mock.MatchedBy(func(u entity.User) bool {
-			return assert.Equal(s.T(), "Darth", u.FirstName) &&
-				assert.Equal(s.T(), "Wader", u.LastName)
+		mock.MatchedBy(func(e entity.UserEvent) bool {
+			return assert.Equal(s.T(), "Darth", e.User.FirstName) &&
+				assert.Equal(s.T(), "Wader", e.User.LastName) &&
+				assert.NotNil(s.T(), e.Before) &&
+				assert.Equal(s.T(), userCreated.ID, e.Before.ID) &&
+				assert.Equal(s.T(), "Anakin", e.Before.FirstName) &&
+				assert.Equal(s.T(), []string{"first_name", "last_name"}, e.Changed)
 		}),
 	).Return(nil).Once()
 	getUsersResp, err := s.httpCli.Do(req)
@@ -262,3 +270,139 @@ func (s *srvSuite) TestDeleteUser() {
 
 	cl.AssertExpectations(s.T())
 }
+
+func (s *srvSuite) TestListUsers() {
+	var cl mockedChangelog
+	cl.On("UserCreated", mock.Anything).Return(nil)
+
+	srvURL, closer := s.setupServer(&cl)
+	defer closer()
+
+	lastName := "Tano-" + uuid.New().String()
+	for _, firstName := range []string{"Ahsoka", "Rex", "Cody"} {
+		_, err := s.createTestUser(srvURL, entity.User{FirstName: firstName, LastName: lastName})
+		require.NoError(s.T(), err)
+	}
+
+	resp, err := s.httpCli.Get(srvURL + "/users?limit=2&name=" + lastName)
+	require.NoError(s.T(), err)
+
+	defer entity.CloseBody(resp.Body)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+
+	var page entity.UserPage
+	require.NoError(s.T(), json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(s.T(), page.Items, 2)
+	assert.Equal(s.T(), "Ahsoka", page.Items[0].FirstName)
+	assert.Equal(s.T(), "Rex", page.Items[1].FirstName)
+	assert.True(s.T(), page.HasMore)
+
+	resp, err = s.httpCli.Get(srvURL + "/users?limit=2&offset=2&name=" + lastName)
+	require.NoError(s.T(), err)
+
+	defer entity.CloseBody(resp.Body)
+	require.NoError(s.T(), json.NewDecoder(resp.Body).Decode(&page))
+	require.Len(s.T(), page.Items, 1)
+	assert.Equal(s.T(), "Cody", page.Items[0].FirstName)
+	assert.False(s.T(), page.HasMore)
+}
+
+func (s *srvSuite) TestListUsersBadLimit() {
+	srvURL, closer := s.setupServer(nil)
+	defer closer()
+
+	resp, err := s.httpCli.Get(srvURL + "/users?limit=1000")
+	require.NoError(s.T(), err)
+
+	defer entity.CloseBody(resp.Body)
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *srvSuite) TestSearchUsers() {
+	var cl mockedChangelog
+	cl.On("UserCreated", mock.Anything).Return(nil)
+
+	srvURL, closer := s.setupServer(&cl)
+	defer closer()
+
+	lastName := "Doe" + uuid.New().String()[:8]
+	for _, firstName := range []string{"Jane", "John"} {
+		_, err := s.createTestUser(srvURL, entity.User{FirstName: firstName, LastName: lastName})
+		require.NoError(s.T(), err)
+	}
+
+	resp, err := s.httpCli.Get(srvURL + "/users/search?highlight=true&q=" + url.QueryEscape("jon "+strings.ToLower(lastName)))
+	require.NoError(s.T(), err)
+
+	defer entity.CloseBody(resp.Body)
+	require.Equal(s.T(), http.StatusOK, resp.StatusCode)
+
+	var page entity.UserSearchPage
+	require.NoError(s.T(), json.NewDecoder(resp.Body).Decode(&page))
+	require.NotEmpty(s.T(), page.Items)
+	assert.Equal(s.T(), "John", page.Items[0].FirstName)
+	assert.Equal(s.T(), "John <mark>"+lastName+"</mark>", page.Items[0].Highlight)
+	for i := 1; i < len(page.Items); i++ {
+		assert.LessOrEqual(s.T(), page.Items[i].Score, page.Items[i-1].Score)
+	}
+}
+
+func (s *srvSuite) TestSearchUsersNeedsQuery() {
+	srvURL, closer := s.setupServer(nil)
+	defer closer()
+
+	resp, err := s.httpCli.Get(srvURL + "/users/search?q=+")
+	require.NoError(s.T(), err)
+
+	defer entity.CloseBody(resp.Body)
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *srvSuite) TestWithTxSwapsNames() {
+	ctx := context.Background()
+	a, err := s.repo.InsertUser(ctx, entity.User{FirstName: "Han", LastName: "Solo"})
+	s.Require().NoError(err)
+	b, err := s.repo.InsertUser(ctx, entity.User{FirstName: "Leia", LastName: "Organa"})
+	s.Require().NoError(err)
+
+	swap := func(ctx context.Context) error {
+		if _, err := s.repo.UpdateUser(ctx, a.ID, entity.User{FirstName: b.FirstName, LastName: b.LastName}); err != nil {
+			return err
+		}
+		_, err := s.repo.UpdateUser(ctx, b.ID, entity.User{FirstName: a.FirstName, LastName: a.LastName})
+		return err
+	}
+
+	// a failure rolls back every change and skips the hooks
+	var committed bool
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		s.repo.AfterCommit(ctx, func() { committed = true })
+		if err := swap(ctx); err != nil {
+			return err
+		}
+		return s.repo.DeleteUser(ctx, uuid.NewString())
+	})
+	s.ErrorIs(err, entity.ErrNotFound)
+	s.False(committed)
+
+	got, err := s.repo.UserByID(ctx, a.ID)
+	s.Require().NoError(err)
+	s.Equal("Han", got.FirstName)
+
+	err = s.repo.WithTx(ctx, func(ctx context.Context) error {
+		s.repo.AfterCommit(ctx, func() { committed = true })
+		return swap(ctx)
+	})
+	s.Require().NoError(err)
+	s.True(committed)
+
+	got, err = s.repo.UserByID(ctx, a.ID)
+	s.Require().NoError(err)
+	s.Equal("Leia", got.FirstName)
+	s.Equal("Organa", got.LastName)
+
+	got, err = s.repo.UserByID(ctx, b.ID)
+	s.Require().NoError(err)
+	s.Equal("Han", got.FirstName)
+	s.Equal("Solo", got.LastName)
+}
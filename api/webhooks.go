@@ -0,0 +1,313 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/api/external/webhook"
+	"github.com/andyklimenko/testify-usage-example/config"
+	"github.com/andyklimenko/testify-usage-example/notifyformat"
+	"github.com/gorilla/mux"
+)
+
+// webhookSecretGrace is how long the previous secret still signs deliveries after a rotation
+const webhookSecretGrace = 24 * time.Hour
+
+var webhookEventTypes = []entity.EventType{entity.EventUserCreated, entity.EventUserUpdated, entity.EventUserDeleted}
+
+type webhookSender interface {
+	Send(ctx context.Context, w entity.Webhook, e entity.UserEvent) error
+}
+
+type webhookInput struct {
+	URL string `json:"url"`
+	// EventTypes default to every type
+	EventTypes []entity.EventType `json:"event_types"`
+	// Format defaults to the legacy one
+	Format string `json:"format"`
+	// Secret is generated when it's not given
+	Secret string `json:"secret"`
+	Active *bool  `json:"active"`
+}
+
+func (in webhookInput) webhook() (entity.Webhook, error) {
+	u, err := url.Parse(in.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return entity.Webhook{}, fmt.Errorf("%w: url must be an absolute http(s) url", errBadRequest)
+	}
+
+	format, err := notifyformat.Parse(in.Format)
+	if err != nil {
+		return entity.Webhook{}, fmt.Errorf("%w: %w", errBadRequest, err)
+	}
+
+	w := entity.Webhook{URL: in.URL, Format: string(format), Secret: in.Secret, Active: in.Active == nil || *in.Active}
+
+	w.EventTypes = webhookEventTypes
+	if len(in.EventTypes) > 0 {
+		w.EventTypes = nil
+		for _, t := range in.EventTypes {
+			if !slices.Contains(webhookEventTypes, t) {
+				return entity.Webhook{}, fmt.Errorf("%w: unknown event type %q", errBadRequest, t)
+			}
+			if !slices.Contains(w.EventTypes, t) {
+				w.EventTypes = append(w.EventTypes, t)
+			}
+		}
+	}
+
+	if w.Secret == "" {
+		if w.Secret, err = newWebhookSecret(); err != nil {
+			return entity.Webhook{}, err
+		}
+	}
+
+	return w, nil
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("generate secret: %w", err)
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request) {
+	var in webhookInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		s.respondNotOK(w, http.StatusBadRequest, fmt.Errorf("decode request body: %w", err))
+		return
+	}
+
+	hook, err := in.webhook()
+	if err == nil && !s.allowPrivateWebhooks {
+		if err = webhook.CheckTarget(hook.URL); err != nil {
+			err = fmt.Errorf("%w: %w", errBadRequest, err)
+		}
+	}
+	if err != nil {
+		s.respondNotOK(w, statusByErr(err), err)
+		return
+	}
+
+	created, err := s.repo.InsertWebhook(r.Context(), hook)
+	if err != nil {
+		s.respondNotOK(w, http.StatusInternalServerError, fmt.Errorf("create webhook: %w", err))
+		return
+	}
+
+	s.respondOK(w, http.StatusCreated, created)
+}
+
+func (s *Server) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks, err := s.repo.Webhooks(r.Context())
+	if err != nil {
+		s.respondNotOK(w, http.StatusInternalServerError, fmt.Errorf("list webhooks: %w", err))
+		return
+	}
+
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	s.respondOK(w, http.StatusOK, hooks)
+}
+
+func (s *Server) getWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	hook, err := s.repo.WebhookByID(r.Context(), id)
+	if err != nil {
+		s.respondNotOK(w, statusByErr(err), webhookErr(id, err))
+		return
+	}
+
+	hook.Secret = ""
+	s.respondOK(w, http.StatusOK, hook)
+}
+
+type rotateSecretInput struct {
+	// Secret is generated when it's not given
+	Secret string `json:"secret"`
+}
+
+// rotateWebhookSecret replaces the secret, the previous one keeps signing deliveries along with the new one
+// for webhookSecretGrace
+func (s *Server) rotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	var in rotateSecretInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil && !errors.Is(err, io.EOF) {
+		s.respondNotOK(w, http.StatusBadRequest, fmt.Errorf("decode request body: %w", err))
+		return
+	}
+
+	if in.Secret == "" {
+		var err error
+		if in.Secret, err = newWebhookSecret(); err != nil {
+			s.respondNotOK(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	id := mux.Vars(r)["id"]
+	hook, err := s.repo.RotateWebhookSecret(r.Context(), id, in.Secret, webhookSecretGrace)
+	if err != nil {
+		s.respondNotOK(w, statusByErr(err), webhookErr(id, err))
+		return
+	}
+
+	s.respondOK(w, http.StatusOK, hook)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := s.repo.DeleteWebhook(r.Context(), id); err != nil {
+		s.respondNotOK(w, statusByErr(err), webhookErr(id, err))
+		return
+	}
+
+	s.respondOK(w, http.StatusOK, nil)
+}
+
+func (s *Server) listWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r.URL.Query())
+	if err == nil {
+		err = checkPage(limit, offset)
+	}
+	if err != nil {
+		s.respondNotOK(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if _, err := s.repo.WebhookByID(r.Context(), id); err != nil {
+		s.respondNotOK(w, statusByErr(err), webhookErr(id, err))
+		return
+	}
+
+	items, more, err := fetchPage(limit, func(limit int) ([]entity.DeadLetter, error) {
+		return s.repo.WebhookDeadLetters(r.Context(), id, limit, offset)
+	})
+	if err != nil {
+		s.respondNotOK(w, http.StatusInternalServerError, fmt.Errorf("list dead letters of webhook %s: %w", id, err))
+		return
+	}
+
+	s.respondOK(w, http.StatusOK, entity.DeadLetterPage{Items: items, Limit: limit, Offset: offset, HasMore: more})
+}
+
+func (s *Server) replayWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := s.repo.WebhookByID(r.Context(), id); err != nil {
+		s.respondNotOK(w, statusByErr(err), webhookErr(id, err))
+		return
+	}
+
+	n, err := s.repo.ReplayWebhookDeadLetters(r.Context(), id)
+	if err != nil {
+		s.respondNotOK(w, http.StatusInternalServerError, fmt.Errorf("replay dead letters of webhook %s: %w", id, err))
+		return
+	}
+
+	s.kickRelay()
+	s.respondOK(w, http.StatusOK, countResponse{Count: n})
+}
+
+func newWebhookSender(cfg config.Notify) *webhook.Sender {
+	opts := []webhook.Option{webhook.WithSource(cfg.EventSource)}
+	if cfg.WebhookAllowPrivate {
+		opts = append(opts, webhook.WithPrivateTargets())
+	}
+
+	return webhook.New(opts...)
+}
+
+func webhookErr(id string, err error) error {
+	if errors.Is(err, entity.ErrNotFound) {
+		return fmt.Errorf("webhook %s not found", id)
+	}
+
+	return fmt.Errorf("webhook %s: %w", id, err)
+}
+
+// startWebhooks delivers pending webhook deliveries in the background until the returned func is called.
+// Every webhook is served by its own goroutine, so a slow subscriber holds up nobody but itself.
+func (s *Server) startWebhooks() func() {
+	interval := s.relayInterval
+	if interval <= 0 {
+		interval = defaultRelayInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	var busy sync.Map
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			ids, err := s.repo.DueWebhooks(ctx)
+			if err != nil && ctx.Err() == nil {
+				slog.Error("looking for due webhooks", "err", err)
+			}
+
+			for _, id := range ids {
+				if _, running := busy.LoadOrStore(id, struct{}{}); running {
+					continue
+				}
+
+				wg.Add(1)
+				go func(id string) {
+					defer wg.Done()
+					defer busy.Delete(id)
+
+					s.relayWebhook(ctx, id)
+				}(id)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-s.webhookKick:
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func (s *Server) relayWebhook(ctx context.Context, id string) {
+	for ctx.Err() == nil {
+		n, err := s.repo.RelayWebhook(ctx, id, relayBatchSize, func(w entity.Webhook, e entity.UserEvent) error {
+			return s.webhooks.Send(ctx, w, e)
+		})
+		if err != nil {
+			slog.Error("relaying webhook deliveries", "webhook", id, "sent", n, "err", err)
+			return
+		}
+
+		if n < relayBatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/api/storage"
+	"github.com/andyklimenko/testify-usage-example/api/storage/database"
+	"github.com/andyklimenko/testify-usage-example/signature"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookInput(t *testing.T) {
+	t.Parallel()
+
+	inactive := false
+	w, err := webhookInput{URL: "https://example.com/hook", Active: &inactive}.webhook()
+	require.NoError(t, err)
+	assert.Equal(t, webhookEventTypes, w.EventTypes, "every type by default")
+	assert.Equal(t, "legacy", w.Format)
+	assert.Len(t, w.Secret, 64, "a secret is generated")
+	assert.False(t, w.Active)
+
+	w, err = webhookInput{
+		URL:        "http://example.com/hook",
+		EventTypes: []entity.EventType{entity.EventUserDeleted, entity.EventUserDeleted},
+		Format:     "cloudevents-binary",
+		Secret:     "s3cr3t",
+	}.webhook()
+	require.NoError(t, err)
+	assert.Equal(t, []entity.EventType{entity.EventUserDeleted}, w.EventTypes)
+	assert.Equal(t, "s3cr3t", w.Secret)
+	assert.Equal(t, "cloudevents-binary", w.Format)
+	assert.True(t, w.Active)
+
+	_, err = webhookInput{URL: "example.com/hook"}.webhook()
+	assert.ErrorIs(t, err, errBadRequest)
+
+	_, err = webhookInput{URL: "https://example.com/hook", EventTypes: []entity.EventType{"RENAMED"}}.webhook()
+	assert.ErrorIs(t, err, errBadRequest)
+
+	_, err = webhookInput{URL: "https://example.com/hook", Format: "xml"}.webhook()
+	assert.ErrorIs(t, err, errBadRequest)
+}
+
+func TestCreateWebhookRejectsPrivateTargets(t *testing.T) {
+	t.Parallel()
+
+	testSrv := httptest.NewServer(setupRouter(&Server{tokens: []string{"secret"}}))
+	defer testSrv.Close()
+
+	req, err := http.NewRequest(http.MethodPost, testSrv.URL+"/webhooks", strings.NewReader(`{"url": "http://169.254.169.254/latest"}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func (s *srvSuite) createWebhook(srvURL string, in webhookInput) entity.Webhook {
+	raw, err := json.Marshal(in)
+	s.Require().NoError(err)
+
+	resp, err := s.httpCli.Post(srvURL+"/webhooks", "application/json", bytes.NewReader(raw))
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	s.Require().Equal(http.StatusCreated, resp.StatusCode)
+
+	var created entity.Webhook
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&created))
+	return created
+}
+
+func (s *srvSuite) deleteWebhook(srvURL, id string) {
+	req, err := http.NewRequest(http.MethodDelete, srvURL+"/webhooks/"+id, nil)
+	s.Require().NoError(err)
+
+	resp, err := s.httpCli.Do(req)
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+}
+
+func (s *srvSuite) TestWebhooksFanOut() {
+	var cl mockedChangelog
+	cl.On("UserCreated", mock.Anything).Return(nil)
+	cl.On("UserDeleted", mock.Anything).Return(nil)
+
+	srvURL, closer := s.setupServer(&cl)
+	defer closer()
+
+	type delivery struct {
+		hook string
+		body map[string]interface{}
+	}
+	delivered := make(chan delivery, 10)
+	receiver := func(name string, wait <-chan struct{}) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]interface{}
+			assert.NoError(s.T(), json.NewDecoder(r.Body).Decode(&body))
+			<-wait
+			delivered <- delivery{hook: name, body: body}
+		}))
+	}
+
+	ready := make(chan struct{})
+	close(ready)
+	fast := receiver("fast", ready)
+	defer fast.Close()
+	release := make(chan struct{})
+	slow := receiver("slow", release)
+	defer slow.Close()
+	defer close(release)
+
+	fastHook := s.createWebhook(srvURL, webhookInput{URL: fast.URL, EventTypes: []entity.EventType{entity.EventUserCreated}})
+	defer s.deleteWebhook(srvURL, fastHook.ID)
+	assert.NotEmpty(s.T(), fastHook.Secret)
+	// the slow webhook is deleted while its delivery is still in flight
+	slowHook := s.createWebhook(srvURL, webhookInput{URL: slow.URL})
+	defer s.deleteWebhook(srvURL, slowHook.ID)
+
+	resp, err := s.httpCli.Get(srvURL + "/webhooks/" + fastHook.ID)
+	s.Require().NoError(err)
+	var got entity.Webhook
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&got))
+	resp.Body.Close()
+	assert.Empty(s.T(), got.Secret, "the secret is shown only once")
+
+	created, err := s.createTestUser(srvURL, entity.User{FirstName: "Bo-Katan", LastName: "Kryze"})
+	s.Require().NoError(err)
+
+	// the slow one is still busy with the event
+	select {
+	case <-time.After(time.Second):
+		s.T().Fatal("timeout")
+	case d := <-delivered:
+		assert.Equal(s.T(), "fast", d.hook)
+		assert.Equal(s.T(), "CREATED", d.body["notification_type"])
+		assert.Equal(s.T(), created.ID, d.body["user"].(map[string]interface{})["id"])
+	}
+}
+
+func (s *srvSuite) TestWebhookSecretRotation() {
+	var cl mockedChangelog
+	cl.On("UserCreated", mock.Anything).Return(nil)
+
+	srvURL, closer := s.setupServer(&cl)
+	defer closer()
+
+	verified := make(chan error, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(s.T(), err)
+		verified <- errors.Join(signature.Verify(r.Header, body, "first"), signature.Verify(r.Header, body, "second"))
+	}))
+	defer receiver.Close()
+
+	hook := s.createWebhook(srvURL, webhookInput{URL: receiver.URL, Secret: "first"})
+	defer s.deleteWebhook(srvURL, hook.ID)
+
+	resp, err := s.httpCli.Post(srvURL+"/webhooks/"+hook.ID+"/rotate-secret", "application/json", strings.NewReader(`{"secret": "second"}`))
+	s.Require().NoError(err)
+	var rotated entity.Webhook
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&rotated))
+	resp.Body.Close()
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal(s.T(), "second", rotated.Secret)
+	s.Require().NotNil(rotated.PreviousSecretExpiresAt)
+
+	_, err = s.createTestUser(srvURL, entity.User{FirstName: "Fennec", LastName: "Shand"})
+	s.Require().NoError(err)
+
+	select {
+	case <-time.After(time.Second):
+		s.T().Fatal("timeout")
+	case err := <-verified:
+		assert.NoError(s.T(), err, "signed with both secrets during the grace period")
+	}
+}
+
+func (s *srvSuite) TestWebhookDeadLetters() {
+	// no relays in the background, the test relays by itself
+	srvURL, closer := s.setupServer(nil)
+	defer closer()
+
+	ctx := context.Background()
+	repo := storage.New(database.DB(), storage.WithWebhookMaxAttempts(1))
+	hook := s.createWebhook(srvURL, webhookInput{URL: "http://127.0.0.1:1/hook", EventTypes: []entity.EventType{entity.EventUserCreated}})
+	defer s.deleteWebhook(srvURL, hook.ID)
+
+	u, err := repo.InsertUser(ctx, entity.User{FirstName: "Cara", LastName: "Dune"})
+	s.Require().NoError(err)
+
+	var seq int64
+	_, err = repo.RelayWebhook(ctx, hook.ID, relayBatchSize, func(_ entity.Webhook, e entity.UserEvent) error {
+		seq = e.Seq
+		return errors.New("receiver is down")
+	})
+	s.Require().ErrorContains(err, "receiver is down")
+
+	// the only attempt is over, nothing is pending any longer
+	n, err := repo.RelayWebhook(ctx, hook.ID, relayBatchSize, func(entity.Webhook, entity.UserEvent) error {
+		s.Fail("a dead letter is delivered")
+		return nil
+	})
+	s.Require().NoError(err)
+	s.Zero(n)
+
+	resp, err := s.httpCli.Get(srvURL + "/webhooks/" + hook.ID + "/dead-letters")
+	s.Require().NoError(err)
+	var page entity.DeadLetterPage
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&page))
+	resp.Body.Close()
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Require().Len(page.Items, 1)
+	s.Equal(u.ID, page.Items[0].Event.User.ID)
+	s.Equal(seq, page.Items[0].Event.Seq)
+	s.Equal(1, page.Items[0].Attempts)
+	s.Equal("receiver is down", page.Items[0].LastError)
+
+	resp, err = s.httpCli.Post(srvURL+"/webhooks/"+hook.ID+"/dead-letters/replay", "application/json", nil)
+	s.Require().NoError(err)
+	var replayed countResponse
+	s.Require().NoError(json.NewDecoder(resp.Body).Decode(&replayed))
+	resp.Body.Close()
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	s.Equal(1, replayed.Count)
+
+	n, err = repo.RelayWebhook(ctx, hook.ID, relayBatchSize, func(_ entity.Webhook, e entity.UserEvent) error {
+		s.Equal(u.ID, e.User.ID)
+		s.Equal(seq, e.Seq, "a replayed delivery keeps the seq of its event")
+		return nil
+	})
+	s.Require().NoError(err)
+	s.Equal(1, n)
+
+	resp, err = s.httpCli.Get(srvURL + "/webhooks/" + uuid.NewString() + "/dead-letters")
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (s *srvSuite) TestReplayedWebhookDeliveryIsDue() {
+	// no relays in the background, the test relays by itself
+	srvURL, closer := s.setupServer(nil)
+	defer closer()
+
+	ctx := context.Background()
+	repo := storage.New(database.DB(), storage.WithWebhookMaxAttempts(1))
+	hook := s.createWebhook(srvURL, webhookInput{URL: "http://127.0.0.1:1/hook"})
+	defer s.deleteWebhook(srvURL, hook.ID)
+
+	u, err := repo.InsertUser(ctx, entity.User{FirstName: "Kuiil", LastName: "Ugnaught"})
+	s.Require().NoError(err)
+	_, err = repo.RelayWebhook(ctx, hook.ID, relayBatchSize, func(entity.Webhook, entity.UserEvent) error {
+		return errors.New("receiver is down")
+	})
+	s.Require().ErrorContains(err, "receiver is down")
+
+	// the update is queued before the creation comes back, and it's waiting for a retry
+	_, err = repo.UpdateUser(ctx, u.ID, entity.User{FirstName: "Kuiil", LastName: "of Arvala"})
+	s.Require().NoError(err)
+	_, err = repo.ReplayWebhookDeadLetters(ctx, hook.ID)
+	s.Require().NoError(err)
+	_, err = database.DB().Exec(`UPDATE webhook_deliveries SET next_attempt_at = now() + interval '1 hour'
+		WHERE webhook_id = $1 AND user_seq = 2`, hook.ID)
+	s.Require().NoError(err)
+
+	due, err := repo.DueWebhooks(ctx)
+	s.Require().NoError(err)
+	s.Contains(due, hook.ID, "the replayed creation goes first")
+}
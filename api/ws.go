@@ -0,0 +1,265 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsSendBufferSize  = 64
+	wsReplyBufferSize = 16
+	wsMaxMessageSize  = 4096
+)
+
+var (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+var errUnknownAction = errors.New("unknown action")
+
+func (s *Server) wsUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		Subprotocols:    []string{wsProtocol},
+		CheckOrigin:     s.allowedOrigin,
+	}
+}
+
+// allowedOrigin lets through clients that don't send an Origin, same-origin pages and the configured origins
+func (s *Server) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	origin = strings.TrimSuffix(origin, "/")
+	for _, allowed := range s.allowedOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type wsAction string
+
+const (
+	wsSubscribe   wsAction = "subscribe"
+	wsUnsubscribe wsAction = "unsubscribe"
+)
+
+type wsRequest struct {
+	Action  wsAction `json:"action"`
+	UserIDs []string `json:"user_ids,omitempty"`
+	Names   []string `json:"names,omitempty"`
+}
+
+type wsMessageType string
+
+const (
+	wsMsgSubscriptions wsMessageType = "subscriptions"
+	wsMsgEvent         wsMessageType = "event"
+	wsMsgError         wsMessageType = "error"
+)
+
+type wsMessage struct {
+	Type    wsMessageType     `json:"type"`
+	Event   *entity.UserEvent `json:"event,omitempty"`
+	UserIDs []string          `json:"user_ids,omitempty"`
+	Names   []string          `json:"names,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// wsSubscriptions is what a single connection is interested in: particular users and/or
+// name fragments matched case-insensitively against first and last names
+type wsSubscriptions struct {
+	mu      sync.Mutex
+	userIDs map[string]struct{}
+	names   map[string]struct{}
+}
+
+func (s *wsSubscriptions) apply(req wsRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.userIDs == nil {
+		s.userIDs = make(map[string]struct{})
+		s.names = make(map[string]struct{})
+	}
+
+	switch req.Action {
+	case wsSubscribe:
+		for _, id := range req.UserIDs {
+			s.userIDs[id] = struct{}{}
+		}
+		for _, n := range req.Names {
+			s.names[strings.ToLower(n)] = struct{}{}
+		}
+	case wsUnsubscribe:
+		for _, id := range req.UserIDs {
+			delete(s.userIDs, id)
+		}
+		for _, n := range req.Names {
+			delete(s.names, strings.ToLower(n))
+		}
+	default:
+		return errUnknownAction
+	}
+
+	return nil
+}
+
+func (s *wsSubscriptions) match(e entity.UserEvent) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.userIDs[e.User.ID]; ok {
+		return true
+	}
+
+	first, last := strings.ToLower(e.User.FirstName), strings.ToLower(e.User.LastName)
+	for n := range s.names {
+		if strings.Contains(first, n) || strings.Contains(last, n) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *wsSubscriptions) message() wsMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := wsMessage{Type: wsMsgSubscriptions}
+	for id := range s.userIDs {
+		msg.UserIDs = append(msg.UserIDs, id)
+	}
+	for n := range s.names {
+		msg.Names = append(msg.Names, n)
+	}
+
+	return msg
+}
+
+func (s *Server) watchUsers(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		s.respondNotOK(w, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	conn, err := s.wsUpgrader().Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already replied to the client
+		slog.Error("upgrading to websocket", "err", err)
+		return
+	}
+
+	var subs wsSubscriptions
+	replies := make(chan wsMessage, wsReplyBufferSize)
+	sub := s.events.subscribe(wsSendBufferSize)
+	defer s.events.unsubscribe(sub)
+
+	go s.wsWriteLoop(conn, sub, &subs, replies)
+	s.wsReadLoop(conn, &subs, replies)
+}
+
+func (s *Server) wsReadLoop(conn *websocket.Conn, subs *wsSubscriptions, replies chan<- wsMessage) {
+	defer close(replies)
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var req wsRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Error("reading websocket message", "err", err)
+			}
+			return
+		}
+
+		var reply wsMessage
+		if err := subs.apply(req); err != nil {
+			reply = wsMessage{Type: wsMsgError, Error: err.Error()}
+		} else {
+			reply = subs.message()
+		}
+
+		select {
+		case replies <- reply:
+		default:
+			// the client floods us with requests while not reading replies
+			return
+		}
+	}
+}
+
+func (s *Server) wsWriteLoop(conn *websocket.Conn, sub *eventSubscription, subs *wsSubscriptions, replies <-chan wsMessage) {
+	ping := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ping.Stop()
+		_ = conn.Close()
+	}()
+
+	for {
+		var msg wsMessage
+		select {
+		case reply, ok := <-replies:
+			if !ok {
+				return
+			}
+			msg = reply
+		case e, ok := <-sub.events:
+			if !ok {
+				// the server is going down or the client couldn't keep up with the send buffer
+				s.wsClose(conn, websocket.CloseTryAgainLater, "event stream interrupted")
+				return
+			}
+			if !subs.match(e) {
+				continue
+			}
+			msg = wsMessage{Type: wsMsgEvent, Event: &e}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+			continue
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) wsClose(conn *websocket.Conn, code int, text string) {
+	msg := websocket.FormatCloseMessage(code, text)
+	if err := conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteWait)); err != nil {
+		slog.Error("closing websocket", "err", err)
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func dialWatch(t *testing.T, srvURL string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	return websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srvURL, "http")+"/ws", header)
+}
+
+func readWatchMessage(t *testing.T, conn *websocket.Conn) wsMessage {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	var msg wsMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+
+	return msg
+}
+
+func TestWatchUsersAuth(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{tokens: []string{"secret"}}
+	testSrv := httptest.NewServer(setupRouter(srv))
+	defer testSrv.Close()
+
+	_, resp, err := dialWatch(t, testSrv.URL, nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	conn, _, err := dialWatch(t, testSrv.URL, http.Header{"Authorization": {"Bearer secret"}})
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	conn, resp, err = dialWatch(t, testSrv.URL, http.Header{"Sec-WebSocket-Protocol": {"users, bearer.secret"}})
+	require.NoError(t, err)
+	assert.Equal(t, "users", resp.Header.Get("Sec-WebSocket-Protocol"), "the token must not be echoed")
+	require.NoError(t, conn.Close())
+
+	_, resp, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(testSrv.URL, "http")+"/ws?access_token=secret", nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestWatchUsersOrigin(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{allowedOrigins: []string{"https://dashboard.example.com"}}
+	testSrv := httptest.NewServer(setupRouter(srv))
+	defer testSrv.Close()
+
+	_, resp, err := dialWatch(t, testSrv.URL, http.Header{"Origin": {"https://evil.example.com"}})
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	for _, origin := range []string{"https://dashboard.example.com", testSrv.URL} {
+		conn, _, err := dialWatch(t, testSrv.URL, http.Header{"Origin": {origin}})
+		require.NoError(t, err, origin)
+		require.NoError(t, conn.Close())
+	}
+}
+
+func TestWatchUsersSubscriptions(t *testing.T) {
+	t.Parallel()
+
+	srv := &Server{}
+	testSrv := httptest.NewServer(setupRouter(srv))
+	defer testSrv.Close()
+
+	conn, _, err := dialWatch(t, testSrv.URL, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.WriteJSON(wsRequest{Action: wsSubscribe, UserIDs: []string{"42"}, Names: []string{"SKY"}}))
+	ack := readWatchMessage(t, conn)
+	assert.Equal(t, wsMsgSubscriptions, ack.Type)
+	assert.Equal(t, []string{"42"}, ack.UserIDs)
+	assert.Equal(t, []string{"sky"}, ack.Names)
+
+	srv.events.publish(entity.UserEvent{Seq: 1, Type: entity.EventUserCreated, User: entity.User{ID: "1", LastName: "Solo"}})
+	srv.events.publish(entity.UserEvent{Seq: 2, Type: entity.EventUserCreated, User: entity.User{ID: "2", LastName: "Skywalker"}})
+	srv.events.publish(entity.UserEvent{Seq: 3, Type: entity.EventUserDeleted, User: entity.User{ID: "42"}})
+
+	msg := readWatchMessage(t, conn)
+	require.Equal(t, wsMsgEvent, msg.Type)
+	assert.EqualValues(t, 2, msg.Event.Seq)
+
+	msg = readWatchMessage(t, conn)
+	require.Equal(t, wsMsgEvent, msg.Type)
+	assert.EqualValues(t, 3, msg.Event.Seq)
+
+	require.NoError(t, conn.WriteJSON(wsRequest{Action: wsUnsubscribe, UserIDs: []string{"42"}}))
+	ack = readWatchMessage(t, conn)
+	assert.Empty(t, ack.UserIDs)
+
+	require.NoError(t, conn.WriteJSON(wsRequest{Action: "dance"}))
+	assert.Equal(t, wsMessage{Type: wsMsgError, Error: errUnknownAction.Error()}, readWatchMessage(t, conn))
+}
+
+func (s *srvSuite) TestWatchUserMutations() {
+	var cl mockedChangelog
+	cl.On("UserCreated", mock.Anything).Return(nil)
+
+	srvURL, closer := s.setupServer(&cl)
+	defer closer()
+
+	conn, _, err := dialWatch(s.T(), srvURL, http.Header{"Authorization": {"Bearer " + testToken}})
+	require.NoError(s.T(), err)
+	defer conn.Close()
+
+	require.NoError(s.T(), conn.WriteJSON(wsRequest{Action: wsSubscribe, Names: []string{"Kenobi"}}))
+	require.Equal(s.T(), wsMsgSubscriptions, readWatchMessage(s.T(), conn).Type)
+
+	userCreated, err := s.createTestUser(srvURL, entity.User{FirstName: "Obi-Wan", LastName: "Kenobi"})
+	require.NoError(s.T(), err)
+
+	msg := readWatchMessage(s.T(), conn)
+	require.Equal(s.T(), wsMsgEvent, msg.Type)
+	assert.Equal(s.T(), entity.EventUserCreated, msg.Event.Type)
+	assert.Equal(s.T(), userCreated.ID, msg.Event.User.ID)
+}
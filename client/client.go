@@ -0,0 +1,166 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+)
+
+var ErrNoBaseURL = errors.New("no base url")
+
+const pinPrimaryHeader = "X-Read-Primary-Until"
+
+// Client talks to the users API
+type Client struct {
+	baseURL *url.URL
+	cli     *http.Client
+	headers http.Header
+	retry   RetryPolicy
+	// pinPrimary is the latest read-your-writes deadline given by the server, sent back with every request
+	pinPrimary atomic.Value
+}
+
+type Option func(c *Client)
+
+// WithHTTPClient replaces the default http client having a 10 seconds timeout
+func WithHTTPClient(cli *http.Client) Option {
+	return func(c *Client) {
+		c.cli = cli
+	}
+}
+
+// WithToken authenticates every request with the bearer token
+func WithToken(token string) Option {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+// WithHeader adds a header to every request
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		c.headers.Set(key, value)
+	}
+}
+
+// WithRetry sets the policy of retrying idempotent calls
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = p
+	}
+}
+
+func New(baseURL string, opts ...Option) (*Client, error) {
+	if baseURL == "" {
+		return nil, ErrNoBaseURL
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parse base url: %w", err)
+	}
+
+	c := &Client{
+		baseURL: u,
+		cli:     &http.Client{Timeout: 10 * time.Second},
+		headers: make(http.Header),
+		retry:   DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// do executes the request and decodes a successful response into out, if it's not nil.
+// Idempotent requests are retried according to the retry policy.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("encode request body: %w", err)
+		}
+	}
+
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	attempts := 1
+	if idempotent(method) {
+		attempts = max(c.retry.MaxAttempts, 1)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, c.retry.backoff(attempt)); err != nil {
+				return errors.Join(lastErr, err)
+			}
+		}
+
+		lastErr = c.once(ctx, method, u.String(), body, out)
+		if lastErr == nil || !retryable(ctx, lastErr) {
+			return lastErr
+		}
+	}
+
+	return lastErr
+}
+
+func (c *Client) once(ctx context.Context, method, target string, body []byte, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+
+	for k, v := range c.headers {
+		req.Header[k] = v
+	}
+	if pin, ok := c.pinPrimary.Load().(string); ok {
+		req.Header.Set(pinPrimaryHeader, pin)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.cli.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, target, err)
+	}
+	defer entity.CloseBody(resp.Body)
+
+	if pin := resp.Header.Get(pinPrimaryHeader); pin != "" {
+		c.pinPrimary.Store(pin)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response body: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	require.NoError(t, json.NewEncoder(w).Encode(v))
+}
+
+func TestNewClient(t *testing.T) {
+	t.Parallel()
+
+	_, err := New("")
+	assert.ErrorIs(t, err, ErrNoBaseURL)
+}
+
+func TestGetUser(t *testing.T) {
+	t.Parallel()
+
+	u := entity.User{ID: "42", FirstName: "Din", LastName: "Djarin", CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "/users/42", r.URL.Path)
+		writeJSON(t, w, http.StatusOK, u)
+	}))
+	defer srv.Close()
+
+	cli, err := New(srv.URL, WithToken("secret"))
+	require.NoError(t, err)
+
+	got, err := cli.GetUser(context.Background(), "42")
+	require.NoError(t, err)
+	assert.Equal(t, u, got)
+}
+
+func TestAPIError(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, http.StatusNotFound, map[string]interface{}{"code": http.StatusNotFound, "text": "user 42 not found"})
+	}))
+	defer srv.Close()
+
+	cli, err := New(srv.URL)
+	require.NoError(t, err)
+
+	err = cli.DeleteUser(context.Background(), "42")
+	assert.ErrorIs(t, err, entity.ErrNotFound)
+
+	var apiErr *APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, &APIError{StatusCode: http.StatusNotFound, Code: http.StatusNotFound, Text: "user 42 not found"}, apiErr)
+}
+
+func TestRetries(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var u entity.User
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&u))
+		writeJSON(t, w, http.StatusOK, u)
+	}))
+	defer srv.Close()
+
+	cli, err := New(srv.URL, WithRetry(fastRetry))
+	require.NoError(t, err)
+
+	u, err := cli.UpdateUser(context.Background(), "42", entity.User{FirstName: "Grogu"})
+	require.NoError(t, err)
+	assert.Equal(t, "Grogu", u.FirstName)
+	assert.EqualValues(t, 3, calls.Load())
+
+	// creating a user isn't idempotent
+	calls.Store(0)
+	_, err = cli.CreateUser(context.Background(), entity.User{FirstName: "Grogu"})
+	assert.Equal(t, &APIError{StatusCode: http.StatusServiceUnavailable}, err)
+	assert.EqualValues(t, 1, calls.Load())
+}
+
+func TestRetriesStopWithContext(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	cli, err := New(srv.URL, WithRetry(RetryPolicy{MaxAttempts: 10, BaseDelay: time.Hour, MaxDelay: time.Hour}))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = cli.GetUser(ctx, "42")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.EqualValues(t, 1, calls.Load())
+}
+
+func TestUsersIterator(t *testing.T) {
+	t.Parallel()
+
+	var all []entity.User
+	for i := 0; i < 5; i++ {
+		all = append(all, entity.User{ID: strconv.Itoa(i)})
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "sky", r.URL.Query().Get("name"))
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		require.NoError(t, err)
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+
+		end := min(offset+limit, len(all))
+		writeJSON(t, w, http.StatusOK, entity.UserPage{
+			Items:   all[offset:end],
+			Limit:   limit,
+			Offset:  offset,
+			HasMore: end < len(all),
+		})
+	}))
+	defer srv.Close()
+
+	cli, err := New(srv.URL)
+	require.NoError(t, err)
+
+	var got []entity.User
+	it := cli.Users(context.Background(), ListOptions{Name: "sky", Limit: 2})
+	for it.Next() {
+		got = append(got, it.User())
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, all, got)
+}
+
+func TestClientSendsPrimaryPinBack(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			assert.Empty(t, r.Header.Get(pinPrimaryHeader))
+			w.Header().Set(pinPrimaryHeader, "1700000000000")
+		} else {
+			assert.Equal(t, "1700000000000", r.Header.Get(pinPrimaryHeader))
+		}
+		writeJSON(t, w, http.StatusOK, entity.User{ID: "42"})
+	}))
+	defer srv.Close()
+
+	cli, err := New(srv.URL)
+	require.NoError(t, err)
+
+	_, err = cli.UpdateUser(context.Background(), "42", entity.User{FirstName: "Bo-Katan"})
+	require.NoError(t, err)
+	_, err = cli.GetUser(context.Background(), "42")
+	require.NoError(t, err)
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+)
+
+type countResponse struct {
+	Count int `json:"count"`
+}
+
+// DeadLetters returns a single page of notifications the changelog didn't accept, zero limit leaves the page size to the server
+func (c *Client) DeadLetters(ctx context.Context, limit, offset int) (entity.DeadLetterPage, error) {
+	q := make(url.Values)
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	if offset > 0 {
+		q.Set("offset", strconv.Itoa(offset))
+	}
+
+	var res entity.DeadLetterPage
+	err := c.do(ctx, http.MethodGet, "/admin/dead-letters", q, nil, &res)
+	return res, err
+}
+
+func (c *Client) GetDeadLetter(ctx context.Context, id int64) (entity.DeadLetter, error) {
+	var res entity.DeadLetter
+	err := c.do(ctx, http.MethodGet, deadLetterPath(id), nil, nil, &res)
+	return res, err
+}
+
+// ReplayDeadLetter moves the dead letter back to the outbox so that the server delivers it again
+func (c *Client) ReplayDeadLetter(ctx context.Context, id int64) error {
+	return c.do(ctx, http.MethodPost, deadLetterPath(id)+"/replay", nil, nil, nil)
+}
+
+// ReplayDeadLetters moves every dead letter back to the outbox and returns how many there were
+func (c *Client) ReplayDeadLetters(ctx context.Context) (int, error) {
+	var res countResponse
+	err := c.do(ctx, http.MethodPost, "/admin/dead-letters/replay", nil, nil, &res)
+	return res.Count, err
+}
+
+// PurgeDeadLetters drops every dead letter and returns how many there were
+func (c *Client) PurgeDeadLetters(ctx context.Context) (int, error) {
+	var res countResponse
+	err := c.do(ctx, http.MethodDelete, "/admin/dead-letters", nil, nil, &res)
+	return res.Count, err
+}
+
+func deadLetterPath(id int64) string {
+	return "/admin/dead-letters/" + strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+)
+
+const maxErrorBody = 64 << 10
+
+// APIError is returned whenever the server replies with a non-2xx status.
+// It matches entity.ErrNotFound with errors.Is when the status is 404.
+type APIError struct {
+	StatusCode int
+	// Code and Text come from the error body of the server, if there was any
+	Code int
+	Text string
+}
+
+func (e *APIError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("unexpected response-code %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("response-code %d: %s", e.StatusCode, e.Text)
+}
+
+func (e *APIError) Is(target error) bool {
+	return target == entity.ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return apiErr
+	}
+
+	var body struct {
+		Code int    `json:"code"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		apiErr.Text = string(raw)
+		return apiErr
+	}
+
+	apiErr.Code = body.Code
+	apiErr.Text = body.Text
+	return apiErr
+}
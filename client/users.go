@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+)
+
+func (c *Client) CreateUser(ctx context.Context, u entity.User) (entity.User, error) {
+	var res entity.User
+	err := c.do(ctx, http.MethodPost, "/users", nil, u, &res)
+	return res, err
+}
+
+func (c *Client) GetUser(ctx context.Context, id string) (entity.User, error) {
+	var res entity.User
+	err := c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(id), nil, nil, &res)
+	return res, err
+}
+
+func (c *Client) UpdateUser(ctx context.Context, id string, u entity.User) (entity.User, error) {
+	var res entity.User
+	err := c.do(ctx, http.MethodPut, "/users/"+url.PathEscape(id), nil, u, &res)
+	return res, err
+}
+
+func (c *Client) DeleteUser(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/users/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// ListOptions narrows down and pages the list of users. Zero values leave the choice to the server.
+type ListOptions struct {
+	Name   string
+	Limit  int
+	Offset int
+}
+
+func (o ListOptions) query() url.Values {
+	q := make(url.Values)
+	if o.Name != "" {
+		q.Set("name", o.Name)
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		q.Set("offset", strconv.Itoa(o.Offset))
+	}
+
+	return q
+}
+
+// ListUsers returns a single page of users
+func (c *Client) ListUsers(ctx context.Context, opts ListOptions) (entity.UserPage, error) {
+	var res entity.UserPage
+	err := c.do(ctx, http.MethodGet, "/users", opts.query(), nil, &res)
+	return res, err
+}
+
+// Users iterates over all users matching the options, fetching pages of opts.Limit users as it goes:
+//
+//	it := cli.Users(ctx, client.ListOptions{Name: "sky"})
+//	for it.Next() {
+//		fmt.Println(it.User())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+func (c *Client) Users(ctx context.Context, opts ListOptions) *UserIterator {
+	return &UserIterator{ctx: ctx, cli: c, opts: opts, more: true}
+}
+
+type UserIterator struct {
+	ctx  context.Context
+	cli  *Client
+	opts ListOptions
+
+	page []entity.User
+	cur  entity.User
+	more bool
+	err  error
+}
+
+// Next advances to the next user, it returns false when there are no more users or an error happened
+func (it *UserIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	if len(it.page) == 0 {
+		if !it.more {
+			return false
+		}
+
+		page, err := it.cli.ListUsers(it.ctx, it.opts)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.page = page.Items
+		it.more = page.HasMore && len(page.Items) > 0
+		it.opts.Offset += len(page.Items)
+
+		if len(it.page) == 0 {
+			return false
+		}
+	}
+
+	it.cur, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+func (it *UserIterator) User() entity.User {
+	return it.cur
+}
+
+func (it *UserIterator) Err() error {
+	return it.err
+}
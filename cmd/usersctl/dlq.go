@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/spf13/cobra"
+)
+
+var errReplayArgs = errors.New("pass dead letter ids or --all")
+
+func newDLQCmd(g *globalFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dlq",
+		Short: "Inspect, replay and purge notifications the changelog didn't accept",
+	}
+
+	cmd.AddCommand(
+		newListDeadLettersCmd(g),
+		newGetDeadLetterCmd(g),
+		newReplayDeadLettersCmd(g),
+		newPurgeDeadLettersCmd(g),
+	)
+	return cmd
+}
+
+func parseDeadLetterIDs(args []string) ([]int64, error) {
+	ids := make([]int64, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dead letter id %q", arg)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func newListDeadLettersCmd(g *globalFlags) *cobra.Command {
+	var limit, offset int
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List dead letters (GET /admin/dead-letters)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cli, err := g.client()
+			if err != nil {
+				return err
+			}
+
+			page, err := cli.DeadLetters(cmd.Context(), limit, offset)
+			if err != nil {
+				return fmt.Errorf("list dead letters: %w", err)
+			}
+
+			return printDeadLetters(cmd.OutOrStdout(), g.output, false, page.Items...)
+		},
+	}
+	cmd.Flags().IntVar(&limit, "limit", 0, "page size, the server's default when not set")
+	cmd.Flags().IntVar(&offset, "offset", 0, "number of dead letters to skip")
+
+	return cmd
+}
+
+func newGetDeadLetterCmd(g *globalFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <id>...",
+		Short: "Show dead letters (GET /admin/dead-letters/{id})",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ids, err := parseDeadLetterIDs(args)
+			if err != nil {
+				return err
+			}
+
+			cli, err := g.client()
+			if err != nil {
+				return err
+			}
+
+			items := make([]entity.DeadLetter, 0, len(ids))
+			for _, id := range ids {
+				d, err := cli.GetDeadLetter(cmd.Context(), id)
+				if err != nil {
+					return fmt.Errorf("get dead letter %d: %w", id, err)
+				}
+				items = append(items, d)
+			}
+
+			return printDeadLetters(cmd.OutOrStdout(), g.output, len(ids) == 1, items...)
+		},
+	}
+}
+
+func newReplayDeadLettersCmd(g *globalFlags) *cobra.Command {
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "replay <id>... | --all",
+		Short: "Send dead letters to the changelog again (POST /admin/dead-letters/{id}/replay)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if all == (len(args) > 0) {
+				return errReplayArgs
+			}
+
+			ids, err := parseDeadLetterIDs(args)
+			if err != nil {
+				return err
+			}
+
+			cli, err := g.client()
+			if err != nil {
+				return err
+			}
+
+			if all {
+				n, err := cli.ReplayDeadLetters(cmd.Context())
+				if err != nil {
+					return fmt.Errorf("replay dead letters: %w", err)
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "%d dead letters replayed\n", n)
+				return nil
+			}
+
+			for _, id := range ids {
+				if err := cli.ReplayDeadLetter(cmd.Context(), id); err != nil {
+					return fmt.Errorf("replay dead letter %d: %w", id, err)
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "dead letter %d replayed\n", id)
+			}
+
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&all, "all", false, "replay every dead letter")
+
+	return cmd
+}
+
+func newPurgeDeadLettersCmd(g *globalFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "purge",
+		Short: "Drop every dead letter (DELETE /admin/dead-letters)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cli, err := g.client()
+			if err != nil {
+				return err
+			}
+
+			n, err := cli.PurgeDeadLetters(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("purge dead letters: %w", err)
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "%d dead letters purged\n", n)
+
+			return nil
+		},
+	}
+}
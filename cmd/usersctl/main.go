@@ -0,0 +1,43 @@
+// usersctl manages users of the users API from a terminal
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func main() {
+	if err := newRootCmd().Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+type globalFlags struct {
+	configPath string
+	profile    string
+	server     string
+	token      string
+	output     string
+}
+
+func newRootCmd() *cobra.Command {
+	var g globalFlags
+	root := &cobra.Command{
+		Use:           "usersctl",
+		Short:         "Manage users of the users API",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	root.PersistentFlags().StringVar(&g.configPath, "config", defaultConfigPath(), "path to the config file with profiles")
+	root.PersistentFlags().StringVarP(&g.profile, "profile", "p", "", "profile to use instead of the current one")
+	root.PersistentFlags().StringVar(&g.server, "server", "", "server address, overrides the profile")
+	root.PersistentFlags().StringVar(&g.token, "token", "", "bearer token, overrides the profile")
+	root.PersistentFlags().StringVarP(&g.output, "output", "o", string(formatTable), "output format: table, json or yaml")
+
+	root.AddCommand(newUsersCmd(&g), newDLQCmd(&g), newProfileCmd(&g))
+	return root
+}
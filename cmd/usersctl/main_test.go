@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testUser = entity.User{
+	ID:        "8d6f1ad6-7d5e-4b43-a87f-1bd7e6b8f0a4",
+	FirstName: "Cassian",
+	LastName:  "Andor",
+	CreatedAt: time.Date(2023, 11, 5, 10, 0, 0, 0, time.UTC),
+}
+
+func runCtl(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetArgs(append([]string{"--config", filepath.Join(t.TempDir(), "config.yaml")}, args...))
+	cmd.SetIn(strings.NewReader(stdin))
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestCreateUserFromStdin(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+
+		var u entity.User
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&u))
+		assert.Equal(t, "Cassian", u.FirstName)
+		assert.Equal(t, "Andor", u.LastName)
+
+		w.WriteHeader(http.StatusCreated)
+		require.NoError(t, json.NewEncoder(w).Encode(testUser))
+	}))
+	defer srv.Close()
+
+	out, err := runCtl(t, "first_name: Cassian\nlast_name: Andor\n",
+		"--server", srv.URL, "--token", "secret", "-o", "yaml", "users", "create", "-f", "-")
+	require.NoError(t, err)
+	assert.Equal(t, `created_at: "2023-11-05T10:00:00Z"
+first_name: Cassian
+id: 8d6f1ad6-7d5e-4b43-a87f-1bd7e6b8f0a4
+last_name: Andor
+`, out)
+}
+
+func TestListUsersTable(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "andor", r.URL.Query().Get("name"))
+		require.NoError(t, json.NewEncoder(w).Encode(entity.UserPage{Items: []entity.User{testUser}, Limit: 20}))
+	}))
+	defer srv.Close()
+
+	out, err := runCtl(t, "", "--server", srv.URL, "users", "list", "--name", "andor")
+	require.NoError(t, err)
+	assert.Equal(t, `ID                                    FIRST NAME  LAST NAME  CREATED AT
+8d6f1ad6-7d5e-4b43-a87f-1bd7e6b8f0a4  Cassian     Andor      2023-11-05T10:00:00Z
+`, out)
+}
+
+func TestUserInputNeedsNames(t *testing.T) {
+	t.Parallel()
+
+	_, err := runCtl(t, "", "--server", "http://localhost", "users", "create", "--first-name", "Cassian")
+	assert.ErrorIs(t, err, errNoUserInput)
+}
+
+func TestProfiles(t *testing.T) {
+	t.Parallel()
+
+	g := &globalFlags{configPath: filepath.Join(t.TempDir(), "config.yaml")}
+	require.NoError(t, saveConfig(g.configPath, ctlConfig{
+		Current: "local",
+		Profiles: map[string]profile{
+			"local": {Server: "http://localhost:8080"},
+			"prod":  {Server: "https://users.example.com", Token: "prod-token"},
+		},
+	}))
+
+	p, err := g.resolveProfile()
+	require.NoError(t, err)
+	assert.Equal(t, profile{Server: "http://localhost:8080"}, p)
+
+	g.profile = "prod"
+	p, err = g.resolveProfile()
+	require.NoError(t, err)
+	assert.Equal(t, profile{Server: "https://users.example.com", Token: "prod-token"}, p)
+
+	g.token = "override"
+	p, err = g.resolveProfile()
+	require.NoError(t, err)
+	assert.Equal(t, "override", p.Token)
+
+	g.profile = "staging"
+	_, err = g.resolveProfile()
+	assert.EqualError(t, err, `no profile "staging" in `+g.configPath)
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/admin/dead-letters/replay", r.URL.Path)
+		_, _ = w.Write([]byte(`{"count":3}`))
+	}))
+	defer srv.Close()
+
+	out, err := runCtl(t, "", "--server", srv.URL, "dlq", "replay", "--all")
+	require.NoError(t, err)
+	assert.Equal(t, "3 dead letters replayed\n", out)
+
+	_, err = runCtl(t, "", "--server", srv.URL, "dlq", "replay", "--all", "1")
+	assert.ErrorIs(t, err, errReplayArgs)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"gopkg.in/yaml.v3"
+)
+
+type outputFormat string
+
+const (
+	formatTable outputFormat = "table"
+	formatJSON  outputFormat = "json"
+	formatYAML  outputFormat = "yaml"
+)
+
+var userColumns = []string{"ID", "FIRST NAME", "LAST NAME", "CREATED AT"}
+
+func userRow(u entity.User) []string {
+	return []string{u.ID, u.FirstName, u.LastName, u.CreatedAt.Format(time.RFC3339)}
+}
+
+// printUsers writes users in the requested format, a single user is printed as an object rather than a list
+func printUsers(w io.Writer, format string, single bool, users ...entity.User) error {
+	var v interface{} = users
+	if single && len(users) == 1 {
+		v = users[0]
+	}
+
+	rows := make([][]string, 0, len(users))
+	for _, u := range users {
+		rows = append(rows, userRow(u))
+	}
+
+	return writeOutput(w, format, v, userColumns, rows)
+}
+
+var deadLetterColumns = []string{"ID", "EVENT", "USER", "ATTEMPTS", "FAILED AT", "LAST ERROR"}
+
+func deadLetterRow(d entity.DeadLetter) []string {
+	return []string{
+		strconv.FormatInt(d.ID, 10), string(d.Event.Type), d.Event.User.ID,
+		strconv.Itoa(d.Attempts), d.FailedAt.Format(time.RFC3339), d.LastError,
+	}
+}
+
+// printDeadLetters writes dead letters the same way as printUsers does with users
+func printDeadLetters(w io.Writer, format string, single bool, items ...entity.DeadLetter) error {
+	var v interface{} = items
+	if single && len(items) == 1 {
+		v = items[0]
+	}
+
+	rows := make([][]string, 0, len(items))
+	for _, d := range items {
+		rows = append(rows, deadLetterRow(d))
+	}
+
+	return writeOutput(w, format, v, deadLetterColumns, rows)
+}
+
+// writeOutput writes v as JSON or YAML, or the rows as a table
+func writeOutput(w io.Writer, format string, v interface{}, header []string, rows [][]string) error {
+	switch outputFormat(format) {
+	case formatTable:
+		return writeTable(w, header, rows)
+	case formatJSON:
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		e.SetEscapeHTML(false)
+		return e.Encode(v)
+	case formatYAML:
+		return writeYAML(w, v)
+	}
+
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func writeTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, strings.Join(header, "\t")); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if _, err := fmt.Fprintln(tw, strings.Join(row, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+// writeYAML goes through JSON so that keys stay the same as in the API
+func writeYAML(w io.Writer, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+
+	e := yaml.NewEncoder(w)
+	e.SetIndent(2)
+	if err := e.Encode(doc); err != nil {
+		return err
+	}
+
+	return e.Close()
+}
+
+// readUser decodes a user given as JSON or YAML
+func readUser(r io.Reader) (entity.User, error) {
+	var doc map[string]interface{}
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return entity.User{}, fmt.Errorf("decode user: %w", err)
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return entity.User{}, fmt.Errorf("decode user: %w", err)
+	}
+
+	var u entity.User
+	if err := json.Unmarshal(raw, &u); err != nil {
+		return entity.User{}, fmt.Errorf("decode user: %w", err)
+	}
+
+	return u, nil
+}
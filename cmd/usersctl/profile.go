@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/andyklimenko/testify-usage-example/client"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var errNoServer = errors.New("no server address: pass --server, set USERSCTL_SERVER or configure a profile")
+
+type profile struct {
+	Server string `yaml:"server"`
+	Token  string `yaml:"token,omitempty"`
+}
+
+type ctlConfig struct {
+	Current  string             `yaml:"current"`
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".usersctl.yaml"
+	}
+
+	return filepath.Join(dir, "usersctl", "config.yaml")
+}
+
+func loadConfig(path string) (ctlConfig, error) {
+	cfg := ctlConfig{Profiles: make(map[string]profile)}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("read config: %w", err)
+	}
+
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("decode config %s: %w", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]profile)
+	}
+
+	return cfg, nil
+}
+
+func saveConfig(path string, cfg ctlConfig) error {
+	raw, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create config dir: %w", err)
+	}
+
+	// profiles may hold tokens
+	return os.WriteFile(path, raw, 0o600)
+}
+
+// resolveProfile merges, in order of precedence, flags, USERSCTL_* env vars and the selected profile
+func (g *globalFlags) resolveProfile() (profile, error) {
+	cfg, err := loadConfig(g.configPath)
+	if err != nil {
+		return profile{}, err
+	}
+
+	name := g.profile
+	if name == "" {
+		name = os.Getenv("USERSCTL_PROFILE")
+	}
+	if name == "" {
+		name = cfg.Current
+	}
+
+	p, ok := cfg.Profiles[name]
+	if name != "" && !ok {
+		return profile{}, fmt.Errorf("no profile %q in %s", name, g.configPath)
+	}
+
+	for _, override := range []struct {
+		dst      *string
+		flag     string
+		envValue string
+	}{
+		{&p.Server, g.server, os.Getenv("USERSCTL_SERVER")},
+		{&p.Token, g.token, os.Getenv("USERSCTL_TOKEN")},
+	} {
+		if override.envValue != "" {
+			*override.dst = override.envValue
+		}
+		if override.flag != "" {
+			*override.dst = override.flag
+		}
+	}
+
+	if p.Server == "" {
+		return profile{}, errNoServer
+	}
+
+	return p, nil
+}
+
+func (g *globalFlags) client() (*client.Client, error) {
+	p, err := g.resolveProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []client.Option
+	if p.Token != "" {
+		opts = append(opts, client.WithToken(p.Token))
+	}
+
+	return client.New(p.Server, opts...)
+}
+
+func newProfileCmd(g *globalFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage server profiles",
+	}
+
+	var p profile
+	set := &cobra.Command{
+		Use:   "set <name>",
+		Short: "Create or update a profile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if p.Server == "" {
+				return errNoServer
+			}
+
+			cfg, err := loadConfig(g.configPath)
+			if err != nil {
+				return err
+			}
+
+			cfg.Profiles[args[0]] = p
+			if cfg.Current == "" {
+				cfg.Current = args[0]
+			}
+
+			return saveConfig(g.configPath, cfg)
+		},
+	}
+	set.Flags().StringVar(&p.Server, "server", "", "server address, e.g. http://localhost:8080")
+	set.Flags().StringVar(&p.Token, "token", "", "bearer token")
+
+	use := &cobra.Command{
+		Use:   "use <name>",
+		Short: "Make a profile the current one",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(g.configPath)
+			if err != nil {
+				return err
+			}
+
+			if _, ok := cfg.Profiles[args[0]]; !ok {
+				return fmt.Errorf("no profile %q in %s", args[0], g.configPath)
+			}
+
+			cfg.Current = args[0]
+			return saveConfig(g.configPath, cfg)
+		},
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List profiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cfg, err := loadConfig(g.configPath)
+			if err != nil {
+				return err
+			}
+
+			names := make([]string, 0, len(cfg.Profiles))
+			for name := range cfg.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			rows := make([][]string, 0, len(names))
+			for _, name := range names {
+				current := ""
+				if name == cfg.Current {
+					current = "*"
+				}
+				rows = append(rows, []string{current, name, cfg.Profiles[name].Server})
+			}
+
+			return writeTable(cmd.OutOrStdout(), []string{"CURRENT", "NAME", "SERVER"}, rows)
+		},
+	}
+
+	cmd.AddCommand(set, use, list)
+	return cmd
+}
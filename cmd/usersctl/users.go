@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/client"
+	"github.com/spf13/cobra"
+)
+
+var errNoUserInput = errors.New("pass --first-name and --last-name or a file with -f")
+
+// userInput is a user given either with flags or as a JSON/YAML file, "-" stands for stdin
+type userInput struct {
+	firstName string
+	lastName  string
+	file      string
+}
+
+func (in *userInput) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&in.firstName, "first-name", "", "first name")
+	cmd.Flags().StringVar(&in.lastName, "last-name", "", "last name")
+	cmd.Flags().StringVarP(&in.file, "file", "f", "", "read the user as JSON or YAML from a file, - for stdin")
+}
+
+// apply overlays the given fields on top of the base user, flags win over the file
+func (in *userInput) apply(base entity.User, stdin io.Reader) (entity.User, error) {
+	var fromFile entity.User
+	switch in.file {
+	case "":
+	case "-":
+		var err error
+		if fromFile, err = readUser(stdin); err != nil {
+			return base, err
+		}
+	default:
+		f, err := os.Open(in.file)
+		if err != nil {
+			return base, err
+		}
+		defer f.Close()
+
+		if fromFile, err = readUser(f); err != nil {
+			return base, err
+		}
+	}
+
+	u := base
+	for _, name := range []struct {
+		dst        *string
+		file, flag string
+	}{
+		{&u.FirstName, fromFile.FirstName, in.firstName},
+		{&u.LastName, fromFile.LastName, in.lastName},
+	} {
+		if name.file != "" {
+			*name.dst = name.file
+		}
+		if name.flag != "" {
+			*name.dst = name.flag
+		}
+	}
+
+	if u.FirstName == "" || u.LastName == "" {
+		return u, errNoUserInput
+	}
+
+	return u, nil
+}
+
+func newUsersCmd(g *globalFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "users",
+		Short: "Create, inspect, update, delete and list users",
+	}
+
+	cmd.AddCommand(
+		newCreateUserCmd(g),
+		newGetUserCmd(g),
+		newUpdateUserCmd(g),
+		newDeleteUserCmd(g),
+		newListUsersCmd(g),
+	)
+	return cmd
+}
+
+func newCreateUserCmd(g *globalFlags) *cobra.Command {
+	var in userInput
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a user (POST /users)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			u, err := in.apply(entity.User{}, cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+
+			cli, err := g.client()
+			if err != nil {
+				return err
+			}
+
+			created, err := cli.CreateUser(cmd.Context(), u)
+			if err != nil {
+				return fmt.Errorf("create user: %w", err)
+			}
+
+			return printUsers(cmd.OutOrStdout(), g.output, true, created)
+		},
+	}
+	in.register(cmd)
+
+	return cmd
+}
+
+func newGetUserCmd(g *globalFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <id>...",
+		Short: "Show users (GET /users/{id})",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cli, err := g.client()
+			if err != nil {
+				return err
+			}
+
+			users := make([]entity.User, 0, len(args))
+			for _, id := range args {
+				u, err := cli.GetUser(cmd.Context(), id)
+				if err != nil {
+					return fmt.Errorf("get user %s: %w", id, err)
+				}
+				users = append(users, u)
+			}
+
+			return printUsers(cmd.OutOrStdout(), g.output, len(args) == 1, users...)
+		},
+	}
+}
+
+func newUpdateUserCmd(g *globalFlags) *cobra.Command {
+	var in userInput
+	cmd := &cobra.Command{
+		Use:   "update <id>",
+		Short: "Update a user (PUT /users/{id})",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cli, err := g.client()
+			if err != nil {
+				return err
+			}
+
+			// only the given fields change, the rest is taken from the current state
+			current, err := cli.GetUser(cmd.Context(), args[0])
+			if err != nil {
+				return fmt.Errorf("get user %s: %w", args[0], err)
+			}
+
+			u, err := in.apply(current, cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+
+			updated, err := cli.UpdateUser(cmd.Context(), args[0], u)
+			if err != nil {
+				return fmt.Errorf("update user %s: %w", args[0], err)
+			}
+
+			return printUsers(cmd.OutOrStdout(), g.output, true, updated)
+		},
+	}
+	in.register(cmd)
+
+	return cmd
+}
+
+func newDeleteUserCmd(g *globalFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <id>...",
+		Short: "Delete users (DELETE /users/{id})",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cli, err := g.client()
+			if err != nil {
+				return err
+			}
+
+			for _, id := range args {
+				if err := cli.DeleteUser(cmd.Context(), id); err != nil {
+					return fmt.Errorf("delete user %s: %w", id, err)
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "user %s deleted\n", id)
+			}
+
+			return nil
+		},
+	}
+}
+
+func newListUsersCmd(g *globalFlags) *cobra.Command {
+	var opts client.ListOptions
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List users (GET /users)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cli, err := g.client()
+			if err != nil {
+				return err
+			}
+
+			if !all {
+				page, err := cli.ListUsers(cmd.Context(), opts)
+				if err != nil {
+					return fmt.Errorf("list users: %w", err)
+				}
+				return printUsers(cmd.OutOrStdout(), g.output, false, page.Items...)
+			}
+
+			var users []entity.User
+			it := cli.Users(cmd.Context(), opts)
+			for it.Next() {
+				users = append(users, it.User())
+			}
+			if err := it.Err(); err != nil {
+				return fmt.Errorf("list users: %w", err)
+			}
+
+			return printUsers(cmd.OutOrStdout(), g.output, false, users...)
+		},
+	}
+	cmd.Flags().StringVar(&opts.Name, "name", "", "part of the first or the last name")
+	cmd.Flags().IntVar(&opts.Limit, "limit", 0, "page size, the server's default when not set")
+	cmd.Flags().IntVar(&opts.Offset, "offset", 0, "number of users to skip")
+	cmd.Flags().BoolVar(&all, "all", false, "fetch every page")
+
+	return cmd
+}
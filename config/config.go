@@ -13,8 +13,8 @@ type Config struct {
 	DB     DB
 }
 
-func (c Config) Load() error {
-	if err := c.Server.load("server"); err != nil {
+func (c *Config) Load() error {
+	if err := c.Server.Load("server"); err != nil {
 		return fmt.Errorf("http server configuration: %w", err)
 	}
 
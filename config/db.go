@@ -1,14 +1,40 @@
 package config
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
-	ErrNoDbDSN = errors.New("no db dsn")
+	ErrNoDbDSN           = errors.New("no db dsn")
+	ErrBadMigrationDrift = errors.New(`migration drift must be either "fail" or "warn"`)
 )
 
 type DB struct {
 	Driver string
 	DSN    string
+	// ReplicaDSNs are read-only replicas of DSN taking over plain reads
+	ReplicaDSNs []string
+	// PinPrimaryFor is how long a client reads from the primary after it wrote something
+	PinPrimaryFor time.Duration
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// ConnectTimeout bounds a single attempt to connect, ConnectWait is how long to keep retrying at startup
+	ConnectTimeout time.Duration
+	ConnectWait    time.Duration
+	// StatementTimeout is the default statement_timeout of every session, 0 keeps the server default
+	StatementTimeout time.Duration
+
+	// MigrationDrift is what to do when an applied migration was edited: "fail" or "warn"
+	MigrationDrift string
+	// MigrationLockWait is how long an instance waits for another one to finish migrating
+	MigrationLockWait time.Duration
+	// MigrationLockTimeout and MigrationStatementTimeout bound every migration statement
+	MigrationLockTimeout      time.Duration
+	MigrationStatementTimeout time.Duration
 }
 
 func (db *DB) Load(envPrefix string) error {
@@ -22,5 +48,37 @@ func (db *DB) Load(envPrefix string) error {
 		return ErrNoDbDSN
 	}
 
+	db.ReplicaDSNs = splitList(v.GetString("replica_dsns"))
+	v.SetDefault("pin_primary_for", 5*time.Second)
+	db.PinPrimaryFor = v.GetDuration("pin_primary_for")
+
+	v.SetDefault("max_open_conns", 25)
+	db.MaxOpenConns = v.GetInt("max_open_conns")
+	v.SetDefault("max_idle_conns", 25)
+	db.MaxIdleConns = v.GetInt("max_idle_conns")
+	v.SetDefault("conn_max_lifetime", 30*time.Minute)
+	db.ConnMaxLifetime = v.GetDuration("conn_max_lifetime")
+	v.SetDefault("conn_max_idle_time", 5*time.Minute)
+	db.ConnMaxIdleTime = v.GetDuration("conn_max_idle_time")
+	v.SetDefault("connect_timeout", 5*time.Second)
+	db.ConnectTimeout = v.GetDuration("connect_timeout")
+	v.SetDefault("connect_wait", time.Minute)
+	db.ConnectWait = v.GetDuration("connect_wait")
+	v.SetDefault("statement_timeout", 30*time.Second)
+	db.StatementTimeout = v.GetDuration("statement_timeout")
+
+	v.SetDefault("migration_drift", "fail")
+	db.MigrationDrift = v.GetString("migration_drift")
+	if db.MigrationDrift != "fail" && db.MigrationDrift != "warn" {
+		return ErrBadMigrationDrift
+	}
+
+	v.SetDefault("migration_lock_wait", time.Minute)
+	db.MigrationLockWait = v.GetDuration("migration_lock_wait")
+	v.SetDefault("migration_lock_timeout", 5*time.Second)
+	db.MigrationLockTimeout = v.GetDuration("migration_lock_timeout")
+	v.SetDefault("migration_statement_timeout", time.Minute)
+	db.MigrationStatementTimeout = v.GetDuration("migration_statement_timeout")
+
 	return nil
 }
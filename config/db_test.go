@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -20,6 +21,46 @@ func TestDBLoad(t *testing.T) {
 
 	assert.Equal(t, "postgres", db.Driver)
 	assert.Equal(t, "localhost", db.DSN)
+	assert.Empty(t, db.ReplicaDSNs)
+	assert.Equal(t, 5*time.Second, db.PinPrimaryFor)
+	assert.Equal(t, 25, db.MaxOpenConns)
+	assert.Equal(t, 25, db.MaxIdleConns)
+	assert.Equal(t, 30*time.Minute, db.ConnMaxLifetime)
+	assert.Equal(t, 5*time.Minute, db.ConnMaxIdleTime)
+	assert.Equal(t, 5*time.Second, db.ConnectTimeout)
+	assert.Equal(t, time.Minute, db.ConnectWait)
+	assert.Equal(t, 30*time.Second, db.StatementTimeout)
+	assert.Equal(t, "fail", db.MigrationDrift)
+	assert.Equal(t, time.Minute, db.MigrationLockWait)
+	assert.Equal(t, 5*time.Second, db.MigrationLockTimeout)
+	assert.Equal(t, time.Minute, db.MigrationStatementTimeout)
+}
+
+func TestDBLoadPool(t *testing.T) {
+	t.Setenv("TEST_POOL_DSN", "localhost")
+	t.Setenv("TEST_POOL_MAX_OPEN_CONNS", "50")
+	t.Setenv("TEST_POOL_CONN_MAX_IDLE_TIME", "90s")
+	t.Setenv("TEST_POOL_STATEMENT_TIMEOUT", "0")
+	t.Setenv("TEST_POOL_REPLICA_DSNS", "host=replica1, host=replica2")
+
+	var db DB
+	require.NoError(t, db.Load("test.pool"))
+	assert.Equal(t, 50, db.MaxOpenConns)
+	assert.Equal(t, 90*time.Second, db.ConnMaxIdleTime)
+	assert.Zero(t, db.StatementTimeout)
+	assert.Equal(t, []string{"host=replica1", "host=replica2"}, db.ReplicaDSNs)
+}
+
+func TestDBLoadMigrationDrift(t *testing.T) {
+	t.Setenv("TEST_DRIFT_DSN", "localhost")
+
+	var db DB
+	t.Setenv("TEST_DRIFT_MIGRATION_DRIFT", "warn")
+	require.NoError(t, db.Load("test.drift"))
+	assert.Equal(t, "warn", db.MigrationDrift)
+
+	t.Setenv("TEST_DRIFT_MIGRATION_DRIFT", "ignore")
+	assert.ErrorIs(t, db.Load("test.drift"), ErrBadMigrationDrift)
 }
 
 func TestDBLoadOldWay(t *testing.T) {
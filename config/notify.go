@@ -1,11 +1,58 @@
 package config
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
 
-var ErrNoNotificationAddr = errors.New("no notification address")
+	"github.com/andyklimenko/testify-usage-example/notifyformat"
+)
+
+var (
+	ErrNoNotificationAddr = errors.New("no notification address")
+	ErrBadRetryStatus     = errors.New("bad retry status")
+	ErrTooManySecrets     = errors.New("too many notification secrets")
+)
 
 type Notify struct {
 	Addr string
+	// Format is the shape of changelog notifications
+	Format notifyformat.Format
+	// EventSource is the source of CloudEvents, both the changelog's and webhooks'
+	EventSource string
+	// Secrets sign notifications, the second one is the previous secret kept while receivers move to the new one
+	Secrets []string
+	// RelayInterval is how often the outbox is checked for notifications written by other instances
+	RelayInterval time.Duration
+
+	// RetryMaxAttempts includes the first attempt, 1 disables retries
+	RetryMaxAttempts int
+	RetryBaseDelay   time.Duration
+	RetryMaxDelay    time.Duration
+	// RetryJitter is the randomized share of every delay from 0 to 1
+	RetryJitter        float64
+	RetryStatuses      []int
+	RetryNetworkErrors bool
+
+	// BreakerFailureThreshold is the number of failed posts in a row that opens the circuit, 0 disables the breaker
+	BreakerFailureThreshold int
+	// BreakerCoolDown is how long the circuit stays open before a probe is let through
+	BreakerCoolDown time.Duration
+	// BreakerSuccessThreshold is the number of successful probes that close the circuit
+	BreakerSuccessThreshold int
+
+	// BatchSize is the most notifications sent in one request, 0 or 1 sends them one by one
+	BatchSize int
+	// BatchMaxWait is how long notifications may wait for others to fill a batch up
+	BatchMaxWait time.Duration
+	// BatchFormat is how a batch is put into the body
+	BatchFormat notifyformat.BatchFormat
+
+	// WebhookAllowPrivate lets webhooks target loopback, link-local and private addresses
+	WebhookAllowPrivate bool
+	// WebhookMaxAttempts is how many times a webhook delivery is tried before it's moved to the dead letters of the webhook
+	WebhookMaxAttempts int
 }
 
 func (n *Notify) load(envPrefix string) error {
@@ -16,5 +63,60 @@ func (n *Notify) load(envPrefix string) error {
 		return ErrNoNotificationAddr
 	}
 
+	var err error
+	if n.Format, err = notifyformat.Parse(v.GetString("format")); err != nil {
+		return err
+	}
+	v.SetDefault("event_source", "/users")
+	n.EventSource = v.GetString("event_source")
+
+	n.Secrets = splitList(v.GetString("secrets"))
+	if len(n.Secrets) > 2 {
+		return fmt.Errorf("%w: %d, at most the current and the previous one", ErrTooManySecrets, len(n.Secrets))
+	}
+
+	v.SetDefault("relay_interval", time.Second)
+	n.RelayInterval = v.GetDuration("relay_interval")
+
+	v.SetDefault("retry_max_attempts", 5)
+	n.RetryMaxAttempts = v.GetInt("retry_max_attempts")
+	v.SetDefault("retry_base_delay", 200*time.Millisecond)
+	n.RetryBaseDelay = v.GetDuration("retry_base_delay")
+	v.SetDefault("retry_max_delay", 10*time.Second)
+	n.RetryMaxDelay = v.GetDuration("retry_max_delay")
+	v.SetDefault("retry_jitter", 0.5)
+	n.RetryJitter = v.GetFloat64("retry_jitter")
+	v.SetDefault("retry_network_errors", true)
+	n.RetryNetworkErrors = v.GetBool("retry_network_errors")
+
+	v.SetDefault("breaker_failure_threshold", 5)
+	n.BreakerFailureThreshold = v.GetInt("breaker_failure_threshold")
+	v.SetDefault("breaker_cool_down", 30*time.Second)
+	n.BreakerCoolDown = v.GetDuration("breaker_cool_down")
+	v.SetDefault("breaker_success_threshold", 1)
+	n.BreakerSuccessThreshold = v.GetInt("breaker_success_threshold")
+
+	v.SetDefault("batch_size", 0)
+	n.BatchSize = v.GetInt("batch_size")
+	v.SetDefault("batch_max_wait", 100*time.Millisecond)
+	n.BatchMaxWait = v.GetDuration("batch_max_wait")
+	if n.BatchFormat, err = notifyformat.ParseBatch(v.GetString("batch_format")); err != nil {
+		return err
+	}
+
+	n.WebhookAllowPrivate = v.GetBool("webhook_allow_private")
+	v.SetDefault("webhook_max_attempts", 10)
+	n.WebhookMaxAttempts = v.GetInt("webhook_max_attempts")
+
+	v.SetDefault("retry_statuses", "408,429,500,502,503,504")
+	n.RetryStatuses = nil
+	for _, raw := range splitList(v.GetString("retry_statuses")) {
+		code, err := strconv.Atoi(raw)
+		if err != nil || code < 100 || code > 599 {
+			return fmt.Errorf("%w %q", ErrBadRetryStatus, raw)
+		}
+		n.RetryStatuses = append(n.RetryStatuses, code)
+	}
+
 	return nil
 }
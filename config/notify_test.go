@@ -3,7 +3,9 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
+	"github.com/andyklimenko/testify-usage-example/notifyformat"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -17,4 +19,95 @@ func TestNotifyLoad(t *testing.T) {
 	require.NoError(t, os.Setenv("NOTIFY_ADDRESS", "test"))
 	require.NoError(t, cfg.load("notify"))
 	assert.Equal(t, "test", cfg.Addr)
+	assert.Equal(t, time.Second, cfg.RelayInterval)
+	assert.Equal(t, notifyformat.Legacy, cfg.Format)
+	assert.Equal(t, "/users", cfg.EventSource)
+	assert.Equal(t, 5, cfg.RetryMaxAttempts)
+	assert.Equal(t, 200*time.Millisecond, cfg.RetryBaseDelay)
+	assert.Equal(t, 10*time.Second, cfg.RetryMaxDelay)
+	assert.Equal(t, 0.5, cfg.RetryJitter)
+	assert.Equal(t, []int{408, 429, 500, 502, 503, 504}, cfg.RetryStatuses)
+	assert.True(t, cfg.RetryNetworkErrors)
+	assert.Equal(t, 5, cfg.BreakerFailureThreshold)
+	assert.Equal(t, 30*time.Second, cfg.BreakerCoolDown)
+	assert.Equal(t, 1, cfg.BreakerSuccessThreshold)
+	assert.Zero(t, cfg.BatchSize)
+	assert.Equal(t, 100*time.Millisecond, cfg.BatchMaxWait)
+	assert.Equal(t, notifyformat.BatchJSON, cfg.BatchFormat)
+}
+
+func TestNotifyLoadRetryStatuses(t *testing.T) {
+	t.Setenv("TEST_RETRY_ADDRESS", "test")
+	t.Setenv("TEST_RETRY_RETRY_STATUSES", "503, 429")
+
+	var cfg Notify
+	require.NoError(t, cfg.load("test.retry"))
+	assert.Equal(t, []int{503, 429}, cfg.RetryStatuses)
+
+	t.Setenv("TEST_RETRY_RETRY_STATUSES", "5xx")
+	assert.ErrorIs(t, cfg.load("test.retry"), ErrBadRetryStatus)
+}
+
+func TestNotifyLoadSecrets(t *testing.T) {
+	t.Setenv("TEST_SECRETS_ADDRESS", "test")
+	t.Setenv("TEST_SECRETS_SECRETS", "new, old")
+
+	var cfg Notify
+	require.NoError(t, cfg.load("test.secrets"))
+	assert.Equal(t, []string{"new", "old"}, cfg.Secrets)
+
+	t.Setenv("TEST_SECRETS_SECRETS", "newest,new,old")
+	assert.ErrorIs(t, cfg.load("test.secrets"), ErrTooManySecrets)
+}
+
+func TestNotifyLoadFormat(t *testing.T) {
+	t.Setenv("TEST_FORMAT_ADDRESS", "test")
+	t.Setenv("TEST_FORMAT_FORMAT", "cloudevents-binary")
+
+	var cfg Notify
+	require.NoError(t, cfg.load("test.format"))
+	assert.Equal(t, notifyformat.CloudEventsBinary, cfg.Format)
+
+	t.Setenv("TEST_FORMAT_FORMAT", "xml")
+	assert.ErrorIs(t, cfg.load("test.format"), notifyformat.ErrBadFormat)
+}
+
+func TestNotifyLoadBatch(t *testing.T) {
+	t.Setenv("TEST_BATCH_ADDRESS", "test")
+	t.Setenv("TEST_BATCH_BATCH_SIZE", "50")
+	t.Setenv("TEST_BATCH_BATCH_MAX_WAIT", "1s")
+	t.Setenv("TEST_BATCH_BATCH_FORMAT", "ndjson")
+
+	var cfg Notify
+	require.NoError(t, cfg.load("test.batch"))
+	assert.Equal(t, 50, cfg.BatchSize)
+	assert.Equal(t, time.Second, cfg.BatchMaxWait)
+	assert.Equal(t, notifyformat.BatchNDJSON, cfg.BatchFormat)
+
+	t.Setenv("TEST_BATCH_BATCH_FORMAT", "xml")
+	assert.ErrorIs(t, cfg.load("test.batch"), notifyformat.ErrBadBatchFormat)
+}
+
+func TestNotifyLoadWebhookAllowPrivate(t *testing.T) {
+	t.Setenv("TEST_WEBHOOK_ADDRESS", "test")
+
+	var cfg Notify
+	require.NoError(t, cfg.load("test.webhook"))
+	assert.False(t, cfg.WebhookAllowPrivate)
+
+	t.Setenv("TEST_WEBHOOK_WEBHOOK_ALLOW_PRIVATE", "true")
+	require.NoError(t, cfg.load("test.webhook"))
+	assert.True(t, cfg.WebhookAllowPrivate)
+}
+
+func TestNotifyLoadWebhookMaxAttempts(t *testing.T) {
+	t.Setenv("TEST_HOOKS_ADDRESS", "test")
+
+	var cfg Notify
+	require.NoError(t, cfg.load("test.hooks"))
+	assert.Equal(t, 10, cfg.WebhookMaxAttempts)
+
+	t.Setenv("TEST_HOOKS_WEBHOOK_MAX_ATTEMPTS", "3")
+	require.NoError(t, cfg.load("test.hooks"))
+	assert.Equal(t, 3, cfg.WebhookMaxAttempts)
 }
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"net/url"
+	"regexp"
+)
+
+const redacted = "xxxxx"
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Redacted returns a copy of the config that is safe to print: tokens, secrets and the database password are masked
+func (c Config) Redacted() Config {
+	res := c
+
+	res.Server.Tokens = redactAll(c.Server.Tokens)
+	res.Notify.Secrets = redactAll(c.Notify.Secrets)
+
+	res.DB.DSN = redactDSN(c.DB.DSN)
+	if c.DB.ReplicaDSNs != nil {
+		res.DB.ReplicaDSNs = make([]string, len(c.DB.ReplicaDSNs))
+		for i, dsn := range c.DB.ReplicaDSNs {
+			res.DB.ReplicaDSNs[i] = redactDSN(dsn)
+		}
+	}
+
+	return res
+}
+
+func redactAll(secrets []string) []string {
+	if secrets == nil {
+		return nil
+	}
+
+	res := make([]string, len(secrets))
+	for i := range res {
+		res[i] = redacted
+	}
+
+	return res
+}
+
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Redacted()
+	}
+
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
@@ -1,6 +1,9 @@
 package config
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	ErrNoServerAddr = errors.New("no server address")
@@ -8,9 +11,17 @@ var (
 
 type Server struct {
 	Addr string
+	// Tokens are bearer tokens accepted by authenticated endpoints, empty leaves /ws open and disables the admin endpoints
+	Tokens []string
+	// AllowedOrigins may open a websocket besides the server's own origin
+	AllowedOrigins []string
+	// ValidateRequests rejects requests that don't match the OpenAPI spec
+	ValidateRequests bool
+	// ValidateResponses logs responses that don't match the OpenAPI spec
+	ValidateResponses bool
 }
 
-func (s *Server) load(envPrefix string) error {
+func (s *Server) Load(envPrefix string) error {
 	v := setupViper(envPrefix)
 
 	s.Addr = v.GetString("address")
@@ -18,5 +29,21 @@ func (s *Server) load(envPrefix string) error {
 		return ErrNoServerAddr
 	}
 
+	s.Tokens = splitList(v.GetString("tokens"))
+	s.AllowedOrigins = splitList(v.GetString("allowed_origins"))
+	s.ValidateRequests = v.GetBool("validate_requests")
+	s.ValidateResponses = v.GetBool("validate_responses")
+
 	return nil
 }
+
+func splitList(raw string) []string {
+	var res []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+
+	return res
+}
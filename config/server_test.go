@@ -12,9 +12,19 @@ func TestServerLoad(t *testing.T) {
 	t.Parallel()
 
 	var cfg Server
-	assert.EqualError(t, cfg.load("test"), ErrNoServerAddr.Error())
+	assert.EqualError(t, cfg.Load("test"), ErrNoServerAddr.Error())
 
 	require.NoError(t, os.Setenv("TEST_ADDRESS", "http://localhost/hello/there"))
-	require.NoError(t, cfg.load("test"))
+	require.NoError(t, cfg.Load("test"))
 	assert.Equal(t, "http://localhost/hello/there", cfg.Addr)
+	assert.Empty(t, cfg.Tokens)
+
+	require.NoError(t, os.Setenv("TEST_TOKENS", "first, second,,"))
+	require.NoError(t, cfg.Load("test"))
+	assert.Equal(t, []string{"first", "second"}, cfg.Tokens)
+	assert.Empty(t, cfg.AllowedOrigins)
+
+	require.NoError(t, os.Setenv("TEST_ALLOWED_ORIGINS", "https://dashboard.example.com"))
+	require.NoError(t, cfg.Load("test"))
+	assert.Equal(t, []string{"https://dashboard.example.com"}, cfg.AllowedOrigins)
 }
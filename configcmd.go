@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/andyklimenko/testify-usage-example/config"
+	"github.com/spf13/cobra"
+)
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+	}
+
+	printCmd := &cobra.Command{
+		Use:   "print",
+		Short: "Print the effective configuration with secrets redacted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			var cfg config.Config
+			if err := cfg.Load(); err != nil {
+				return withExitCode(exitConfig, err)
+			}
+
+			e := json.NewEncoder(cmd.OutOrStdout())
+			e.SetIndent("", "  ")
+			return e.Encode(cfg.Redacted())
+		},
+	}
+
+	cmd.AddCommand(printCmd)
+	return cmd
+}
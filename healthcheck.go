@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/entity"
+	"github.com/andyklimenko/testify-usage-example/config"
+	"github.com/spf13/cobra"
+)
+
+func newHealthcheckCmd() *cobra.Command {
+	var (
+		target  string
+		timeout time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "healthcheck",
+		Short: "Probe the readiness of a running server, exits non-zero when it's not ready",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if target == "" {
+				var cfg config.Server
+				if err := cfg.Load("server"); err != nil {
+					return withExitCode(exitConfig, fmt.Errorf("http server configuration: %w", err))
+				}
+				target = readinessURL(cfg.Addr)
+			}
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			defer cancel()
+
+			return withExitCode(exitUnavailable, probe(ctx, target))
+		},
+	}
+	cmd.Flags().StringVar(&target, "url", "", "readiness endpoint, derived from SERVER_ADDRESS by default")
+	cmd.Flags().DurationVar(&timeout, "timeout", 3*time.Second, "probe timeout")
+
+	return cmd
+}
+
+// readinessURL points to the local server listening on addr
+func readinessURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr + "/readyz"
+	}
+
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	return "http://" + net.JoinHostPort(host, port) + "/readyz"
+}
+
+func probe(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer entity.CloseBody(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s replied with %d", url, resp.StatusCode)
+	}
+
+	return nil
+}
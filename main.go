@@ -1,32 +1,66 @@
 package main
 
 import (
-	"github.com/andyklimenko/testify-usage-example/api"
-	"github.com/andyklimenko/testify-usage-example/api/external/changelog"
-	"github.com/andyklimenko/testify-usage-example/api/storage"
-	"github.com/andyklimenko/testify-usage-example/api/storage/database"
-	"github.com/andyklimenko/testify-usage-example/api/storage/migrations"
-	"github.com/andyklimenko/testify-usage-example/config"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
 )
 
-func main() {
-	var cfg config.Config
-	if err := cfg.Load(); err != nil {
-		panic(err)
+// exit codes follow sysexits.h where there's a fitting one
+const (
+	exitFailure     = 1
+	exitUnavailable = 69
+	exitConfig      = 78
+)
+
+// exitError carries the code the process should exit with
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
 	}
 
-	db, err := database.DbConnect(cfg.DB.Driver, cfg.DB.DSN)
-	if err != nil {
-		panic(err)
+	return &exitError{code: code, err: err}
+}
+
+func exitCode(err error) int {
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
 	}
 
-	if err := migrations.Up(db, cfg.DB.Driver); err != nil {
-		panic(err)
+	return exitFailure
+}
+
+func newRootCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "testify-usage-example",
+		Short:         "Users API server",
+		SilenceUsage:  true,
+		SilenceErrors: true,
 	}
 
-	changelogNotifySvc := changelog.New(cfg.Notify.Addr)
-	srv := api.New(cfg, storage.New(db), changelogNotifySvc)
-	if err := srv.Start(); err != nil {
-		panic(err)
+	root.AddCommand(newServeCmd(), newMigrateCmd(), newConfigCmd(), newHealthcheckCmd())
+	return root
+}
+
+func main() {
+	if err := newRootCmd().Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadinessURL(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "http://localhost:8080/readyz", readinessURL("0.0.0.0:8080"))
+	assert.Equal(t, "http://localhost:8080/readyz", readinessURL(":8080"))
+	assert.Equal(t, "http://10.0.0.1:8080/readyz", readinessURL("10.0.0.1:8080"))
+}
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, exitFailure, exitCode(errors.New("boom")))
+	assert.Equal(t, exitConfig, exitCode(fmt.Errorf("wrapped: %w", withExitCode(exitConfig, errors.New("no dsn")))))
+	assert.NoError(t, withExitCode(exitConfig, nil))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andyklimenko/testify-usage-example/api/storage/database"
+	"github.com/andyklimenko/testify-usage-example/api/storage/migrations"
+	"github.com/andyklimenko/testify-usage-example/config"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+)
+
+// withDB runs fn against the database from the storage configuration, nothing else is required to be set
+func withDB(fn func(db *sqlx.DB, cfg config.DB) error) error {
+	var cfg config.DB
+	if err := cfg.Load("storage"); err != nil {
+		return withExitCode(exitConfig, fmt.Errorf("storage configuration: %w", err))
+	}
+
+	db, err := database.Connect(context.Background(), cfg)
+	if err != nil {
+		return withExitCode(exitUnavailable, err)
+	}
+	defer db.Close()
+
+	return fn(db, cfg)
+}
+
+func migrationOptions(cfg config.DB) []migrations.Option {
+	return []migrations.Option{
+		migrations.WithDriftPolicy(migrations.DriftPolicy(cfg.MigrationDrift)),
+		migrations.WithLockWait(cfg.MigrationLockWait),
+		migrations.WithTimeouts(cfg.MigrationLockTimeout, cfg.MigrationStatementTimeout),
+	}
+}
+
+func newMigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage the database schema",
+	}
+
+	up := &cobra.Command{
+		Use:   "up",
+		Short: "Apply all pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withDB(func(db *sqlx.DB, cfg config.DB) error {
+				return migrations.Up(db, cfg.Driver, migrationOptions(cfg)...)
+			})
+		},
+	}
+
+	var steps int
+	down := &cobra.Command{
+		Use:   "down",
+		Short: "Roll back applied migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withDB(func(db *sqlx.DB, cfg config.DB) error {
+				n, err := migrations.Down(db, cfg.Driver, steps, migrationOptions(cfg)...)
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "rolled back %d migration(s)\n", n)
+				return nil
+			})
+		},
+	}
+	down.Flags().IntVar(&steps, "steps", 1, "number of migrations to roll back, 0 rolls back everything")
+
+	status := &cobra.Command{
+		Use:   "status",
+		Short: "Show applied and pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withDB(func(db *sqlx.DB, cfg config.DB) error {
+				statuses, err := migrations.Status(db, cfg.Driver)
+				if err != nil {
+					return err
+				}
+
+				tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "MIGRATION\tAPPLIED AT\tDRIFT")
+				for _, st := range statuses {
+					appliedAt := "pending"
+					if st.AppliedAt != nil {
+						appliedAt = st.AppliedAt.Format(time.RFC3339)
+					}
+					drift := "-"
+					if st.Drifted {
+						drift = "edited after apply"
+					}
+					fmt.Fprintf(tw, "%s\t%s\t%s\n", st.ID, appliedAt, drift)
+				}
+
+				return tw.Flush()
+			})
+		},
+	}
+
+	redo := &cobra.Command{
+		Use:   "redo",
+		Short: "Roll back the most recent migration and apply it again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withDB(func(db *sqlx.DB, cfg config.DB) error {
+				return migrations.Redo(db, cfg.Driver, migrationOptions(cfg)...)
+			})
+		},
+	}
+
+	cmd.AddCommand(up, down, status, redo)
+	return cmd
+}
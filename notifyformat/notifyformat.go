@@ -0,0 +1,58 @@
+// Package notifyformat names the shapes of user notifications, so the configuration and the encoder
+// agree on them without depending on each other.
+package notifyformat
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Format is the shape of a single notification
+type Format string
+
+const (
+	Legacy Format = "legacy"
+	// CloudEvents puts the whole event into the body
+	CloudEvents Format = "cloudevents"
+	// CloudEventsBinary puts the user into the body and the rest of the event into ce- headers
+	CloudEventsBinary Format = "cloudevents-binary"
+)
+
+// BatchFormat is how several notifications share one body
+type BatchFormat string
+
+const (
+	// BatchJSON puts notifications into a JSON array
+	BatchJSON BatchFormat = "json"
+	// BatchNDJSON puts every notification on its own line
+	BatchNDJSON BatchFormat = "ndjson"
+)
+
+var (
+	ErrBadFormat      = errors.New("bad notification format")
+	ErrBadBatchFormat = errors.New("bad notification batch format")
+)
+
+// Parse accepts an empty string as Legacy
+func Parse(raw string) (Format, error) {
+	switch f := Format(raw); f {
+	case "":
+		return Legacy, nil
+	case Legacy, CloudEvents, CloudEventsBinary:
+		return f, nil
+	}
+
+	return "", fmt.Errorf("%w %q", ErrBadFormat, raw)
+}
+
+// ParseBatch accepts an empty string as BatchJSON
+func ParseBatch(raw string) (BatchFormat, error) {
+	switch f := BatchFormat(raw); f {
+	case "":
+		return BatchJSON, nil
+	case BatchJSON, BatchNDJSON:
+		return f, nil
+	}
+
+	return "", fmt.Errorf("%w %q", ErrBadBatchFormat, raw)
+}
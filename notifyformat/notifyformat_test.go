@@ -0,0 +1,38 @@
+package notifyformat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	f, err := Parse("")
+	require.NoError(t, err)
+	assert.Equal(t, Legacy, f)
+
+	f, err = Parse("cloudevents-binary")
+	require.NoError(t, err)
+	assert.Equal(t, CloudEventsBinary, f)
+
+	_, err = Parse("xml")
+	assert.ErrorIs(t, err, ErrBadFormat)
+}
+
+func TestParseBatch(t *testing.T) {
+	t.Parallel()
+
+	f, err := ParseBatch("")
+	require.NoError(t, err)
+	assert.Equal(t, BatchJSON, f)
+
+	f, err = ParseBatch("ndjson")
+	require.NoError(t, err)
+	assert.Equal(t, BatchNDJSON, f)
+
+	_, err = ParseBatch("xml")
+	assert.ErrorIs(t, err, ErrBadBatchFormat)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/andyklimenko/testify-usage-example/api"
+	"github.com/andyklimenko/testify-usage-example/api/external/changelog"
+	"github.com/andyklimenko/testify-usage-example/api/external/notification"
+	"github.com/andyklimenko/testify-usage-example/api/storage"
+	"github.com/andyklimenko/testify-usage-example/api/storage/database"
+	"github.com/andyklimenko/testify-usage-example/api/storage/migrations"
+	"github.com/andyklimenko/testify-usage-example/config"
+	"github.com/jmoiron/sqlx"
+	"github.com/spf13/cobra"
+)
+
+func newServeCmd() *cobra.Command {
+	var skipMigrate bool
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the HTTP server",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			var cfg config.Config
+			if err := cfg.Load(); err != nil {
+				return withExitCode(exitConfig, err)
+			}
+
+			db, err := database.Connect(cmd.Context(), cfg.DB)
+			if err != nil {
+				return withExitCode(exitUnavailable, err)
+			}
+			defer db.Close()
+
+			if !skipMigrate {
+				if err := migrations.Up(db, cfg.DB.Driver, migrationOptions(cfg.DB)...); err != nil {
+					return fmt.Errorf("apply migrations: %w", err)
+				}
+			}
+
+			// a replica that is down is skipped until it's back, so it must not hold up the startup
+			var replicas []*sqlx.DB
+			for _, dsn := range cfg.DB.ReplicaDSNs {
+				replicaCfg := cfg.DB
+				replicaCfg.DSN = dsn
+				replica, err := database.Open(replicaCfg)
+				if err != nil {
+					return withExitCode(exitConfig, fmt.Errorf("replica: %w", err))
+				}
+				defer replica.Close()
+				replicas = append(replicas, replica)
+			}
+
+			changelogNotifySvc := changelog.New(cfg.Notify.Addr, changelog.WithRetry(changelog.RetryPolicy{
+				MaxAttempts:        cfg.Notify.RetryMaxAttempts,
+				BaseDelay:          cfg.Notify.RetryBaseDelay,
+				MaxDelay:           cfg.Notify.RetryMaxDelay,
+				Jitter:             cfg.Notify.RetryJitter,
+				RetryStatuses:      cfg.Notify.RetryStatuses,
+				RetryNetworkErrors: cfg.Notify.RetryNetworkErrors,
+			}), changelog.WithBreaker(changelog.BreakerPolicy{
+				FailureThreshold: cfg.Notify.BreakerFailureThreshold,
+				CoolDown:         cfg.Notify.BreakerCoolDown,
+				SuccessThreshold: cfg.Notify.BreakerSuccessThreshold,
+			}), changelog.WithSecrets(cfg.Notify.Secrets...), changelog.WithEncoder(notification.Encoder{
+				Format: cfg.Notify.Format,
+				Source: cfg.Notify.EventSource,
+			}), changelog.WithBatching(changelog.BatchPolicy{
+				MaxSize: cfg.Notify.BatchSize,
+				MaxWait: cfg.Notify.BatchMaxWait,
+				Format:  cfg.Notify.BatchFormat,
+			}))
+			repo := storage.New(db, storage.WithReplicas(replicas...), storage.WithWebhookMaxAttempts(cfg.Notify.WebhookMaxAttempts))
+			srv := api.New(cfg, repo, changelogNotifySvc)
+			return srv.Start()
+		},
+	}
+	cmd.Flags().BoolVar(&skipMigrate, "skip-migrate", false, "don't apply pending migrations at startup")
+
+	return cmd
+}
@@ -0,0 +1,194 @@
+// Package signature signs notifications of the users API and verifies them on the receiving side.
+//
+// Every notification carries three headers:
+//
+//	X-Delivery-ID: 1b4e28ba-2fa1-41d2-883f-0016d3cca427
+//	X-Signature-Timestamp: 1699178400
+//	X-Signature: v2=5257a869e7ecebeda32affa62cdca3fa51cad7e77a0e56ff536d0ce8e108d8bd
+//
+// The delivery ID is unique for every request, retries included. The timestamp is in Unix seconds.
+// Every v2 signature is a hex-encoded HMAC-SHA256 over the timestamp, a dot, the delivery ID, a dot
+// and the raw body, keyed with one of the secrets. While a secret is rotated there are two signatures
+// separated by a comma, one per active secret, and either of them is enough.
+// A receiver rejects notifications with a timestamp too far from its own clock, so a captured
+// notification can't be replayed later on. To stop a replay within the tolerance as well, a receiver
+// remembers the delivery IDs it has taken for as long as the tolerance and drops the ones it has seen.
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DeliveryIDHeader = "X-Delivery-ID"
+	TimestampHeader  = "X-Signature-Timestamp"
+	SignatureHeader  = "X-Signature"
+
+	// DefaultTolerance is how far the timestamp may be from the receiver's clock
+	DefaultTolerance = 5 * time.Minute
+
+	version = "v2"
+	// maxBody limits how much Middleware reads to verify a request
+	maxBody = 1 << 20
+)
+
+var (
+	ErrNoSignature  = errors.New("no signature")
+	ErrNoDeliveryID = errors.New("no delivery id")
+	ErrBadTimestamp = errors.New("bad signature timestamp")
+	ErrStale        = errors.New("signature timestamp is out of tolerance")
+	ErrBadSignature = errors.New("signature mismatch")
+	ErrReplayed     = errors.New("delivery id has been seen already")
+	ErrNoSecrets    = errors.New("no secrets to verify the signature with")
+	errBodyTooLarge = errors.New("body is too large")
+)
+
+// Sign returns values of TimestampHeader and SignatureHeader for the body delivered with the id,
+// one signature per secret. Empty secrets are skipped.
+func Sign(id string, body []byte, at time.Time, secrets ...string) (timestamp, signature string) {
+	timestamp = strconv.FormatInt(at.Unix(), 10)
+
+	sigs := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		sigs = append(sigs, version+"="+hex.EncodeToString(mac(secret, timestamp, id, body)))
+	}
+
+	return timestamp, strings.Join(sigs, ",")
+}
+
+// SignRequest sets the signature headers of the request with the body it's going to carry under a new delivery ID
+func SignRequest(r *http.Request, body []byte, at time.Time, secrets ...string) {
+	id := uuid.NewString()
+	timestamp, sig := Sign(id, body, at, secrets...)
+	if sig == "" {
+		return
+	}
+
+	r.Header.Set(DeliveryIDHeader, id)
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set(SignatureHeader, sig)
+}
+
+// Verifier checks signatures of notifications
+type Verifier struct {
+	// Secrets are the ones shared with the sender, the notification must be signed with any of them
+	Secrets []string
+	// Tolerance defaults to DefaultTolerance
+	Tolerance time.Duration
+	// Now defaults to time.Now
+	Now func() time.Time
+	// Seen, when set, is called with the delivery ID of every notification with a valid signature and tells whether
+	// the ID has come before, such a notification is a replay. It has to remember IDs for as long as Tolerance.
+	Seen func(id string) bool
+}
+
+// Verify checks the headers of a notification with the given body, the default Verifier is used.
+// It doesn't remember delivery IDs, that's up to the receiver.
+func Verify(h http.Header, body []byte, secrets ...string) error {
+	return Verifier{Secrets: secrets}.Verify(h, body)
+}
+
+func (v Verifier) Verify(h http.Header, body []byte) error {
+	timestamp, sigs := h.Get(TimestampHeader), h.Get(SignatureHeader)
+	if timestamp == "" || sigs == "" {
+		return ErrNoSignature
+	}
+	id := h.Get(DeliveryIDHeader)
+	if id == "" {
+		return ErrNoDeliveryID
+	}
+
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w %q", ErrBadTimestamp, timestamp)
+	}
+
+	now, tolerance := time.Now, DefaultTolerance
+	if v.Now != nil {
+		now = v.Now
+	}
+	if v.Tolerance > 0 {
+		tolerance = v.Tolerance
+	}
+
+	if d := now().Sub(time.Unix(secs, 0)); d > tolerance || d < -tolerance {
+		return ErrStale
+	}
+
+	var secrets int
+	for _, secret := range v.Secrets {
+		if secret == "" {
+			continue
+		}
+		secrets++
+
+		expected := mac(secret, timestamp, id, body)
+		for _, sig := range strings.Split(sigs, ",") {
+			ver, encoded, ok := strings.Cut(strings.TrimSpace(sig), "=")
+			if !ok || ver != version {
+				continue
+			}
+
+			got, err := hex.DecodeString(encoded)
+			if err == nil && hmac.Equal(got, expected) {
+				if v.Seen != nil && v.Seen(id) {
+					return ErrReplayed
+				}
+				return nil
+			}
+		}
+	}
+
+	if secrets == 0 {
+		return ErrNoSecrets
+	}
+
+	return ErrBadSignature
+}
+
+// Middleware rejects requests without a valid signature with 401, the body stays readable for next
+func (v Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+		if err == nil && len(body) > maxBody {
+			err = errBodyTooLarge
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := v.Verify(r.Header, body); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func mac(secret, timestamp, id string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte{'.'})
+	h.Write([]byte(id))
+	h.Write([]byte{'.'})
+	h.Write(body)
+	return h.Sum(nil)
+}
@@ -0,0 +1,119 @@
+package signature
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	signedAt   = time.Date(2023, 11, 5, 10, 0, 0, 0, time.UTC)
+	body       = []byte(`{"notification_type":"CREATED"}`)
+	deliveryID = "1b4e28ba-2fa1-41d2-883f-0016d3cca427"
+)
+
+func signed(secrets ...string) http.Header {
+	h := make(http.Header)
+	ts, sig := Sign(deliveryID, body, signedAt, secrets...)
+	h.Set(DeliveryIDHeader, deliveryID)
+	h.Set(TimestampHeader, ts)
+	h.Set(SignatureHeader, sig)
+	return h
+}
+
+func TestSign(t *testing.T) {
+	t.Parallel()
+
+	ts, sig := Sign(deliveryID, body, signedAt, "old", "", "new")
+	assert.Equal(t, "1699178400", ts)
+	require.Len(t, strings.Split(sig, ","), 2, "one signature per secret")
+	assert.True(t, strings.HasPrefix(sig, "v2="))
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	v := Verifier{Secrets: []string{"new", "old"}, Now: func() time.Time { return signedAt.Add(time.Minute) }}
+	assert.NoError(t, v.Verify(signed("new"), body))
+	assert.NoError(t, v.Verify(signed("old"), body), "the previous secret is still accepted")
+	assert.NoError(t, v.Verify(signed("newest", "new"), body), "the sender has rotated first")
+
+	assert.ErrorIs(t, v.Verify(signed("other"), body), ErrBadSignature)
+	assert.ErrorIs(t, v.Verify(signed("new"), []byte(`{"notification_type":"DELETED"}`)), ErrBadSignature)
+	assert.ErrorIs(t, v.Verify(http.Header{}, body), ErrNoSignature)
+	assert.ErrorIs(t, Verifier{}.Verify(signed("new"), body), ErrStale)
+	assert.ErrorIs(t, Verifier{Now: v.Now}.Verify(signed("new"), body), ErrNoSecrets)
+
+	h := signed("new")
+	h.Set(TimestampHeader, "yesterday")
+	assert.ErrorIs(t, v.Verify(h, body), ErrBadTimestamp)
+
+	// the timestamp is signed as well, so the notification can't be replayed with a fresh one
+	h = signed("new")
+	h.Set(TimestampHeader, "1699178460")
+	assert.ErrorIs(t, v.Verify(h, body), ErrBadSignature)
+
+	// so is the delivery ID, a replay can't pass for a new delivery
+	h = signed("new")
+	h.Set(DeliveryIDHeader, "a5d4b3f0-0b1e-4d0c-9f3a-6c1d2e3f4a5b")
+	assert.ErrorIs(t, v.Verify(h, body), ErrBadSignature)
+	h.Del(DeliveryIDHeader)
+	assert.ErrorIs(t, v.Verify(h, body), ErrNoDeliveryID)
+
+	v.Now = func() time.Time { return signedAt.Add(DefaultTolerance + time.Second) }
+	assert.ErrorIs(t, v.Verify(signed("new"), body), ErrStale)
+}
+
+func TestVerifySeen(t *testing.T) {
+	t.Parallel()
+
+	seen := make(map[string]bool)
+	v := Verifier{Secrets: []string{"secret"}, Now: func() time.Time { return signedAt }, Seen: func(id string) bool {
+		defer func() { seen[id] = true }()
+		return seen[id]
+	}}
+
+	assert.NoError(t, v.Verify(signed("secret"), body))
+	assert.ErrorIs(t, v.Verify(signed("secret"), body), ErrReplayed)
+	assert.ErrorIs(t, v.Verify(signed("other"), body), ErrBadSignature, "only valid signatures are remembered")
+}
+
+func TestSignRequest(t *testing.T) {
+	t.Parallel()
+
+	first := httptest.NewRequest(http.MethodPost, "/", nil)
+	SignRequest(first, body, time.Now(), "secret")
+	second := httptest.NewRequest(http.MethodPost, "/", nil)
+	SignRequest(second, body, time.Now(), "secret")
+
+	assert.NotEmpty(t, first.Header.Get(DeliveryIDHeader))
+	assert.NotEqual(t, first.Header.Get(DeliveryIDHeader), second.Header.Get(DeliveryIDHeader), "every request is a new delivery")
+	assert.NoError(t, Verify(first.Header, body, "secret"))
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	handler := Verifier{Secrets: []string{"secret"}}.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, got)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+	SignRequest(req, body, time.Now(), "secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}